@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -33,6 +34,9 @@ type Client struct {
 	hub            *Hub
 	conn           *websocket.Conn
 	cid            string
+	uid            string
+	sequences      map[string]int64
+	depths         map[string]*Depth
 	receive        chan *BlazeMessage
 	hubChannel     chan *EventResponse
 	clientResponse chan []byte
@@ -45,6 +49,8 @@ func NewClient(ctx context.Context, hub *Hub, conn *websocket.Conn, id string, c
 		hub:            hub,
 		conn:           conn,
 		cid:            id,
+		sequences:      make(map[string]int64),
+		depths:         make(map[string]*Depth),
 		receive:        make(chan *BlazeMessage, 64),
 		hubChannel:     make(chan *EventResponse, 81920),
 		hubResponse:    make(chan []byte, 1024),
@@ -101,14 +107,37 @@ func (client *Client) loopHubChannel(ctx context.Context) error {
 				if err != nil {
 					return err
 				}
+			case "SYNC_PENDING_EVENTS":
+				time.Sleep(100 * time.Millisecond)
+				err := client.syncPendingEvents(ctx, e.Channel, e.Sequence)
+				if err != nil {
+					return err
+				}
+			case "SEND_LAST_EVENT":
+				if e.Depth != nil {
+					client.depths[e.Channel] = e.Depth
+				}
+				err := client.sendLastEvent(ctx, e.Channel)
+				if err != nil {
+					return err
+				}
+			case "CLEAR_DEPTH":
+				delete(client.depths, e.Channel)
 			case "EMIT_EVENT":
-				id, _ := uuid.NewV4()
-				data, _ := json.Marshal(BlazeMessage{
-					Id:     id.String(),
-					Action: "EMIT_EVENT",
-					Data:   e.Event,
-				})
-				err := client.pipeHubResponse(ctx, data)
+				if last, found := client.sequences[e.Channel]; found {
+					if e.Event.Sequence <= last {
+						continue
+					}
+					if e.Event.Sequence > last+1 {
+						err := client.syncPendingEvents(ctx, e.Channel, last)
+						if err != nil {
+							return err
+						}
+						continue
+					}
+					client.sequences[e.Channel] = e.Event.Sequence
+				}
+				err := client.sendEvent(ctx, e.Channel, e.Event)
 				if err != nil {
 					return err
 				}
@@ -124,6 +153,23 @@ func (client *Client) sendPendingEvents(ctx context.Context, channel string) err
 	if err != nil {
 		return err
 	}
+	return client.sendEvents(ctx, events)
+}
+
+// syncPendingEvents sends the events after the sequence, or all the pending events from
+// the latest BOOK-T0 to resync the client, then the emitted events must follow the last one.
+func (client *Client) syncPendingEvents(ctx context.Context, channel string, sequence int64) error {
+	events, err := ListPendingEvents(ctx, channel)
+	if err != nil {
+		return err
+	}
+	if len(events) > 0 {
+		client.sequences[channel] = events[len(events)-1].Sequence
+	}
+	return client.sendEvents(ctx, ResumeEvents(events, sequence))
+}
+
+func (client *Client) sendEvents(ctx context.Context, events []*Event) error {
 	for _, e := range events {
 		id, _ := uuid.NewV4()
 		data, _ := json.Marshal(BlazeMessage{
@@ -131,7 +177,7 @@ func (client *Client) sendPendingEvents(ctx context.Context, channel string) err
 			Action: "EMIT_EVENT",
 			Data:   e,
 		})
-		err = client.pipeHubResponse(ctx, data)
+		err := client.pipeHubResponse(ctx, data)
 		if err != nil {
 			return err
 		}
@@ -139,6 +185,31 @@ func (client *Client) sendPendingEvents(ctx context.Context, channel string) err
 	return nil
 }
 
+func (client *Client) sendLastEvent(ctx context.Context, channel string) error {
+	event, err := LastEvent(ctx, channel)
+	if err != nil || event == nil {
+		return err
+	}
+	return client.sendEvent(ctx, channel, event)
+}
+
+func (client *Client) sendEvent(ctx context.Context, channel string, event *Event) error {
+	if depth, found := client.depths[channel]; found {
+		e, err := BookDepth(event, depth)
+		if err != nil {
+			return err
+		}
+		event = e
+	}
+	id, _ := uuid.NewV4()
+	data, _ := json.Marshal(BlazeMessage{
+		Id:     id.String(),
+		Action: "EMIT_EVENT",
+		Data:   event,
+	})
+	return client.pipeHubResponse(ctx, data)
+}
+
 func writeGzipToConn(ctx context.Context, conn *websocket.Conn, msg []byte) error {
 	err := conn.SetWriteDeadline(time.Now().Add(writeWait))
 	if err != nil {
@@ -220,15 +291,90 @@ func (client *Client) handleMessage(ctx context.Context, msg *BlazeMessage) erro
 	market := fmt.Sprint(msg.Params["market"])
 	switch msg.Action {
 	case "SUBSCRIBE_BOOK":
-		err = client.hub.SubscribePendingEvents(ctx, market, client.cid)
+		err = client.hub.SubscribePendingEvents(ctx, market, client.cid, paramInt(msg.Params, "sequence"))
+	case "SYNC_BOOK":
+		err = client.pipeHubChannel(ctx, &EventResponse{
+			Channel:  market + "-ORDER-EVENTS",
+			Source:   "SYNC_PENDING_EVENTS",
+			Sequence: paramInt(msg.Params, "sequence"),
+		})
 	case "UNSUBSCRIBE_BOOK":
 		err = client.hub.UnsubscribePendingEvents(ctx, market, client.cid)
 	case "SUBSCRIBE_TICKER":
+		err = client.hub.SubscribeTicker(ctx, market, client.cid)
 	case "UNSUBSCRIBE_TICKER":
+		err = client.hub.UnsubscribeTicker(ctx, market, client.cid)
+	case "AUTHENTICATE":
+		err = client.authenticate(ctx, fmt.Sprint(msg.Params["token"]))
+	case "SUBSCRIBE_USER":
+		if err = client.authenticated(); err == nil {
+			err = client.hub.SubscribeUserEvents(ctx, client.uid, client.cid)
+		}
+	case "UNSUBSCRIBE_USER":
+		if err = client.authenticated(); err == nil {
+			err = client.hub.UnsubscribeUserEvents(ctx, client.uid, client.cid)
+		}
+	case "SUBSCRIBE_DEPTH":
+		var typ string
+		var depth *Depth
+		typ, depth, err = ParseDepth(paramString(msg.Params, "level"), int(paramInt(msg.Params, "depth")), paramString(msg.Params, "tick"))
+		if err == nil {
+			err = client.hub.SubscribeDepth(ctx, market, typ, client.cid, depth)
+		}
+	case "UNSUBSCRIBE_DEPTH":
+		var typ string
+		typ, _, err = ParseDepth(paramString(msg.Params, "level"), 0, "")
+		if err == nil {
+			err = client.hub.UnsubscribeDepth(ctx, market, typ, client.cid)
+		}
+	case "SUBSCRIBE_TRADES":
+		err = client.hub.SubscribeTrades(ctx, market, client.cid)
+	case "UNSUBSCRIBE_TRADES":
+		err = client.hub.UnsubscribeTrades(ctx, market, client.cid)
 	}
 	return client.ack(ctx, msg.Action, msg.Id, err)
 }
 
+func paramInt(params map[string]interface{}, key string) int64 {
+	switch v := params[key].(type) {
+	case float64:
+		return int64(v)
+	case string:
+		i, _ := strconv.ParseInt(v, 10, 64)
+		return i
+	}
+	return 0
+}
+
+func paramString(params map[string]interface{}, key string) string {
+	if v, found := params[key]; found && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+func (client *Client) authenticate(ctx context.Context, token string) error {
+	userId, err := client.hub.authenticate(ctx, token)
+	if err != nil {
+		return err
+	}
+	if userId == "" {
+		return errors.New("unauthorized")
+	}
+	if client.uid != "" && client.uid != userId {
+		return errors.New("already authenticated")
+	}
+	client.uid = userId
+	return nil
+}
+
+func (client *Client) authenticated() error {
+	if client.uid == "" {
+		return errors.New("unauthorized")
+	}
+	return nil
+}
+
 func (client *Client) parseMessage(ctx context.Context, wsReader io.Reader) error {
 	var message BlazeMessage
 	gzReader, err := gzip.NewReader(wsReader)
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/MixinNetwork/go-number"
+)
+
+const (
+	DepthLevel1 = "L1"
+	DepthLevel2 = "L2"
+	DepthLevel3 = "L3"
+
+	EventTypeBookL2 = "BOOK-L2"
+	EventTypeBookL3 = "BOOK-L3"
+)
+
+type Depth struct {
+	Limit int
+	Tick  string
+}
+
+type BookEntry struct {
+	OrderId string `json:"order_id,omitempty"`
+	Side    string `json:"side"`
+	Price   string `json:"price"`
+	Amount  string `json:"amount"`
+	Funds   string `json:"funds"`
+}
+
+// ParseDepth returns the book snapshot type and the depth of the level, L1 is the best
+// price level of L2, and the orders of L3 are never grouped by the tick.
+func ParseDepth(level string, limit int, tick string) (string, *Depth, error) {
+	if limit < 0 {
+		return "", nil, fmt.Errorf("invalid depth %d", limit)
+	}
+	if tick != "" && !number.FromString(tick).IsPositive() {
+		return "", nil, fmt.Errorf("invalid depth tick %s", tick)
+	}
+	switch level {
+	case DepthLevel1:
+		return EventTypeBookL2, &Depth{Limit: 1}, nil
+	case "", DepthLevel2:
+		return EventTypeBookL2, &Depth{Limit: limit, Tick: tick}, nil
+	case DepthLevel3:
+		return EventTypeBookL3, &Depth{Limit: limit}, nil
+	}
+	return "", nil, fmt.Errorf("invalid depth level %s", level)
+}
+
+// BookDepth keeps the best price levels of the book snapshot up to the depth limit, all
+// the levels are kept if the limit is zero, and the prices are grouped by the tick multiple
+// before limited, the ask prices are rounded up and the bid prices are rounded down.
+func BookDepth(e *Event, depth *Depth) (*Event, error) {
+	var book struct {
+		Asks []*BookEntry `json:"asks"`
+		Bids []*BookEntry `json:"bids"`
+	}
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &book)
+	if err != nil {
+		return nil, err
+	}
+	tick := number.FromString(depth.Tick)
+	event := *e
+	event.Data = map[string]interface{}{
+		"asks": depthEntries(book.Asks, depth.Limit, tick, true),
+		"bids": depthEntries(book.Bids, depth.Limit, tick, false),
+	}
+	return &event, nil
+}
+
+func depthEntries(entries []*BookEntry, limit int, tick number.Decimal, up bool) []*BookEntry {
+	grouped := make([]*BookEntry, 0)
+	levels, last := 0, ""
+	for _, entry := range entries {
+		price := entry.Price
+		if tick.IsPositive() {
+			price = roundTick(number.FromString(price), tick, up).Persist()
+		}
+		if price != last {
+			if limit > 0 && levels == limit {
+				break
+			}
+			levels, last = levels+1, price
+		} else if tick.IsPositive() {
+			level := grouped[len(grouped)-1]
+			level.Amount = number.FromString(level.Amount).Add(number.FromString(entry.Amount)).Persist()
+			level.Funds = number.FromString(level.Funds).Add(number.FromString(entry.Funds)).Persist()
+			continue
+		}
+		grouped = append(grouped, &BookEntry{
+			OrderId: entry.OrderId,
+			Side:    entry.Side,
+			Price:   price,
+			Amount:  entry.Amount,
+			Funds:   entry.Funds,
+		})
+	}
+	return grouped
+}
+
+func roundTick(price, tick number.Decimal, up bool) number.Decimal {
+	rounded := price.Div(tick).RoundFloor(0).Mul(tick)
+	if up && rounded.Cmp(price) < 0 {
+		rounded = rounded.Add(tick)
+	}
+	return rounded
+}
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDepth(t *testing.T) {
+	assert := assert.New(t)
+
+	typ, depth, err := ParseDepth("", 0, "")
+	assert.Nil(err)
+	assert.Equal(EventTypeBookL2, typ)
+	assert.Equal(&Depth{}, depth)
+	typ, depth, err = ParseDepth(DepthLevel1, 20, "0.1")
+	assert.Nil(err)
+	assert.Equal(EventTypeBookL2, typ)
+	assert.Equal(&Depth{Limit: 1}, depth)
+	typ, depth, err = ParseDepth(DepthLevel2, 20, "0.1")
+	assert.Nil(err)
+	assert.Equal(&Depth{Limit: 20, Tick: "0.1"}, depth)
+	typ, depth, err = ParseDepth(DepthLevel3, 5, "0.1")
+	assert.Nil(err)
+	assert.Equal(EventTypeBookL3, typ)
+	assert.Equal(&Depth{Limit: 5}, depth)
+
+	_, _, err = ParseDepth("L4", 0, "")
+	assert.NotNil(err)
+	_, _, err = ParseDepth(DepthLevel2, -1, "")
+	assert.NotNil(err)
+	_, _, err = ParseDepth(DepthLevel2, 5, "-0.1")
+	assert.NotNil(err)
+	_, _, err = ParseDepth(DepthLevel2, 5, "tick")
+	assert.NotNil(err)
+}
+
+func TestBookDepth(t *testing.T) {
+	assert := assert.New(t)
+
+	book := &Event{
+		Market:   "market",
+		Type:     EventTypeBookL2,
+		Sequence: 100,
+		Data: map[string]interface{}{
+			"asks": []interface{}{
+				map[string]interface{}{"side": "ASK", "price": "1.01", "amount": "1", "funds": "1.01"},
+				map[string]interface{}{"side": "ASK", "price": "1.05", "amount": "2", "funds": "2.1"},
+				map[string]interface{}{"side": "ASK", "price": "1.1", "amount": "1", "funds": "1.1"},
+				map[string]interface{}{"side": "ASK", "price": "1.23", "amount": "1", "funds": "1.23"},
+			},
+			"bids": []interface{}{
+				map[string]interface{}{"side": "BID", "price": "0.99", "amount": "1", "funds": "0.99"},
+				map[string]interface{}{"side": "BID", "price": "0.9", "amount": "2", "funds": "1.8"},
+			},
+		},
+	}
+
+	e, err := BookDepth(book, &Depth{})
+	assert.Nil(err)
+	assert.Equal(int64(100), e.Sequence)
+	assert.Len(e.Data["asks"], 4)
+	assert.Len(e.Data["bids"], 2)
+	assert.Len(book.Data["asks"], 4)
+
+	e, err = BookDepth(book, &Depth{Limit: 1})
+	assert.Nil(err)
+	assert.Equal([]*BookEntry{{Side: "ASK", Price: "1.01", Amount: "1", Funds: "1.01"}}, e.Data["asks"])
+	assert.Equal([]*BookEntry{{Side: "BID", Price: "0.99", Amount: "1", Funds: "0.99"}}, e.Data["bids"])
+
+	e, err = BookDepth(book, &Depth{Tick: "0.1"})
+	assert.Nil(err)
+	assert.Equal([]*BookEntry{
+		{Side: "ASK", Price: "1.1", Amount: "4", Funds: "4.21"},
+		{Side: "ASK", Price: "1.3", Amount: "1", Funds: "1.23"},
+	}, e.Data["asks"])
+	assert.Equal([]*BookEntry{{Side: "BID", Price: "0.9", Amount: "3", Funds: "2.79"}}, e.Data["bids"])
+
+	e, err = BookDepth(book, &Depth{Limit: 1, Tick: "0.5"})
+	assert.Nil(err)
+	assert.Equal([]*BookEntry{{Side: "ASK", Price: "1.5", Amount: "5", Funds: "5.44"}}, e.Data["asks"])
+	assert.Equal([]*BookEntry{{Side: "BID", Price: "0.5", Amount: "3", Funds: "2.79"}}, e.Data["bids"])
+
+	orders := &Event{
+		Type: EventTypeBookL3,
+		Data: map[string]interface{}{
+			"asks": []interface{}{
+				map[string]interface{}{"order_id": "o1", "side": "ASK", "price": "1.01", "amount": "1", "funds": "1.01"},
+				map[string]interface{}{"order_id": "o2", "side": "ASK", "price": "1.01", "amount": "2", "funds": "2.02"},
+				map[string]interface{}{"order_id": "o3", "side": "ASK", "price": "1.05", "amount": "2", "funds": "2.1"},
+			},
+			"bids": []interface{}{},
+		},
+	}
+	e, err = BookDepth(orders, &Depth{Limit: 1})
+	assert.Nil(err)
+	assert.Equal([]*BookEntry{
+		{OrderId: "o1", Side: "ASK", Price: "1.01", Amount: "1", Funds: "1.01"},
+		{OrderId: "o2", Side: "ASK", Price: "1.01", Amount: "2", Funds: "2.02"},
+	}, e.Data["asks"])
+	assert.Len(e.Data["bids"], 0)
+}
@@ -11,8 +11,11 @@ import (
 const registerWait = 10 * time.Second
 
 type Subscription struct {
-	channel string
-	cid     string
+	channel  string
+	cid      string
+	source   string
+	sequence int64
+	depth    *Depth
 }
 
 type Member struct {
@@ -21,31 +24,42 @@ type Member struct {
 }
 
 type EventResponse struct {
-	Channel string
-	Source  string
-	Event   *Event
+	Channel  string
+	Source   string
+	Event    *Event
+	Sequence int64
+	Depth    *Depth
 }
 
+type AuthenticateFunc func(ctx context.Context, token string) (string, error)
+
 type Hub struct {
-	register    chan *Client
-	unregister  chan *Client
-	subscribe   chan *Subscription
-	unsubscribe chan *Subscription
-	response    chan *EventResponse
+	authenticate AuthenticateFunc
+	register     chan *Client
+	unregister   chan *Client
+	subscribe    chan *Subscription
+	unsubscribe  chan *Subscription
+	response     chan *EventResponse
 }
 
-func NewHub() *Hub {
+func NewHub(authenticate AuthenticateFunc) *Hub {
 	return &Hub{
-		register:    make(chan *Client),
-		unregister:  make(chan *Client),
-		subscribe:   make(chan *Subscription, 64),
-		unsubscribe: make(chan *Subscription, 64),
-		response:    make(chan *EventResponse, 8192),
+		authenticate: authenticate,
+		register:     make(chan *Client),
+		unregister:   make(chan *Client),
+		subscribe:    make(chan *Subscription, 64),
+		unsubscribe:  make(chan *Subscription, 64),
+		response:     make(chan *EventResponse, 8192),
 	}
 }
 
 func (hub *Hub) Run(ctx context.Context) error {
-	go hub.loopPendingEvents(ctx)
+	go hub.loopEvents(ctx, "ORDER-EVENTS")
+	go hub.loopEvents(ctx, EventTypeTicker)
+	go hub.loopEvents(ctx, "TRADES")
+	go hub.loopEvents(ctx, "USER-EVENTS")
+	go hub.loopEvents(ctx, EventTypeBookL2)
+	go hub.loopEvents(ctx, EventTypeBookL3)
 	members := make(map[string]*Member)
 	channels := make(map[string]map[string]time.Time)
 
@@ -68,14 +82,17 @@ func (hub *Hub) Run(ctx context.Context) error {
 				channels[sub.channel] = make(map[string]time.Time)
 			}
 			if member, found := members[sub.cid]; found {
-				if _, found := member.channels[sub.channel]; found {
+				// a subscribed depth channel is subscribed again only to change its depth
+				if _, found := member.channels[sub.channel]; found && sub.depth == nil {
 					continue
 				}
 				channels[sub.channel][sub.cid] = time.Now()
 				member.channels[sub.channel] = time.Now()
 				err := member.client.pipeHubChannel(ctx, &EventResponse{
-					Channel: sub.channel,
-					Source:  "LIST_PENDING_EVENTS",
+					Channel:  sub.channel,
+					Source:   sub.source,
+					Sequence: sub.sequence,
+					Depth:    sub.depth,
 				})
 				if err != nil {
 					log.Println("hub subscribe", err)
@@ -85,6 +102,13 @@ func (hub *Hub) Run(ctx context.Context) error {
 		case sub := <-hub.unsubscribe:
 			if member, found := members[sub.cid]; found {
 				delete(member.channels, sub.channel)
+				if sub.source != "" {
+					err := member.client.pipeHubChannel(ctx, &EventResponse{Channel: sub.channel, Source: sub.source})
+					if err != nil {
+						log.Println("hub unsubscribe", err)
+						member.client.cancel()
+					}
+				}
 			}
 			if channel, found := channels[sub.channel]; found {
 				delete(channel, sub.cid)
@@ -127,9 +151,9 @@ func (hub *Hub) Unregister(client *Client) error {
 	return nil
 }
 
-func (hub *Hub) SubscribePendingEvents(ctx context.Context, market, cid string) error {
+func (hub *Hub) SubscribePendingEvents(ctx context.Context, market, cid string, sequence int64) error {
 	select {
-	case hub.subscribe <- &Subscription{market + "-ORDER-EVENTS", cid}:
+	case hub.subscribe <- &Subscription{market + "-ORDER-EVENTS", cid, "SYNC_PENDING_EVENTS", sequence, nil}:
 	case <-time.After(registerWait):
 		return fmt.Errorf("timeout to subscribe pending events %s %s", market, cid)
 	}
@@ -138,20 +162,92 @@ func (hub *Hub) SubscribePendingEvents(ctx context.Context, market, cid string)
 
 func (hub *Hub) UnsubscribePendingEvents(ctx context.Context, market, cid string) error {
 	select {
-	case hub.unsubscribe <- &Subscription{market + "-ORDER-EVENTS", cid}:
+	case hub.unsubscribe <- &Subscription{market + "-ORDER-EVENTS", cid, "", 0, nil}:
 	case <-time.After(registerWait):
 		return fmt.Errorf("timeout to unsubscribe pending events %s %s", market, cid)
 	}
 	return nil
 }
 
-func (hub *Hub) loopPendingEvents(ctx context.Context) {
-	pubsub := Redis(ctx).Subscribe("ORDER-EVENTS")
+func (hub *Hub) SubscribeTicker(ctx context.Context, market, cid string) error {
+	select {
+	case hub.subscribe <- &Subscription{market + "-" + EventTypeTicker, cid, "SEND_LAST_EVENT", 0, nil}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to subscribe ticker %s %s", market, cid)
+	}
+	return nil
+}
+
+func (hub *Hub) UnsubscribeTicker(ctx context.Context, market, cid string) error {
+	select {
+	case hub.unsubscribe <- &Subscription{market + "-" + EventTypeTicker, cid, "", 0, nil}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to unsubscribe ticker %s %s", market, cid)
+	}
+	return nil
+}
+
+func (hub *Hub) SubscribeTrades(ctx context.Context, market, cid string) error {
+	select {
+	case hub.subscribe <- &Subscription{market + "-TRADES", cid, "LIST_PENDING_EVENTS", 0, nil}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to subscribe trades %s %s", market, cid)
+	}
+	return nil
+}
+
+func (hub *Hub) UnsubscribeTrades(ctx context.Context, market, cid string) error {
+	select {
+	case hub.unsubscribe <- &Subscription{market + "-TRADES", cid, "", 0, nil}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to unsubscribe trades %s %s", market, cid)
+	}
+	return nil
+}
+
+func (hub *Hub) SubscribeDepth(ctx context.Context, market, typ, cid string, depth *Depth) error {
+	select {
+	case hub.subscribe <- &Subscription{market + "-" + typ, cid, "SEND_LAST_EVENT", 0, depth}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to subscribe depth %s %s", market, cid)
+	}
+	return nil
+}
+
+func (hub *Hub) UnsubscribeDepth(ctx context.Context, market, typ, cid string) error {
+	select {
+	case hub.unsubscribe <- &Subscription{market + "-" + typ, cid, "CLEAR_DEPTH", 0, nil}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to unsubscribe depth %s %s", market, cid)
+	}
+	return nil
+}
+
+func (hub *Hub) SubscribeUserEvents(ctx context.Context, userId, cid string) error {
+	select {
+	case hub.subscribe <- &Subscription{userId + "-USER-EVENTS", cid, "", 0, nil}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to subscribe user events %s %s", userId, cid)
+	}
+	return nil
+}
+
+func (hub *Hub) UnsubscribeUserEvents(ctx context.Context, userId, cid string) error {
+	select {
+	case hub.unsubscribe <- &Subscription{userId + "-USER-EVENTS", cid, "", 0, nil}:
+	case <-time.After(registerWait):
+		return fmt.Errorf("timeout to unsubscribe user events %s %s", userId, cid)
+	}
+	return nil
+}
+
+func (hub *Hub) loopEvents(ctx context.Context, topic string) {
+	pubsub := Redis(ctx).Subscribe(topic)
 
 	for {
 		msg, err := pubsub.ReceiveMessage()
 		if err != nil {
-			log.Println("loopPendingEvents", err)
+			log.Println("loopEvents", topic, err)
 			time.Sleep(300 * time.Millisecond)
 			continue
 		}
@@ -160,6 +256,14 @@ func (hub *Hub) loopPendingEvents(ctx context.Context) {
 		if err != nil {
 			log.Panicln(err)
 		}
-		hub.response <- &EventResponse{event.Market + "-ORDER-EVENTS", "EMIT_EVENT", &event}
+		hub.response <- eventResponse(topic, &event)
+	}
+}
+
+func eventResponse(topic string, event *Event) *EventResponse {
+	channel := event.Market + "-" + topic
+	if event.UserId != "" {
+		channel = event.UserId + "-" + topic
 	}
+	return &EventResponse{channel, "EMIT_EVENT", event, 0, nil}
 }
@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/ocean.one/config"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHubSubscribeDepth(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := SetupRedis(context.Background(), redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 100 * time.Millisecond,
+	}))
+	hub := NewHub(nil)
+	go hub.Run(ctx)
+	client := &Client{
+		cid:        "client",
+		hubChannel: make(chan *EventResponse, 8),
+		cancel:     func() {},
+	}
+	receive := func() *EventResponse {
+		select {
+		case e := <-client.hubChannel:
+			return e
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	assert.Nil(hub.Register(ctx, client))
+	assert.Nil(hub.SubscribeDepth(ctx, "market", EventTypeBookL2, client.cid, &Depth{Limit: 5}))
+	e := receive()
+	assert.NotNil(e)
+	assert.Equal("market-BOOK-L2", e.Channel)
+	assert.Equal("SEND_LAST_EVENT", e.Source)
+	assert.Equal(5, e.Depth.Limit)
+
+	assert.Nil(hub.SubscribeDepth(ctx, "market", EventTypeBookL2, client.cid, &Depth{Limit: 10, Tick: "0.1"}))
+	e = receive()
+	assert.NotNil(e)
+	assert.Equal("SEND_LAST_EVENT", e.Source)
+	assert.Equal(10, e.Depth.Limit)
+	assert.Equal("0.1", e.Depth.Tick)
+
+	assert.Nil(hub.SubscribeTicker(ctx, "market", client.cid))
+	assert.NotNil(receive())
+	assert.Nil(hub.SubscribeTicker(ctx, "market", client.cid))
+	assert.Nil(receive())
+
+	assert.Nil(hub.UnsubscribeDepth(ctx, "market", EventTypeBookL2, client.cid))
+	e = receive()
+	assert.NotNil(e)
+	assert.Equal("market-BOOK-L2", e.Channel)
+	assert.Equal("CLEAR_DEPTH", e.Source)
+	assert.Nil(hub.UnsubscribeTicker(ctx, "market", client.cid))
+	assert.Nil(receive())
+}
+
+func TestHubUserEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := SetupRedis(context.Background(), redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 100 * time.Millisecond,
+	}))
+	hub := NewHub(func(ctx context.Context, token string) (string, error) {
+		switch token {
+		case "owner", "other":
+			return token + "-uid", nil
+		case "invalid":
+			return "", nil
+		}
+		return "", errors.New("authenticate " + token)
+	})
+	go hub.Run(ctx)
+	receive := func(client *Client) *EventResponse {
+		select {
+		case e := <-client.hubChannel:
+			return e
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+	send := func(client *Client, action string, params map[string]interface{}) string {
+		assert.Nil(client.handleMessage(ctx, &BlazeMessage{Id: action, Action: action, Params: params}))
+		var msg BlazeMessage
+		assert.Nil(json.Unmarshal(<-client.clientResponse, &msg))
+		assert.Equal(action, msg.Id)
+		return msg.Error
+	}
+
+	owner, _ := NewClient(ctx, hub, nil, "owner-client", func() {})
+	other, _ := NewClient(ctx, hub, nil, "other-client", func() {})
+	assert.Nil(hub.Register(ctx, owner))
+	assert.Nil(hub.Register(ctx, other))
+
+	assert.Equal("unauthorized", send(owner, "SUBSCRIBE_USER", nil))
+	assert.Equal("unauthorized", send(owner, "UNSUBSCRIBE_USER", nil))
+	assert.Equal("unauthorized", send(owner, "AUTHENTICATE", map[string]interface{}{"token": "invalid"}))
+	assert.Equal("authenticate expired", send(owner, "AUTHENTICATE", map[string]interface{}{"token": "expired"}))
+	assert.Equal("unauthorized", send(owner, "SUBSCRIBE_USER", nil))
+	assert.Nil(receive(owner))
+
+	assert.Equal("", send(owner, "AUTHENTICATE", map[string]interface{}{"token": "owner"}))
+	assert.Equal("already authenticated", send(owner, "AUTHENTICATE", map[string]interface{}{"token": "other"}))
+	assert.Equal("", send(owner, "SUBSCRIBE_USER", nil))
+	e := receive(owner)
+	assert.NotNil(e)
+	assert.Equal("owner-uid-USER-EVENTS", e.Channel)
+	assert.Equal("", send(other, "AUTHENTICATE", map[string]interface{}{"token": "other"}))
+	assert.Equal("", send(other, "SUBSCRIBE_USER", nil))
+	assert.NotNil(receive(other))
+
+	fill := &Event{UserId: "owner-uid", Market: "market", Type: EventTypeOrderFill, Data: map[string]interface{}{"order_id": "order"}}
+	hub.response <- eventResponse("USER-EVENTS", fill)
+	e = receive(owner)
+	assert.NotNil(e)
+	assert.Equal("owner-uid-USER-EVENTS", e.Channel)
+	assert.Equal("EMIT_EVENT", e.Source)
+	assert.Equal(fill, e.Event)
+	assert.Nil(receive(other))
+
+	assert.Equal("", send(owner, "UNSUBSCRIBE_USER", nil))
+	hub.response <- eventResponse("USER-EVENTS", fill)
+	assert.Nil(receive(owner))
+	assert.Nil(receive(other))
+}
+
+func TestHubTrades(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := testSetupRedis(t)
+	hub := NewHub(nil)
+	go hub.Run(ctx)
+	client, _ := NewClient(ctx, hub, nil, "client", func() {})
+	assert.Nil(hub.Register(ctx, client))
+	receive := func() *EventResponse {
+		select {
+		case e := <-client.hubChannel:
+			return e
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	market := "trades-market"
+	assert.Nil(Redis(ctx).Del(market + "-TRADES").Err())
+	queue := NewQueue(ctx, market)
+	for i := 0; i < TradesReplayLimit+5; i++ {
+		e := &Event{Market: market, Type: EventTypeTrade, Data: map[string]interface{}{"trade_id": fmt.Sprint(i)}, Timestamp: time.Now()}
+		assert.Nil(queue.handleEvent(ctx, e))
+	}
+
+	assert.Nil(hub.SubscribeTrades(ctx, market, client.cid))
+	e := receive()
+	assert.NotNil(e)
+	assert.Equal(market+"-TRADES", e.Channel)
+	assert.Equal("LIST_PENDING_EVENTS", e.Source)
+	assert.Nil(client.sendPendingEvents(ctx, e.Channel))
+	assert.Len(client.hubResponse, TradesReplayLimit)
+	for i := 0; i < TradesReplayLimit; i++ {
+		var msg struct{ Data *Event }
+		assert.Nil(json.Unmarshal(<-client.hubResponse, &msg))
+		assert.Equal(EventTypeTrade, msg.Data.Type)
+		assert.Equal(fmt.Sprint(i+5), msg.Data.Data["trade_id"])
+	}
+
+	// the TRADES topic may be subscribed by the hub a bit later
+	var emitted *EventResponse
+	for i := 0; i < 10 && emitted == nil; i++ {
+		trade := &Event{Market: market, Type: EventTypeTrade, Data: map[string]interface{}{"trade_id": "last"}, Timestamp: time.Now()}
+		assert.Nil(queue.handleEvent(ctx, trade))
+		emitted = receive()
+	}
+	assert.NotNil(emitted)
+	assert.Equal(market+"-TRADES", emitted.Channel)
+	assert.Equal("EMIT_EVENT", emitted.Source)
+	assert.Equal("last", emitted.Event.Data["trade_id"])
+	events, err := ListPendingEvents(ctx, market+"-TRADES")
+	assert.Nil(err)
+	assert.Len(events, TradesReplayLimit)
+
+	assert.Nil(hub.UnsubscribeTrades(ctx, market, client.cid))
+	assert.Nil(queue.handleEvent(ctx, &Event{Market: market, Type: EventTypeTrade, Timestamp: time.Now()}))
+	assert.Nil(receive())
+}
+
+func testSetupRedis(t *testing.T) context.Context {
+	ctx := SetupRedis(context.Background(), redis.NewClient(&redis.Options{
+		Addr: config.RedisEngineCacheAddress,
+		DB:   config.RedisEngineCacheDatabase,
+	}))
+	if err := Redis(ctx).Ping().Err(); err != nil {
+		t.Skip("redis unavailable", err)
+	}
+	return ctx
+}
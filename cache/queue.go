@@ -14,12 +14,23 @@ const (
 	EventTypeOrderOpen   = "ORDER-OPEN"
 	EventTypeOrderMatch  = "ORDER-MATCH"
 	EventTypeOrderCancel = "ORDER-CANCEL"
+
+	EventTypeOrderSelfTrade = "ORDER-SELF-TRADE"
+	EventTypeOrderAmend     = "ORDER-AMEND"
+
+	EventTypeBookDelta = "BOOK-DELTA"
+
+	EventTypeTicker = "TICKER"
+	EventTypeTrade  = "TRADE"
+
+	TradesReplayLimit = 100
 )
 
 type Event struct {
+	UserId    string                 `json:"user_id,omitempty"`
 	Market    string                 `json:"market"`
 	Type      string                 `json:"event"`
-	Sequence  string                 `json:"sequence"`
+	Sequence  int64                  `json:"sequence"`
 	Data      map[string]interface{} `json:"data,omitempty"`
 	Timestamp time.Time              `json:"timestamp"`
 }
@@ -47,6 +58,20 @@ func ListPendingEvents(ctx context.Context, key string) ([]*Event, error) {
 	return events, nil
 }
 
+// ResumeEvents returns the pending events after the sequence, the pending events start
+// with the latest BOOK-T0, so they are all returned when the sequence is not covered.
+func ResumeEvents(events []*Event, sequence int64) []*Event {
+	if len(events) == 0 || sequence < events[0].Sequence || sequence > events[len(events)-1].Sequence {
+		return events
+	}
+	for i, e := range events {
+		if e.Sequence > sequence {
+			return events[i:]
+		}
+	}
+	return nil
+}
+
 func Book(ctx context.Context, market string, limit int) (*Event, error) {
 	key := fmt.Sprintf("%s-BOOK-T%d", market, limit)
 	data, err := Redis(ctx).Get(key).Result()
@@ -58,11 +83,27 @@ func Book(ctx context.Context, market string, limit int) (*Event, error) {
 	return &e, err
 }
 
+func Ticker(ctx context.Context, market string) (*Event, error) {
+	return LastEvent(ctx, market+"-"+EventTypeTicker)
+}
+
+func LastEvent(ctx context.Context, key string) (*Event, error) {
+	data, err := Redis(ctx).Get(key).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var e Event
+	err = json.Unmarshal([]byte(data), &e)
+	return &e, err
+}
+
 func NewQueue(ctx context.Context, market string) *Queue {
 	base, _ := time.Parse(time.RFC3339Nano, "2017-07-07T07:07:07.777777777Z")
 	return &Queue{
 		market:   market,
-		sequence: time.Now().UnixNano() - base.UnixNano(),
+		sequence: (time.Now().UnixNano() - base.UnixNano()) / 1000,
 		events:   make(chan *Event, 8192),
 	}
 }
@@ -71,8 +112,11 @@ func (queue *Queue) Loop(ctx context.Context) {
 	for {
 		select {
 		case e := <-queue.events:
-			err := queue.handleEvent(ctx, e)
-			if err != nil {
+			for {
+				err := queue.handleEvent(ctx, e)
+				if err == nil {
+					break
+				}
 				log.Println("cache queue loop error", err)
 				time.Sleep(1 * time.Second)
 			}
@@ -81,7 +125,7 @@ func (queue *Queue) Loop(ctx context.Context) {
 }
 
 func (queue *Queue) handleEvent(ctx context.Context, e *Event) error {
-	e.Sequence = fmt.Sprint(queue.sequence)
+	e.Sequence = queue.sequence
 	data, err := json.Marshal(e)
 	if err != nil {
 		log.Panicln(err)
@@ -90,39 +134,67 @@ func (queue *Queue) handleEvent(ctx context.Context, e *Event) error {
 		_, err := Redis(ctx).Set(queue.market+"-BOOK-T1", data, 0).Result()
 		return err
 	}
+	if e.Type == EventTypeTrade {
+		key := queue.market + "-TRADES"
+		_, err := Redis(ctx).Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.RPush(key, data)
+			pipe.LTrim(key, -TradesReplayLimit, -1)
+			pipe.Publish("TRADES", data)
+			return nil
+		})
+		return err
+	}
+	if e.Type == EventTypeBookL2 || e.Type == EventTypeBookL3 {
+		e.Sequence = queue.sequence - 1
+		data, _ = json.Marshal(e)
+		_, err := Redis(ctx).Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.Set(queue.market+"-"+e.Type, data, 0)
+			pipe.Publish(e.Type, data)
+			return nil
+		})
+		return err
+	}
+	if e.Type == EventTypeTicker {
+		_, err := Redis(ctx).Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.Set(queue.market+"-"+EventTypeTicker, data, 0)
+			pipe.Publish(EventTypeTicker, data)
+			return nil
+		})
+		return err
+	}
 
-	queue.sequence = queue.sequence + 1
-
+	// the sequence is only advanced once the event is pushed, so a failed event is retried
+	// with the same sequence and never leaves a gap in the pending events
 	key := queue.market + "-ORDER-EVENTS"
 	switch e.Type {
-	case EventTypeOrderOpen, EventTypeOrderMatch, EventTypeOrderCancel:
-		_, err := Redis(ctx).RPush(key, data).Result()
-		if err != nil {
-			return err
-		}
-	case "BOOK-T0":
-		_, err := Redis(ctx).Pipelined(func(pipe redis.Pipeliner) error {
-			pipe.Del(key)
+	case EventTypeOrderOpen, EventTypeOrderMatch, EventTypeOrderCancel, EventTypeOrderSelfTrade, EventTypeOrderAmend, EventTypeBookDelta:
+		_, err = Redis(ctx).TxPipelined(func(pipe redis.Pipeliner) error {
 			pipe.RPush(key, data)
-			pipe.Set(queue.market+"-BOOK-T0", data, 0)
+			pipe.Publish("ORDER-EVENTS", data)
 			return nil
 		})
-		if err != nil {
-			return err
-		}
-		data, _ = json.Marshal(Event{
+	case "BOOK-T0":
+		heartbeat, _ := json.Marshal(Event{
 			Market:    queue.market,
 			Type:      "HEARTBEAT",
 			Sequence:  e.Sequence,
 			Timestamp: e.Timestamp,
 		})
+		_, err = Redis(ctx).TxPipelined(func(pipe redis.Pipeliner) error {
+			pipe.Del(key)
+			pipe.RPush(key, data)
+			pipe.Set(queue.market+"-BOOK-T0", data, 0)
+			pipe.Publish("ORDER-EVENTS", heartbeat)
+			return nil
+		})
 	default:
-		return fmt.Errorf("unsupported queue type %s", e.Type)
+		log.Panicln("unsupported queue type", e.Type)
 	}
-
-	_, err = Redis(ctx).Publish("ORDER-EVENTS", data).Result()
-	return err
-
+	if err != nil {
+		return err
+	}
+	queue.sequence = queue.sequence + 1
+	return nil
 }
 
 func (queue *Queue) AttachEvent(ctx context.Context, typ string, data map[string]interface{}) {
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventSequence(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := json.Marshal(Event{Market: "market", Type: EventTypeBookDelta, Sequence: 1531142594})
+	assert.Nil(err)
+	assert.Contains(string(data), `"sequence":1531142594`)
+	var e Event
+	assert.Nil(json.Unmarshal(data, &e))
+	assert.Equal(int64(1531142594), e.Sequence)
+}
+
+func TestQueueFailedEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := SetupRedis(context.Background(), redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 100 * time.Millisecond,
+	}))
+	queue := NewQueue(ctx, "market")
+	sequence := queue.sequence
+	for _, typ := range []string{EventTypeOrderOpen, "BOOK-T0"} {
+		e := &Event{Market: "market", Type: typ, Timestamp: time.Now()}
+		assert.NotNil(queue.handleEvent(ctx, e))
+		assert.NotNil(queue.handleEvent(ctx, e))
+		assert.Equal(sequence, e.Sequence)
+		assert.Equal(sequence, queue.sequence)
+	}
+}
+
+func TestResumeEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Len(ResumeEvents(nil, 0), 0)
+	assert.Len(ResumeEvents(nil, 100), 0)
+
+	events := []*Event{
+		{Type: "BOOK-T0", Sequence: 100},
+		{Type: EventTypeOrderOpen, Sequence: 101},
+		{Type: EventTypeBookDelta, Sequence: 102},
+	}
+
+	// a new client or a client behind the latest BOOK-T0 resyncs from the snapshot
+	assert.Equal(events, ResumeEvents(events, 0))
+	assert.Equal(events, ResumeEvents(events, 99))
+	// a client ahead of the pending events, e.g. after the engine restarts, resyncs too
+	assert.Equal(events, ResumeEvents(events, 103))
+
+	// a client within the pending events receives only the missed events
+	assert.Equal(events[1:], ResumeEvents(events, 100))
+	assert.Equal(events[2:], ResumeEvents(events, 101))
+	assert.Len(ResumeEvents(events, 102), 0)
+}
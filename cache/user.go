@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const (
+	EventTypeOrderFill = "ORDER-FILL"
+	EventTypeTransfer  = "TRANSFER"
+)
+
+type UserQueue struct {
+	events chan *Event
+}
+
+func NewUserQueue() *UserQueue {
+	return &UserQueue{
+		events: make(chan *Event, 8192),
+	}
+}
+
+func (queue *UserQueue) Loop(ctx context.Context) {
+	for {
+		select {
+		case e := <-queue.events:
+			for {
+				err := queue.handleEvent(ctx, e)
+				if err == nil {
+					break
+				}
+				log.Println("cache user queue loop error", err)
+				time.Sleep(1 * time.Second)
+			}
+		}
+	}
+}
+
+func (queue *UserQueue) handleEvent(ctx context.Context, e *Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Panicln(err)
+	}
+	_, err = Redis(ctx).Publish("USER-EVENTS", data).Result()
+	return err
+}
+
+func (queue *UserQueue) AttachEvent(ctx context.Context, userId, market, typ string, data map[string]interface{}) {
+	queue.events <- &Event{
+		UserId:    userId,
+		Market:    market,
+		Type:      typ,
+		Data:      data,
+		Timestamp: time.Now().UTC(),
+	}
+}
@@ -0,0 +1,29 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserQueueFailedEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := SetupRedis(context.Background(), redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 100 * time.Millisecond,
+	}))
+	queue := NewUserQueue()
+	e := &Event{UserId: "uid", Market: "market", Type: EventTypeOrderFill, Timestamp: time.Now()}
+	assert.NotNil(queue.handleEvent(ctx, e))
+
+	// the failed event is retried instead of dropped, so the next one stays queued
+	queue.AttachEvent(ctx, "uid", "market", EventTypeOrderFill, nil)
+	queue.AttachEvent(ctx, "uid", "market", EventTypeTransfer, nil)
+	go queue.Loop(ctx)
+	time.Sleep(1500 * time.Millisecond)
+	assert.Len(queue.events, 1)
+}
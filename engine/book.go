@@ -4,49 +4,98 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/MixinNetwork/go-number"
 	"github.com/MixinNetwork/ocean.one/cache"
+	"github.com/emirpasic/gods/trees/redblacktree"
 )
 
 const (
 	OrderActionCreate = "CREATE"
 	OrderActionCancel = "CANCEL"
+	OrderActionAmend  = "AMEND"
+
+	SelfTradeCancelNewest       = "CANCEL_NEWEST"
+	SelfTradeCancelOldest       = "CANCEL_OLDEST"
+	SelfTradeCancelBoth         = "CANCEL_BOTH"
+	SelfTradeDecrementAndCancel = "DECREMENT_AND_CANCEL"
+
+	BookStateActive     = "ACTIVE"
+	BookStateHalted     = "HALTED"
+	BookStateCancelOnly = "CANCEL_ONLY"
 
 	EventQueueSize = 8192
 )
 
 type TransactCallback func(taker, maker *Order, amount number.Integer) string
 type CancelCallback func(order *Order)
+type TriggerCallback func(order *Order)
+type DecrementCallback func(order, opponent *Order, amount, funds number.Integer)
+type AmendCallback func(order *Order, amount, funds number.Integer)
 
 type OrderEvent struct {
 	Order  *Order
 	Action string
+
+	snapshot   chan *Snapshot
+	checkpoint time.Time
+	state      string
 }
 
 type Book struct {
 	market      string
+	selfTrade   string
+	state       string
+	halted      []*OrderEvent
 	events      chan *OrderEvent
-	createIndex map[string]bool
-	cancelIndex map[string]bool
+	createIndex *Index
+	cancelIndex *Index
 	transact    TransactCallback
 	cancel      CancelCallback
+	trigger     TriggerCallback
+	decrement   DecrementCallback
+	amend       AmendCallback
 	asks        *Page
 	bids        *Page
+	askStops    *Trigger
+	bidStops    *Trigger
+	lastPrice   number.Integer
+	lastTrade   *TickerTrade
+	buckets     []*TickerBucket
+	tickerBest  string
+	triggered   []*Order
+	changed     []*Entry
+	depthStale  bool
+	deadlines   *redblacktree.Tree
 	queue       *cache.Queue
 }
 
-func NewBook(ctx context.Context, market string, transact TransactCallback, cancel CancelCallback) *Book {
+func NewBook(ctx context.Context, market, selfTrade string, transact TransactCallback, cancel CancelCallback, trigger TriggerCallback, decrement DecrementCallback, amend AmendCallback) *Book {
+	switch selfTrade {
+	case "", SelfTradeCancelNewest, SelfTradeCancelOldest, SelfTradeCancelBoth, SelfTradeDecrementAndCancel:
+	default:
+		return nil
+	}
 	return &Book{
 		market:      market,
+		selfTrade:   selfTrade,
+		state:       BookStateActive,
 		events:      make(chan *OrderEvent, EventQueueSize),
-		createIndex: make(map[string]bool),
-		cancelIndex: make(map[string]bool),
+		createIndex: NewIndex(),
+		cancelIndex: NewIndex(),
 		transact:    transact,
 		cancel:      cancel,
+		trigger:     trigger,
+		decrement:   decrement,
+		amend:       amend,
 		asks:        NewPage(PageSideAsk),
 		bids:        NewPage(PageSideBid),
+		askStops:    NewTrigger(PageSideAsk),
+		bidStops:    NewTrigger(PageSideBid),
+		triggered:   make([]*Order, 0),
+		deadlines:   redblacktree.NewWith(deadlineCompare),
 		queue:       cache.NewQueue(ctx, market),
 	}
 }
@@ -55,10 +104,17 @@ func (book *Book) AttachOrderEvent(ctx context.Context, order *Order, action str
 	if order.Side != PageSideAsk && order.Side != PageSideBid {
 		log.Panicln(order, action)
 	}
-	if order.Type != OrderTypeLimit && order.Type != OrderTypeMarket {
+	switch order.Type {
+	case OrderTypeLimit, OrderTypeMarket, OrderTypeStopLimit, OrderTypeStopMarket:
+	default:
 		log.Panicln(order, action)
 	}
-	if action != OrderActionCreate && action != OrderActionCancel {
+	if action != OrderActionCreate && action != OrderActionCancel && action != OrderActionAmend {
+		log.Panicln(order, action)
+	}
+	switch order.TimeInForce {
+	case "", TimeInForceGTC, TimeInForceIOC, TimeInForceFOK, TimeInForcePostOnly:
+	default:
 		log.Panicln(order, action)
 	}
 	if action != OrderActionCancel {
@@ -67,16 +123,40 @@ func (book *Book) AttachOrderEvent(ctx context.Context, order *Order, action str
 	book.events <- &OrderEvent{Order: order, Action: action}
 }
 
+func (book *Book) SetState(ctx context.Context, state string) {
+	switch state {
+	case BookStateActive, BookStateHalted, BookStateCancelOnly:
+	default:
+		log.Panicln(book.market, state)
+	}
+	book.events <- &OrderEvent{state: state}
+}
+
 func (book *Book) process(ctx context.Context, taker, maker *Order) (string, number.Integer, number.Integer) {
 	taker.assert()
 	maker.assert()
 
 	matchedPrice := maker.Price
-	makerAmount := maker.RemainingAmount
+	matchedAmount, matchedFunds := matchOrders(taker, maker)
+	taker.fill(matchedAmount, matchedFunds)
+	maker.fill(matchedAmount, matchedFunds)
+
+	tradeId := book.transact(taker, maker, matchedAmount)
+	book.lastPrice = matchedPrice
+	trade := book.recordTrade(tradeId, matchedPrice, matchedAmount)
+	book.cacheTrade(ctx, trade, taker.Side)
+	book.triggered = append(book.triggered, book.askStops.Release(matchedPrice)...)
+	book.triggered = append(book.triggered, book.bidStops.Release(matchedPrice)...)
+	return tradeId, matchedAmount, matchedFunds
+}
+
+func matchOrders(taker, maker *Order) (number.Integer, number.Integer) {
+	matchedPrice := maker.Price
+	makerAmount := maker.visible()
 	makerFunds := makerAmount.Mul(matchedPrice)
 	if maker.Side == PageSideBid {
-		makerAmount = maker.RemainingFunds.Div(matchedPrice)
-		makerFunds = maker.RemainingFunds
+		makerFunds = maker.visible()
+		makerAmount = makerFunds.Div(matchedPrice)
 	}
 	takerAmount := taker.RemainingAmount
 	takerFunds := takerAmount.Mul(matchedPrice)
@@ -88,92 +168,214 @@ func (book *Book) process(ctx context.Context, taker, maker *Order) (string, num
 	if takerAmount.Cmp(matchedAmount) < 0 || takerFunds.Cmp(matchedFunds) < 0 {
 		matchedAmount, matchedFunds = takerAmount, takerFunds
 	}
+	return matchedAmount, matchedFunds
+}
+
+func (book *Book) createOrder(ctx context.Context, order *Order) {
+	if book.live(order) || !book.createIndex.Add(order.Id, order.ActionAt) {
+		return
+	}
 
-	taker.FilledAmount = taker.FilledAmount.Add(matchedAmount)
-	taker.FilledFunds = taker.FilledFunds.Add(matchedFunds)
-	if taker.Side == PageSideAsk {
-		taker.RemainingAmount = taker.RemainingAmount.Sub(matchedAmount)
+	if order.expired(time.Now()) {
+		book.cancel(order)
+		return
 	}
-	if taker.Side == PageSideBid {
-		taker.RemainingFunds = taker.RemainingFunds.Sub(matchedFunds)
+	if !order.ExpiredAt.IsZero() {
+		book.deadlines.Put(order, true)
 	}
 
-	maker.FilledAmount = maker.FilledAmount.Add(matchedAmount)
-	maker.FilledFunds = maker.FilledFunds.Add(matchedFunds)
-	if maker.Side == PageSideAsk {
-		maker.RemainingAmount = maker.RemainingAmount.Sub(matchedAmount)
+	book.placeOrder(ctx, order)
+	book.releaseTriggered(ctx)
+}
+
+func (book *Book) live(order *Order) bool {
+	if order.Side == PageSideAsk {
+		return book.asks.Get(order.Id) != nil || book.askStops.Get(order.Id) != nil
 	}
-	if maker.Side == PageSideBid {
-		maker.RemainingFunds = maker.RemainingFunds.Sub(matchedFunds)
+	return book.bids.Get(order.Id) != nil || book.bidStops.Get(order.Id) != nil
+}
+
+func (book *Book) releaseTriggered(ctx context.Context) {
+	for len(book.triggered) > 0 {
+		order := book.triggered[0]
+		book.triggered = book.triggered[1:]
+		book.releaseOrder(ctx, order)
 	}
+}
 
-	tradeId := book.transact(taker, maker, matchedAmount)
-	return tradeId, matchedAmount, matchedFunds
+func (book *Book) releaseOrder(ctx context.Context, order *Order) {
+	order.release()
+	book.trigger(order)
+	book.placeOrder(ctx, order)
 }
 
-func (book *Book) createOrder(ctx context.Context, order *Order) {
-	if _, found := book.createIndex[order.Id]; found {
+func (book *Book) placeOrder(ctx context.Context, order *Order) {
+	if order.stopped() {
+		if !book.lastPrice.IsZero() && order.triggered(book.lastPrice) {
+			book.releaseOrder(ctx, order)
+		} else if order.Side == PageSideAsk {
+			book.askStops.Put(order)
+		} else if order.Side == PageSideBid {
+			book.bidStops.Put(order)
+		}
 		return
 	}
-	book.createIndex[order.Id] = true
 
-	if order.Side == PageSideAsk {
-		opponents := make([]*Order, 0)
-		book.bids.Iterate(func(opponent *Order) (number.Integer, number.Integer, bool) {
-			if order.Type == OrderTypeLimit && opponent.Price.Cmp(order.Price) < 0 {
-				return order.RemainingAmount.Zero(), order.RemainingFunds.Zero(), true
-			}
-			tradeId, matchedAmount, matchedFunds := book.process(ctx, order, opponent)
-			book.cacheOrderEvent(ctx, cache.EventTypeOrderMatch, opponent.Side, opponent.Price, matchedAmount, matchedFunds, tradeId, opponent.Id, order.Id)
-			opponents = append(opponents, opponent)
-			return matchedAmount, matchedFunds, order.filled()
-		})
-		for _, o := range opponents {
-			if o.filled() {
-				book.bids.Remove(o)
-			}
+	if !book.acceptOrder(ctx, order) {
+		book.deadlines.Remove(order)
+		book.cancel(order)
+		return
+	}
+
+	page, opponentPage := book.asks, book.bids
+	if order.Side == PageSideBid {
+		page, opponentPage = book.bids, book.asks
+	}
+
+	prevented := false
+	opponents, cancelled := make([]*Order, 0), make([]*Order, 0)
+	opponentPage.Iterate(func(opponent *Order) (number.Integer, number.Integer, bool) {
+		if !order.crosses(opponent) {
+			return order.RemainingAmount.Zero(), order.RemainingFunds.Zero(), true
 		}
-		if !order.filled() {
-			if order.Type == OrderTypeLimit {
-				book.asks.Put(order)
-				book.cacheOrderEvent(ctx, cache.EventTypeOrderOpen, order.Side, order.Price, order.RemainingAmount, order.RemainingFunds, order.Id)
-			} else {
-				book.cancel(order)
+		if book.selfTrade != "" && order.UserId != "" && order.UserId == opponent.UserId {
+			cancelTaker, cancelMaker, decrementedAmount, decrementedFunds := book.preventSelfTrade(ctx, order, opponent)
+			if cancelMaker {
+				cancelled = append(cancelled, opponent)
 			}
+			prevented = cancelTaker
+			return decrementedAmount, decrementedFunds, cancelTaker
 		}
-	} else if order.Side == PageSideBid {
-		opponents := make([]*Order, 0)
-		book.asks.Iterate(func(opponent *Order) (number.Integer, number.Integer, bool) {
-			if order.Type == OrderTypeLimit && opponent.Price.Cmp(order.Price) > 0 {
-				return order.RemainingAmount.Zero(), order.RemainingFunds.Zero(), true
-			}
-			tradeId, matchedAmount, matchedFunds := book.process(ctx, order, opponent)
-			book.cacheOrderEvent(ctx, cache.EventTypeOrderMatch, opponent.Side, opponent.Price, matchedAmount, matchedFunds, tradeId, opponent.Id, order.Id)
-			opponents = append(opponents, opponent)
-			return matchedAmount, matchedFunds, order.filled()
-		})
-		for _, o := range opponents {
-			if o.filled() {
-				book.asks.Remove(o)
-			}
+		tradeId, matchedAmount, matchedFunds := book.process(ctx, order, opponent)
+		book.cacheOrderEvent(ctx, cache.EventTypeOrderMatch, opponent.Side, opponent.Price, matchedAmount, matchedFunds, tradeId, opponent.Id, order.Id)
+		if opponent.iceberg() && opponent.visible().IsZero() && !opponent.filled() {
+			book.cacheOrderQuantity(ctx, cache.EventTypeOrderOpen, opponent, opponent.slice())
 		}
-		if !order.filled() {
-			if order.Type == OrderTypeLimit {
-				book.bids.Put(order)
-				book.cacheOrderEvent(ctx, cache.EventTypeOrderOpen, order.Side, order.Price, order.RemainingAmount, order.RemainingFunds, order.Id)
-			} else {
-				book.cancel(order)
-			}
+		opponents = append(opponents, opponent)
+		return matchedAmount, matchedFunds, order.filled()
+	})
+	for _, o := range opponents {
+		if o.filled() {
+			opponentPage.Remove(o)
+			book.deadlines.Remove(o)
+		}
+	}
+	if len(opponents) > 0 {
+		book.cacheTicker(ctx)
+	}
+	for _, o := range cancelled {
+		opponentPage.Remove(o)
+		book.deadlines.Remove(o)
+		book.cancel(o)
+		book.cacheOrderQuantity(ctx, cache.EventTypeOrderSelfTrade, o, o.visible())
+	}
+	if prevented {
+		book.cancel(order)
+	} else if !order.filled() {
+		if order.resting() {
+			page.Put(order)
+			book.cacheOrderQuantity(ctx, cache.EventTypeOrderOpen, order, order.visible())
+		} else {
+			book.cancel(order)
+		}
+	}
+	if page.Get(order.Id) == nil {
+		book.deadlines.Remove(order)
+	}
+}
+
+// returns whether to cancel the taker and the maker, and the decremented maker amount and funds
+func (book *Book) preventSelfTrade(ctx context.Context, taker, maker *Order) (bool, bool, number.Integer, number.Integer) {
+	zeroAmount, zeroFunds := maker.RemainingAmount.Zero(), maker.RemainingFunds.Zero()
+	switch book.selfTrade {
+	case SelfTradeCancelNewest:
+		return true, false, zeroAmount, zeroFunds
+	case SelfTradeCancelOldest:
+		return false, true, zeroAmount, zeroFunds
+	case SelfTradeCancelBoth:
+		return true, true, zeroAmount, zeroFunds
+	case SelfTradeDecrementAndCancel:
+	default:
+		log.Panicln(book.selfTrade)
+	}
+
+	matchedAmount, matchedFunds := matchOrders(taker, maker)
+	takerSmaller, makerSmaller := *taker, *maker
+	takerSmaller.fill(matchedAmount, matchedFunds)
+	makerSmaller.fill(matchedAmount, matchedFunds)
+	if takerSmaller.filled() && makerSmaller.filled() {
+		return true, true, zeroAmount, zeroFunds
+	}
+	if takerSmaller.filled() {
+		maker.decrement(matchedAmount, matchedFunds)
+		book.decrement(maker, taker, matchedAmount, matchedFunds)
+		book.cacheOrderEvent(ctx, cache.EventTypeOrderSelfTrade, maker.Side, maker.Price, matchedAmount, matchedFunds, maker.Id)
+		if maker.iceberg() && maker.visible().IsZero() {
+			book.cacheOrderQuantity(ctx, cache.EventTypeOrderOpen, maker, maker.slice())
 		}
+		return true, false, matchedAmount, matchedFunds
 	}
+	taker.decrement(matchedAmount, matchedFunds)
+	book.decrement(taker, maker, matchedAmount, matchedFunds)
+	return false, true, zeroAmount, zeroFunds
+}
+
+func (book *Book) acceptOrder(ctx context.Context, order *Order) bool {
+	switch order.TimeInForce {
+	case TimeInForcePostOnly:
+		return !book.crossed(order)
+	case TimeInForceFOK:
+		return book.fillable(order)
+	}
+	return true
+}
+
+func (book *Book) crossed(order *Order) bool {
+	page := book.asks
+	if order.Side == PageSideAsk {
+		page = book.bids
+	}
+	crossed := false
+	page.Iterate(func(opponent *Order) (number.Integer, number.Integer, bool) {
+		crossed = order.crosses(opponent)
+		return opponent.RemainingAmount.Zero(), opponent.RemainingFunds.Zero(), true
+	})
+	return crossed
+}
+
+func (book *Book) fillable(order *Order) bool {
+	page := book.asks
+	if order.Side == PageSideAsk {
+		page = book.bids
+	}
+	taker := *order
+	page.Iterate(func(opponent *Order) (number.Integer, number.Integer, bool) {
+		if !taker.crosses(opponent) {
+			return opponent.RemainingAmount.Zero(), opponent.RemainingFunds.Zero(), true
+		}
+		if book.selfTrade != "" && order.UserId != "" && order.UserId == opponent.UserId {
+			done := book.selfTrade != SelfTradeCancelOldest
+			return opponent.RemainingAmount.Zero(), opponent.RemainingFunds.Zero(), done
+		}
+		maker := *opponent
+		maker.DisplayAmount = number.Integer{}
+		matchedAmount, matchedFunds := matchOrders(&taker, &maker)
+		taker.fill(matchedAmount, matchedFunds)
+		return opponent.RemainingAmount.Zero(), opponent.RemainingFunds.Zero(), taker.filled()
+	})
+	return taker.filled()
 }
 
 func (book *Book) cancelOrder(ctx context.Context, order *Order) {
-	if _, found := book.cancelIndex[order.Id]; found {
+	if !book.cancelIndex.Add(order.Id, order.ActionAt) {
 		return
 	}
-	book.cancelIndex[order.Id] = true
 
+	if stop := book.cancelStopOrder(ctx, order); stop != nil {
+		book.deadlines.Remove(stop)
+		book.cancel(stop)
+		return
+	}
 	if order.Side == PageSideAsk {
 		order = book.asks.Remove(order)
 	} else if order.Side == PageSideBid {
@@ -182,9 +384,73 @@ func (book *Book) cancelOrder(ctx context.Context, order *Order) {
 		log.Panicln(order)
 	}
 	if order != nil {
+		book.deadlines.Remove(order)
 		book.cancel(order)
-		book.cacheOrderEvent(ctx, cache.EventTypeOrderCancel, order.Side, order.Price, order.RemainingAmount, order.RemainingFunds, order.Id)
+		book.cacheOrderQuantity(ctx, cache.EventTypeOrderCancel, order, order.visible())
+	}
+}
+
+func (book *Book) amendOrder(ctx context.Context, amended *Order) {
+	page := book.asks
+	if amended.Side == PageSideBid {
+		page = book.bids
+	}
+	order := page.Get(amended.Id)
+	if order == nil {
+		return
+	}
+	quantity := amended.remaining()
+	if quantity.IsZero() || quantity.Cmp(order.remaining()) > 0 {
+		quantity = order.remaining()
+	}
+	reduced := order.remaining().Sub(quantity)
+	amount, funds := reduced, order.RemainingFunds.Zero()
+	if order.Side == PageSideBid {
+		amount, funds = order.RemainingAmount.Zero(), reduced
+	}
+
+	if amended.Price.IsZero() || amended.Price.Cmp(order.Price) == 0 {
+		if reduced.IsZero() {
+			return
+		}
+		page.Resize(order, quantity)
+		book.amend(order, amount, funds)
+		book.cacheOrderQuantity(ctx, cache.EventTypeOrderAmend, order, order.visible())
+		return
 	}
+
+	page.Remove(order)
+	book.changeLevel(order.Side, order.Price)
+	order.resize(quantity)
+	order.Price = amended.Price
+	book.amend(order, amount, funds)
+	book.cacheOrderQuantity(ctx, cache.EventTypeOrderAmend, order, order.slice())
+	book.placeOrder(ctx, order)
+	book.releaseTriggered(ctx)
+}
+
+func (book *Book) expireOrders(ctx context.Context, now time.Time) {
+	for {
+		node := book.deadlines.Left()
+		if node == nil || !node.Key.(*Order).expired(now) {
+			return
+		}
+		order := node.Key.(*Order)
+		book.deadlines.Remove(order)
+		if book.live(order) {
+			book.cancelOrder(ctx, order)
+		}
+	}
+}
+
+func (book *Book) cancelStopOrder(ctx context.Context, order *Order) *Order {
+	switch order.Side {
+	case PageSideAsk:
+		return book.askStops.Remove(order)
+	case PageSideBid:
+		return book.bidStops.Remove(order)
+	}
+	return nil
 }
 
 func (book *Book) Run(ctx context.Context) {
@@ -196,26 +462,86 @@ func (book *Book) Run(ctx context.Context) {
 	bestCacheTicker := time.NewTicker(time.Second)
 	defer bestCacheTicker.Stop()
 
+	expireTicker := time.NewTicker(time.Second)
+	defer expireTicker.Stop()
+
 	book.cacheList(ctx, 0)
+	book.cacheDepth(ctx)
 
 	for {
 		select {
 		case event := <-book.events:
-			if event.Action == OrderActionCreate {
-				book.createOrder(ctx, event.Order)
-			} else if event.Action == OrderActionCancel {
-				book.cancelOrder(ctx, event.Order)
+			if event.snapshot != nil {
+				event.snapshot <- book.checkpoint(event.checkpoint)
+			} else if event.state != "" {
+				book.setState(ctx, event.state)
 			} else {
-				log.Panicln(event)
+				book.handleOrderEvent(ctx, event)
 			}
+			book.cacheDeltas(ctx)
 		case <-fullCacheTicker.C:
 			book.cacheList(ctx, 0)
-		case <-bestCacheTicker.C:
+		case t := <-bestCacheTicker.C:
 			book.cacheList(ctx, 1)
+			book.refreshTicker(ctx, t)
+			if book.depthStale {
+				book.cacheDepth(ctx)
+			}
+		case t := <-expireTicker.C:
+			if book.state != BookStateHalted {
+				book.expireOrders(ctx, t)
+				book.cacheDeltas(ctx)
+			}
 		}
 	}
 }
 
+func (book *Book) handleOrderEvent(ctx context.Context, event *OrderEvent) {
+	switch book.state {
+	case BookStateHalted:
+		book.halted = append(book.halted, event)
+		return
+	case BookStateCancelOnly:
+		if event.Action == OrderActionCreate {
+			book.rejectOrder(ctx, event.Order)
+			return
+		}
+		if event.Action == OrderActionAmend {
+			return
+		}
+	}
+
+	switch event.Action {
+	case OrderActionCreate:
+		book.createOrder(ctx, event.Order)
+	case OrderActionCancel:
+		book.cancelOrder(ctx, event.Order)
+	case OrderActionAmend:
+		book.amendOrder(ctx, event.Order)
+	default:
+		log.Panicln(event)
+	}
+}
+
+func (book *Book) setState(ctx context.Context, state string) {
+	book.state = state
+	if state == BookStateHalted {
+		return
+	}
+	halted := book.halted
+	book.halted = nil
+	for _, event := range halted {
+		book.handleOrderEvent(ctx, event)
+	}
+}
+
+func (book *Book) rejectOrder(ctx context.Context, order *Order) {
+	if book.live(order) || !book.createIndex.Add(order.Id, order.ActionAt) {
+		return
+	}
+	book.cancel(order)
+}
+
 func (book *Book) cacheList(ctx context.Context, limit int) {
 	event := fmt.Sprintf("BOOK-T%d", limit)
 	data := map[string]interface{}{
@@ -225,6 +551,26 @@ func (book *Book) cacheList(ctx context.Context, limit int) {
 	book.queue.AttachEvent(ctx, event, data)
 }
 
+func (book *Book) cacheDepth(ctx context.Context) {
+	book.depthStale = false
+	book.queue.AttachEvent(ctx, cache.EventTypeBookL2, map[string]interface{}{
+		"asks": book.asks.List(0, true),
+		"bids": book.bids.List(0, true),
+	})
+	book.queue.AttachEvent(ctx, cache.EventTypeBookL3, map[string]interface{}{
+		"asks": book.asks.ListOrders(),
+		"bids": book.bids.ListOrders(),
+	})
+}
+
+func (book *Book) cacheOrderQuantity(ctx context.Context, event string, order *Order, quantity number.Integer) {
+	amount, funds := quantity, order.RemainingFunds.Zero()
+	if order.Side == PageSideBid {
+		amount, funds = order.RemainingAmount.Zero(), quantity
+	}
+	book.cacheOrderEvent(ctx, event, order.Side, order.Price, amount, funds, order.Id)
+}
+
 func (book *Book) cacheOrderEvent(ctx context.Context, event, side string, price, amount, funds number.Integer, tradeAndOrderIds ...string) {
 	if amount.IsZero() {
 		amount = funds.Div(price)
@@ -239,7 +585,7 @@ func (book *Book) cacheOrderEvent(ctx context.Context, event, side string, price
 	}
 
 	switch event {
-	case cache.EventTypeOrderOpen, cache.EventTypeOrderCancel: // order open or cancel event
+	case cache.EventTypeOrderOpen, cache.EventTypeOrderCancel, cache.EventTypeOrderSelfTrade, cache.EventTypeOrderAmend: // order open, cancel, self trade or amend event
 		data["order_id"] = tradeAndOrderIds[0]
 	case cache.EventTypeOrderMatch: // order match event
 		data["trade_id"] = tradeAndOrderIds[0]
@@ -247,5 +593,65 @@ func (book *Book) cacheOrderEvent(ctx context.Context, event, side string, price
 		data["taker_id"] = tradeAndOrderIds[2]
 	}
 
+	book.changeLevel(side, price)
 	book.queue.AttachEvent(ctx, event, data)
 }
+
+func (book *Book) changeLevel(side string, price number.Integer) {
+	for _, l := range book.changed {
+		if l.Side == side && l.Price.Cmp(price) == 0 {
+			return
+		}
+	}
+	book.changed = append(book.changed, &Entry{Side: side, Price: price})
+}
+
+// levelDeltas returns the current levels of all the changed prices, in the order
+// they are changed, the amount and funds of a removed level are zero.
+func (book *Book) levelDeltas() []*Entry {
+	deltas := make([]*Entry, 0, len(book.changed))
+	for _, l := range book.changed {
+		page := book.asks
+		if l.Side == PageSideBid {
+			page = book.bids
+		}
+		deltas = append(deltas, page.Level(l.Price))
+	}
+	book.changed = nil
+	return deltas
+}
+
+func (book *Book) cacheDeltas(ctx context.Context) {
+	for _, entry := range book.levelDeltas() {
+		book.depthStale = true
+		book.queue.AttachEvent(ctx, cache.EventTypeBookDelta, map[string]interface{}{
+			"side":   entry.Side,
+			"price":  entry.Price,
+			"amount": entry.Amount,
+			"funds":  entry.Funds,
+		})
+	}
+}
+
+func (book *Book) cacheTrade(ctx context.Context, trade *TickerTrade, side string) {
+	book.queue.AttachEvent(ctx, cache.EventTypeTrade, map[string]interface{}{
+		"trade_id":   trade.TradeId,
+		"side":       side,
+		"price":      trade.Price,
+		"amount":     trade.Amount,
+		"funds":      trade.Funds,
+		"created_at": trade.CreatedAt.UTC(),
+	})
+}
+
+func deadlineCompare(a, b interface{}) int {
+	order := a.(*Order)
+	opponent := b.(*Order)
+	if order.ExpiredAt.Before(opponent.ExpiredAt) {
+		return -1
+	}
+	if order.ExpiredAt.After(opponent.ExpiredAt) {
+		return 1
+	}
+	return strings.Compare(order.Id, opponent.Id)
+}
@@ -2,6 +2,8 @@ package engine
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
@@ -32,7 +34,7 @@ func TestBook(t *testing.T) {
 
 	matched := make([]*DummyTrade, 0)
 	cancelled := make([]*Order, 0)
-	book := NewBook(ctx, "market", func(taker, maker *Order, amount number.Integer) string {
+	book := NewBook(ctx, "market", "", func(taker, maker *Order, amount number.Integer) string {
 		matched = append(matched, &DummyTrade{
 			Amount:           amount,
 			TakerId:          taker.Id,
@@ -47,6 +49,9 @@ func TestBook(t *testing.T) {
 		return "TRADE-ID"
 	}, func(order *Order) {
 		cancelled = append(cancelled, order)
+	}, func(order *Order) {
+	}, func(order, opponent *Order, amount, funds number.Integer) {
+	}, func(order *Order, amount, funds number.Integer) {
 	})
 	assert.NotNil(book)
 	go book.Run(ctx)
@@ -91,14 +96,14 @@ func TestBook(t *testing.T) {
 	book.AttachOrderEvent(ctx, bo1_3, OrderActionCreate)
 	time.Sleep(100 * time.Millisecond)
 	assert.Equal("6000", book.bids.entries[10000].Funds.Persist())
-	assert.Equal(3, book.bids.entries[10000].list.Size())
+	assert.Equal(3, book.bids.entries[10000].list.Len())
 
 	book.AttachOrderEvent(ctx, bo1_2, OrderActionCancel)
 	time.Sleep(100 * time.Millisecond)
 	assert.Len(cancelled, 1)
 	assert.Equal(bo1_2.Id, cancelled[0].Id)
 	assert.Equal("4000", book.bids.entries[10000].Funds.Persist())
-	assert.Equal(2, book.bids.entries[10000].list.Size())
+	assert.Equal(2, book.bids.entries[10000].list.Len())
 
 	id, _ = uuid.NewV4()
 	bo2_1 := &Order{
@@ -131,7 +136,7 @@ func TestBook(t *testing.T) {
 		assert.Len(cancelled, 1)
 		assert.Equal(bo1_2.Id, cancelled[0].Id)
 		assert.Equal("2000", book.bids.entries[10000].Funds.Persist())
-		assert.Equal(1, book.bids.entries[10000].list.Size())
+		assert.Equal(1, book.bids.entries[10000].list.Len())
 		assert.Len(book.asks.entries, 0)
 		assert.Len(matched, 3)
 		m0 := matched[0]
@@ -176,8 +181,7 @@ func TestBook(t *testing.T) {
 
 	assert.Len(cancelled, 1)
 	assert.Equal(bo1_2.Id, cancelled[0].Id)
-	assert.Equal("0", book.bids.entries[10000].Funds.Persist())
-	assert.Equal(0, book.bids.entries[10000].list.Size())
+	assert.Nil(book.bids.entries[10000])
 	assert.Len(book.asks.entries, 1)
 	assert.Len(matched, 4)
 	m3 := matched[3]
@@ -216,14 +220,10 @@ func TestBook(t *testing.T) {
 	book.AttachOrderEvent(ctx, bo2_2, OrderActionCreate)
 	time.Sleep(100 * time.Millisecond)
 
-	assert.Equal("0", book.bids.entries[10000].Funds.Persist())
-	assert.Equal(0, book.bids.entries[10000].list.Size())
-	assert.Equal("0", book.bids.entries[20000].Funds.Persist())
-	assert.Equal(0, book.bids.entries[20000].list.Size())
-	assert.Equal("0", book.asks.entries[10000].Amount.Persist())
-	assert.Equal(0, book.asks.entries[10000].list.Size())
-	assert.Equal("0", book.asks.entries[20000].Amount.Persist())
-	assert.Equal(0, book.asks.entries[20000].list.Size())
+	assert.Nil(book.bids.entries[10000])
+	assert.Nil(book.bids.entries[20000])
+	assert.Nil(book.asks.entries[10000])
+	assert.Nil(book.asks.entries[20000])
 	assert.Len(cancelled, 2)
 	assert.Equal(bo1_2.Id, cancelled[0].Id)
 	assert.Equal(bo2_2.Id, cancelled[1].Id)
@@ -249,6 +249,695 @@ func TestBook(t *testing.T) {
 	assert.Equal("200", m5.MakerFilledPrice.Persist())
 }
 
+func TestBookStopOrders(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+
+	matched := make([]*DummyTrade, 0)
+	triggered := make([]*Order, 0)
+	book := NewBook(ctx, "market", "", func(taker, maker *Order, amount number.Integer) string {
+		matched = append(matched, &DummyTrade{Amount: amount, TakerId: taker.Id, MakerId: maker.Id})
+		return "TRADE-ID"
+	}, func(order *Order) {
+	}, func(order *Order) {
+		triggered = append(triggered, order)
+	}, func(order, opponent *Order, amount, funds number.Integer) {
+	}, func(order *Order, amount, funds number.Integer) {
+	})
+
+	a1 := testLimitOrder(PageSideAsk, 10100, 100, TimeInForceGTC)
+	a2 := testLimitOrder(PageSideAsk, 10300, 100, TimeInForceGTC)
+	b1 := testLimitOrder(PageSideBid, 9900, 100, TimeInForceGTC)
+	b2 := testLimitOrder(PageSideBid, 9700, 100, TimeInForceGTC)
+	bidLimit := testStopOrder(PageSideBid, OrderTypeStopLimit, 10100, 10100, 50)
+	bidMarket := testStopOrder(PageSideBid, OrderTypeStopMarket, 10300, 10300, 20)
+	bidUntouched := testStopOrder(PageSideBid, OrderTypeStopMarket, 11000, 11000, 10)
+	askLimit := testStopOrder(PageSideAsk, OrderTypeStopLimit, 9900, 9700, 30)
+	askMarket := testStopOrder(PageSideAsk, OrderTypeStopMarket, 9700, 0, 20)
+	askUntouched := testStopOrder(PageSideAsk, OrderTypeStopLimit, 9000, 9000, 10)
+	for _, o := range []*Order{a1, a2, b1, b2, bidLimit, bidMarket, bidUntouched, askLimit, askMarket, askUntouched} {
+		book.createOrder(ctx, o)
+	}
+	assert.Len(book.bidStops.Orders(), 3)
+	assert.Len(book.askStops.Orders(), 3)
+	assert.Len(triggered, 0)
+
+	// a trade between the trigger prices releases nothing
+	book.createOrder(ctx, testLimitOrder(PageSideAsk, 10000, 10, TimeInForceGTC))
+	book.createOrder(ctx, testLimitOrder(PageSideBid, 10000, 10, TimeInForceGTC))
+	assert.Len(matched, 1)
+	assert.Equal("100", book.lastPrice.Persist())
+	assert.Len(triggered, 0)
+	assert.Len(book.bidStops.Orders(), 3)
+	assert.Len(book.askStops.Orders(), 3)
+
+	book.createOrder(ctx, testLimitOrder(PageSideBid, 10100, 20, TimeInForceGTC))
+	assert.Len(triggered, 1)
+	assert.Equal(bidLimit.Id, triggered[0].Id)
+	assert.Equal(OrderTypeLimit, bidLimit.Type)
+	assert.Len(matched, 3)
+	assert.Equal(bidLimit.Id, matched[2].TakerId)
+	assert.Equal(a1.Id, matched[2].MakerId)
+	assert.Equal("5", matched[2].Amount.Persist())
+	assert.Equal("0", bidLimit.RemainingFunds.Persist())
+	assert.Equal("3", a1.RemainingAmount.Persist())
+	assert.Nil(book.bidStops.Get(bidLimit.Id))
+
+	book.createOrder(ctx, testLimitOrder(PageSideBid, 10300, 50, TimeInForceGTC))
+	assert.Len(triggered, 2)
+	assert.Equal(bidMarket.Id, triggered[1].Id)
+	assert.Equal(OrderTypeMarket, bidMarket.Type)
+	assert.Len(matched, 6)
+	assert.Equal(bidMarket.Id, matched[5].TakerId)
+	assert.Equal(a2.Id, matched[5].MakerId)
+	assert.Equal("2", matched[5].Amount.Persist())
+	assert.Equal("6", a2.RemainingAmount.Persist())
+	assert.Len(book.bidStops.Orders(), 1)
+	assert.NotNil(book.bidStops.Get(bidUntouched.Id))
+
+	book.createOrder(ctx, testLimitOrder(PageSideAsk, 9700, 120, TimeInForceGTC))
+	assert.Len(triggered, 4)
+	assert.Equal(askLimit.Id, triggered[2].Id)
+	assert.Equal(askMarket.Id, triggered[3].Id)
+	assert.Equal(OrderTypeLimit, askLimit.Type)
+	assert.Equal(OrderTypeMarket, askMarket.Type)
+	assert.Len(matched, 10)
+	assert.Equal(askLimit.Id, matched[8].TakerId)
+	assert.Equal(b2.Id, matched[8].MakerId)
+	assert.Equal("3", matched[8].Amount.Persist())
+	assert.Equal(askMarket.Id, matched[9].TakerId)
+	assert.Equal("2", matched[9].Amount.Persist())
+	assert.Equal("0", askLimit.RemainingAmount.Persist())
+	assert.Equal("0", askMarket.RemainingAmount.Persist())
+	assert.Equal("291", b2.RemainingFunds.Persist())
+	assert.Equal("97", book.lastPrice.Persist())
+	assert.Len(book.askStops.Orders(), 1)
+	assert.NotNil(book.askStops.Get(askUntouched.Id))
+	assert.NotNil(book.bidStops.Get(bidUntouched.Id))
+}
+
+func TestBookTimeInForce(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+
+	matched := make([]*DummyTrade, 0)
+	cancelled := make([]*Order, 0)
+	book := NewBook(ctx, "market", "", func(taker, maker *Order, amount number.Integer) string {
+		matched = append(matched, &DummyTrade{Amount: amount, TakerId: taker.Id, MakerId: maker.Id})
+		return "TRADE-ID"
+	}, func(order *Order) {
+		cancelled = append(cancelled, order)
+	}, func(order *Order) {
+	}, func(order, opponent *Order, amount, funds number.Integer) {
+	}, func(order *Order, amount, funds number.Integer) {
+	})
+
+	ao1 := testLimitOrder(PageSideAsk, 10000, 100, TimeInForceGTC)
+	book.createOrder(ctx, ao1)
+	assert.Equal("10", book.asks.entries[10000].Amount.Persist())
+
+	bo1 := testLimitOrder(PageSideBid, 10000, 200, TimeInForceFOK)
+	book.createOrder(ctx, bo1)
+	assert.Len(matched, 0)
+	assert.Len(cancelled, 1)
+	assert.Equal(bo1.Id, cancelled[0].Id)
+	assert.Equal("2000", cancelled[0].RemainingFunds.Persist())
+	assert.Equal("10", book.asks.entries[10000].Amount.Persist())
+
+	bo2 := testLimitOrder(PageSideBid, 10000, 50, TimeInForcePostOnly)
+	book.createOrder(ctx, bo2)
+	assert.Len(matched, 0)
+	assert.Len(cancelled, 2)
+	assert.Equal(bo2.Id, cancelled[1].Id)
+	assert.Equal("10", book.asks.entries[10000].Amount.Persist())
+
+	bo3 := testLimitOrder(PageSideBid, 9000, 50, TimeInForcePostOnly)
+	book.createOrder(ctx, bo3)
+	assert.Len(cancelled, 2)
+	assert.Equal(1, book.bids.entries[9000].list.Len())
+
+	bo4 := testLimitOrder(PageSideBid, 10000, 150, TimeInForceIOC)
+	book.createOrder(ctx, bo4)
+	assert.Len(matched, 1)
+	assert.Equal("10", matched[0].Amount.Persist())
+	assert.Len(cancelled, 3)
+	assert.Equal(bo4.Id, cancelled[2].Id)
+	assert.Equal("500", cancelled[2].RemainingFunds.Persist())
+	assert.Nil(book.asks.entries[10000])
+	assert.Nil(book.bids.entries[10000])
+
+	ao2 := testLimitOrder(PageSideAsk, 9000, 50, TimeInForceFOK)
+	book.createOrder(ctx, ao2)
+	assert.Len(matched, 2)
+	assert.Len(cancelled, 3)
+	assert.Equal("0", ao2.RemainingAmount.Persist())
+}
+
+func TestBookExpire(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+
+	cancelled := make([]*Order, 0)
+	book := NewBook(ctx, "market", "", func(taker, maker *Order, amount number.Integer) string {
+		return "TRADE-ID"
+	}, func(order *Order) {
+		cancelled = append(cancelled, order)
+	}, func(order *Order) {
+	}, func(order, opponent *Order, amount, funds number.Integer) {
+	}, func(order *Order, amount, funds number.Integer) {
+	})
+
+	now := time.Now()
+	ao1 := testLimitOrder(PageSideAsk, 10000, 100, TimeInForceGTC)
+	ao1.ExpiredAt = now.Add(time.Minute)
+	book.createOrder(ctx, ao1)
+	ao2 := testLimitOrder(PageSideAsk, 10000, 100, TimeInForceGTC)
+	ao2.ExpiredAt = now.Add(time.Hour)
+	book.createOrder(ctx, ao2)
+	ao3 := testLimitOrder(PageSideAsk, 10000, 100, TimeInForceGTC)
+	ao3.ExpiredAt = now.Add(-time.Minute)
+	book.createOrder(ctx, ao3)
+	assert.Len(cancelled, 1)
+	assert.Equal(ao3.Id, cancelled[0].Id)
+	assert.Equal(2, book.asks.entries[10000].list.Len())
+
+	book.expireOrders(ctx, now)
+	assert.Len(cancelled, 1)
+	book.expireOrders(ctx, now.Add(time.Minute))
+	assert.Len(cancelled, 2)
+	assert.Equal(ao1.Id, cancelled[1].Id)
+	assert.Equal(1, book.asks.entries[10000].list.Len())
+	assert.Equal("10", book.asks.entries[10000].Amount.Persist())
+	assert.Equal(1, book.deadlines.Size())
+
+	book.cancelOrder(ctx, ao2)
+	assert.Len(cancelled, 3)
+	assert.Equal(0, book.deadlines.Size())
+	ao4 := testLimitOrder(PageSideAsk, 10000, 100, TimeInForceGTC)
+	ao4.ExpiredAt = now.Add(time.Hour)
+	book.createOrder(ctx, ao4)
+	bo1 := testLimitOrder(PageSideBid, 10000, 100, TimeInForceGTC)
+	bo1.ExpiredAt = now.Add(time.Hour)
+	book.createOrder(ctx, bo1)
+	assert.Equal(0, book.deadlines.Size())
+	bo2 := testLimitOrder(PageSideBid, 9900, 100, TimeInForceIOC)
+	bo2.ExpiredAt = now.Add(time.Hour)
+	book.createOrder(ctx, bo2)
+	assert.Equal(0, book.deadlines.Size())
+
+	cancels := book.cancelIndex.Len()
+	book.expireOrders(ctx, now.Add(2*time.Hour))
+	assert.Len(cancelled, 4)
+	assert.Equal(cancels, book.cancelIndex.Len())
+}
+
+func TestBookIcebergRefill(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+
+	filled := make([]string, 0)
+	book := NewBook(ctx, "market", "", func(taker, maker *Order, amount number.Integer) string {
+		filled = append(filled, maker.FilledAmount.Persist())
+		return "TRADE-ID"
+	}, func(order *Order) {
+	}, func(order *Order) {
+	}, func(order, opponent *Order, amount, funds number.Integer) {
+	}, func(order *Order, amount, funds number.Integer) {
+	})
+
+	ao1 := testLimitOrder(PageSideAsk, 10000, 30, TimeInForceGTC)
+	ao1.DisplayAmount = number.NewInteger(10, 1)
+	book.createOrder(ctx, ao1)
+	bo1 := testLimitOrder(PageSideBid, 10000, 30, TimeInForceGTC)
+	book.createOrder(ctx, bo1)
+	assert.Equal([]string{"1", "2", "3"}, filled)
+	assert.True(ao1.filled())
+	assert.True(bo1.filled())
+	assert.Nil(book.asks.entries[10000])
+}
+
+func TestBookSelfTrade(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+
+	assert.Nil(NewBook(ctx, "market", "CANCEL", nil, nil, nil, nil, nil))
+
+	var matched, cancelled, decremented []*Order
+	setup := func(mode string) *Book {
+		matched, cancelled, decremented = make([]*Order, 0), make([]*Order, 0), make([]*Order, 0)
+		return NewBook(ctx, "market", mode, func(taker, maker *Order, amount number.Integer) string {
+			matched = append(matched, maker)
+			return "TRADE-ID"
+		}, func(order *Order) {
+			cancelled = append(cancelled, order)
+		}, func(order *Order) {
+		}, func(order, opponent *Order, amount, funds number.Integer) {
+			decremented = append(decremented, order)
+		}, func(order *Order, amount, funds number.Integer) {
+		})
+	}
+	userOrder := func(side string, price, amount int64, userId string) *Order {
+		order := testLimitOrder(side, price, amount, TimeInForceGTC)
+		order.UserId = userId
+		return order
+	}
+
+	book := setup(SelfTradeCancelNewest)
+	ao1 := userOrder(PageSideAsk, 10000, 100, "alice")
+	book.createOrder(ctx, ao1)
+	ao2 := userOrder(PageSideAsk, 10000, 100, "bob")
+	book.createOrder(ctx, ao2)
+	bo1 := userOrder(PageSideBid, 10000, 150, "alice")
+	book.createOrder(ctx, bo1)
+	assert.Len(matched, 0)
+	assert.Len(cancelled, 1)
+	assert.Equal(bo1.Id, cancelled[0].Id)
+	assert.Equal("20", book.asks.entries[10000].Amount.Persist())
+
+	book = setup(SelfTradeCancelOldest)
+	ao1 = userOrder(PageSideAsk, 10000, 100, "alice")
+	book.createOrder(ctx, ao1)
+	ao2 = userOrder(PageSideAsk, 10000, 100, "bob")
+	book.createOrder(ctx, ao2)
+	bo1 = userOrder(PageSideBid, 10000, 150, "alice")
+	book.createOrder(ctx, bo1)
+	assert.Len(matched, 1)
+	assert.Equal(ao2.Id, matched[0].Id)
+	assert.Len(cancelled, 1)
+	assert.Equal(ao1.Id, cancelled[0].Id)
+	assert.Nil(book.asks.entries[10000])
+	assert.Equal("500", book.bids.entries[10000].Funds.Persist())
+
+	book = setup(SelfTradeCancelBoth)
+	ao1 = userOrder(PageSideAsk, 10000, 100, "alice")
+	book.createOrder(ctx, ao1)
+	bo1 = userOrder(PageSideBid, 10000, 150, "alice")
+	book.createOrder(ctx, bo1)
+	assert.Len(matched, 0)
+	assert.Len(cancelled, 2)
+	assert.Equal(ao1.Id, cancelled[0].Id)
+	assert.Equal(bo1.Id, cancelled[1].Id)
+	assert.Nil(book.asks.entries[10000])
+	assert.Nil(book.bids.entries[10000])
+
+	book = setup(SelfTradeDecrementAndCancel)
+	ao1 = userOrder(PageSideAsk, 10000, 100, "alice")
+	book.createOrder(ctx, ao1)
+	bo1 = userOrder(PageSideBid, 10000, 40, "alice")
+	book.createOrder(ctx, bo1)
+	assert.Len(matched, 0)
+	assert.Len(cancelled, 1)
+	assert.Equal(bo1.Id, cancelled[0].Id)
+	assert.Len(decremented, 1)
+	assert.Equal(ao1.Id, decremented[0].Id)
+	assert.Equal("6", ao1.RemainingAmount.Persist())
+	assert.Equal("0", ao1.FilledAmount.Persist())
+	assert.Equal("6", book.asks.entries[10000].Amount.Persist())
+
+	bo2 := userOrder(PageSideBid, 10000, 100, "alice")
+	book.createOrder(ctx, bo2)
+	assert.Len(cancelled, 2)
+	assert.Equal(ao1.Id, cancelled[1].Id)
+	assert.Len(decremented, 2)
+	assert.Equal(bo2.Id, decremented[1].Id)
+	assert.Equal("400", bo2.RemainingFunds.Persist())
+	assert.Nil(book.asks.entries[10000])
+	assert.Equal("400", book.bids.entries[10000].Funds.Persist())
+
+	for _, mode := range []string{SelfTradeCancelNewest, SelfTradeCancelOldest, SelfTradeCancelBoth, SelfTradeDecrementAndCancel} {
+		book = setup(mode)
+		ao1 = userOrder(PageSideAsk, 10000, 50, "alice")
+		book.createOrder(ctx, ao1)
+		ao2 = userOrder(PageSideAsk, 10000, 50, "bob")
+		book.createOrder(ctx, ao2)
+		bo1 = userOrder(PageSideBid, 10000, 100, "alice")
+		bo1.TimeInForce = TimeInForceFOK
+		book.createOrder(ctx, bo1)
+		assert.Len(matched, 0, mode)
+		assert.Len(decremented, 0, mode)
+		assert.Len(cancelled, 1, mode)
+		assert.Equal(bo1.Id, cancelled[0].Id, mode)
+		assert.Equal("1000", bo1.RemainingFunds.Persist(), mode)
+		assert.Equal("10", book.asks.entries[10000].Amount.Persist(), mode)
+	}
+
+	book = setup(SelfTradeCancelOldest)
+	ao1 = userOrder(PageSideAsk, 10000, 50, "alice")
+	book.createOrder(ctx, ao1)
+	ao2 = userOrder(PageSideAsk, 10000, 100, "bob")
+	book.createOrder(ctx, ao2)
+	bo1 = userOrder(PageSideBid, 10000, 100, "alice")
+	bo1.TimeInForce = TimeInForceFOK
+	book.createOrder(ctx, bo1)
+	assert.Len(matched, 1)
+	assert.Equal(ao2.Id, matched[0].Id)
+	assert.Len(cancelled, 1)
+	assert.Equal(ao1.Id, cancelled[0].Id)
+	assert.True(bo1.filled())
+}
+
+func TestBookIceberg(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+
+	matched := make([]*Order, 0)
+	book := NewBook(ctx, "market", "", func(taker, maker *Order, amount number.Integer) string {
+		matched = append(matched, maker)
+		return "TRADE-ID"
+	}, func(order *Order) {
+	}, func(order *Order) {
+	}, func(order, opponent *Order, amount, funds number.Integer) {
+	}, func(order *Order, amount, funds number.Integer) {
+	})
+
+	ao1 := testLimitOrder(PageSideAsk, 10000, 100, TimeInForceGTC)
+	ao1.DisplayAmount = number.NewInteger(30, 1)
+	book.createOrder(ctx, ao1)
+	ao2 := testLimitOrder(PageSideAsk, 10000, 20, TimeInForceGTC)
+	book.createOrder(ctx, ao2)
+	assert.Equal("5", book.asks.entries[10000].Amount.Persist())
+
+	bo1 := testLimitOrder(PageSideBid, 10000, 40, TimeInForceGTC)
+	book.createOrder(ctx, bo1)
+	assert.Len(matched, 2)
+	assert.Equal(ao1.Id, matched[0].Id)
+	assert.Equal(ao2.Id, matched[1].Id)
+	assert.Equal("7", ao1.RemainingAmount.Persist())
+	assert.Equal("1", ao2.RemainingAmount.Persist())
+	assert.Equal("4", book.asks.entries[10000].Amount.Persist())
+	assert.Equal(ao1.Id, book.asks.entries[10000].list.Back().Value.(*Order).Id)
+
+	bo2 := testLimitOrder(PageSideBid, 10000, 90, TimeInForceGTC)
+	book.createOrder(ctx, bo2)
+	assert.Len(matched, 6)
+	assert.Equal(ao2.Id, matched[2].Id)
+	assert.Equal(ao1.Id, matched[3].Id)
+	assert.Equal(ao1.Id, matched[5].Id)
+	assert.Equal("0", ao2.RemainingAmount.Persist())
+	assert.Equal("0", ao1.RemainingAmount.Persist())
+	assert.Nil(book.asks.entries[10000])
+	assert.Equal("100", book.bids.entries[10000].Funds.Persist())
+
+	bo3 := testLimitOrder(PageSideBid, 9000, 50, TimeInForceGTC)
+	bo3.DisplayAmount = number.NewInteger(10, 1)
+	book.createOrder(ctx, bo3)
+	assert.Equal("90", book.bids.entries[9000].Funds.Persist())
+	ao3 := testLimitOrder(PageSideAsk, 9000, 30, TimeInForceFOK)
+	book.createOrder(ctx, ao3)
+	assert.Len(matched, 9)
+	assert.Equal(bo2.Id, matched[6].Id)
+	assert.Equal(bo3.Id, matched[8].Id)
+	assert.Equal("0", ao3.RemainingAmount.Persist())
+	assert.Equal("270", bo3.RemainingFunds.Persist())
+	assert.Equal("90", book.bids.entries[9000].Funds.Persist())
+}
+
+func TestBookAmend(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+
+	matched, amended := make([]*Order, 0), make([]number.Integer, 0)
+	book := NewBook(ctx, "market", "", func(taker, maker *Order, amount number.Integer) string {
+		matched = append(matched, maker)
+		return "TRADE-ID"
+	}, func(order *Order) {
+	}, func(order *Order) {
+	}, func(order, opponent *Order, amount, funds number.Integer) {
+	}, func(order *Order, amount, funds number.Integer) {
+		amended = append(amended, amount)
+	})
+
+	ao1 := testLimitOrder(PageSideAsk, 10000, 100, TimeInForceGTC)
+	book.createOrder(ctx, ao1)
+	ao2 := testLimitOrder(PageSideAsk, 10000, 100, TimeInForceGTC)
+	book.createOrder(ctx, ao2)
+	ao3 := testLimitOrder(PageSideAsk, 11000, 100, TimeInForceGTC)
+	book.createOrder(ctx, ao3)
+
+	amend := *ao1
+	amend.RemainingAmount = number.NewInteger(40, 1)
+	book.amendOrder(ctx, &amend)
+	assert.Len(amended, 1)
+	assert.Equal("6", amended[0].Persist())
+	assert.Equal("4", ao1.RemainingAmount.Persist())
+	assert.Equal("14", book.asks.entries[10000].Amount.Persist())
+
+	amend = *ao1
+	amend.RemainingAmount = number.NewInteger(200, 1)
+	book.amendOrder(ctx, &amend)
+	assert.Len(amended, 1)
+
+	bo1 := testLimitOrder(PageSideBid, 10000, 30, TimeInForceGTC)
+	book.createOrder(ctx, bo1)
+	assert.Len(matched, 1)
+	assert.Equal(ao1.Id, matched[0].Id)
+	assert.Equal("1", ao1.RemainingAmount.Persist())
+
+	amend = *ao3
+	amend.Price = number.NewInteger(10000, 2)
+	book.amendOrder(ctx, &amend)
+	assert.Len(amended, 2)
+	assert.True(amended[1].IsZero())
+	assert.Equal(int64(10000), ao3.Price.Value())
+	assert.Nil(book.asks.entries[11000])
+	assert.Equal("21", book.asks.entries[10000].Amount.Persist())
+	assert.Equal(ao3.Id, book.asks.entries[10000].list.Back().Value.(*Order).Id)
+
+	amend = *ao2
+	amend.Price = number.NewInteger(9000, 2)
+	book.amendOrder(ctx, &amend)
+	assert.Equal(int64(9000), book.asks.Get(ao2.Id).Price.Value())
+	assert.Equal("11", book.asks.entries[10000].Amount.Persist())
+	bo2 := testLimitOrder(PageSideBid, 9000, 150, TimeInForceGTC)
+	book.createOrder(ctx, bo2)
+	assert.Len(matched, 2)
+	assert.Equal(ao2.Id, matched[1].Id)
+	assert.Equal("450", book.bids.entries[9000].Funds.Persist())
+
+	amend = *bo2
+	amend.Price = number.NewInteger(10000, 2)
+	book.amendOrder(ctx, &amend)
+	assert.Len(matched, 4)
+	assert.Equal(ao1.Id, matched[2].Id)
+	assert.Equal(ao3.Id, matched[3].Id)
+	assert.Equal("0", ao1.RemainingAmount.Persist())
+	assert.Equal("6.5", ao3.RemainingAmount.Persist())
+	assert.Nil(book.bids.Get(bo2.Id))
+}
+
+func TestBookState(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+
+	matched, cancelled := make([]*Order, 0), make([]*Order, 0)
+	book := NewBook(ctx, "market", "", func(taker, maker *Order, amount number.Integer) string {
+		matched = append(matched, maker)
+		return "TRADE-ID"
+	}, func(order *Order) {
+		cancelled = append(cancelled, order)
+	}, func(order *Order) {
+	}, func(order, opponent *Order, amount, funds number.Integer) {
+	}, func(order *Order, amount, funds number.Integer) {
+	})
+
+	ao1 := testLimitOrder(PageSideAsk, 10000, 100, TimeInForceGTC)
+	book.handleOrderEvent(ctx, &OrderEvent{Order: ao1, Action: OrderActionCreate})
+	ao2 := testLimitOrder(PageSideAsk, 10100, 100, TimeInForceGTC)
+	book.handleOrderEvent(ctx, &OrderEvent{Order: ao2, Action: OrderActionCreate})
+
+	book.setState(ctx, BookStateHalted)
+	bo1 := testLimitOrder(PageSideBid, 10000, 50, TimeInForceGTC)
+	book.handleOrderEvent(ctx, &OrderEvent{Order: bo1, Action: OrderActionCreate})
+	book.handleOrderEvent(ctx, &OrderEvent{Order: ao2, Action: OrderActionCancel})
+	assert.Len(matched, 0)
+	assert.Len(cancelled, 0)
+	assert.Len(book.halted, 2)
+	assert.Len(book.snapshot().Halted, 2)
+
+	book.setState(ctx, BookStateCancelOnly)
+	assert.Len(book.halted, 0)
+	assert.Len(matched, 0)
+	assert.Len(cancelled, 2)
+	assert.Equal(bo1.Id, cancelled[0].Id)
+	assert.Equal(ao2.Id, cancelled[1].Id)
+	assert.Nil(book.bids.Get(bo1.Id))
+
+	amend := *ao1
+	amend.RemainingAmount = number.NewInteger(40, 1)
+	book.handleOrderEvent(ctx, &OrderEvent{Order: &amend, Action: OrderActionAmend})
+	assert.Equal("10", book.asks.Get(ao1.Id).RemainingAmount.Persist())
+	book.handleOrderEvent(ctx, &OrderEvent{Order: bo1, Action: OrderActionCreate})
+	assert.Len(cancelled, 2)
+
+	book.setState(ctx, BookStateActive)
+	bo2 := testLimitOrder(PageSideBid, 10000, 50, TimeInForceGTC)
+	book.handleOrderEvent(ctx, &OrderEvent{Order: bo2, Action: OrderActionCreate})
+	assert.Len(matched, 1)
+	assert.Equal(ao1.Id, matched[0].Id)
+	assert.Equal("5", ao1.RemainingAmount.Persist())
+}
+
+func BenchmarkBookCreateIndex(b *testing.B) {
+	const orders, interval = 4000000, 10000
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+
+	expiredAt := time.Now().Add(-time.Minute)
+	actionAt := time.Now()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		book := testSnapshotBook(ctx)
+		for i := 0; i < orders; i++ {
+			order := testLimitOrder(PageSideAsk, 10000, 100, TimeInForceGTC)
+			order.Id = fmt.Sprint(i)
+			order.ExpiredAt = expiredAt
+			order.ActionAt = actionAt.Add(time.Duration(i) * time.Millisecond)
+			book.createOrder(ctx, order)
+			book.createOrder(ctx, order)
+			if i%interval == interval-1 {
+				book.checkpoint(order.ActionAt)
+			}
+			if book.createIndex.Len() > interval {
+				b.Fatal(book.createIndex.Len())
+			}
+		}
+	}
+}
+
+func TestBookDeltas(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+
+	deltas := func(book *Book) []string {
+		levels := make([]string, 0)
+		for _, e := range book.levelDeltas() {
+			levels = append(levels, fmt.Sprintf("%s %s %s %s", e.Side, e.Price, e.Amount, e.Funds))
+		}
+		return levels
+	}
+
+	book := testSnapshotBook(ctx)
+	ask1 := testLimitOrder(PageSideAsk, 10000, 100, TimeInForceGTC)
+	ask2 := testLimitOrder(PageSideAsk, 10100, 100, TimeInForceGTC)
+	bid := testLimitOrder(PageSideBid, 9900, 50, TimeInForceGTC)
+	book.createOrder(ctx, ask1)
+	book.createOrder(ctx, ask2)
+	book.createOrder(ctx, bid)
+	book.createOrder(ctx, testLimitOrder(PageSideBid, 9900, 10, TimeInForceGTC))
+	assert.Equal([]string{"ASK 100 10 1000", "ASK 101 10 1010", "BID 99 6 594"}, deltas(book))
+	assert.Len(deltas(book), 0)
+
+	book.createOrder(ctx, testLimitOrder(PageSideBid, 10000, 40, TimeInForceGTC))
+	assert.Equal([]string{"ASK 100 6 600"}, deltas(book))
+	book.createOrder(ctx, testLimitOrder(PageSideBid, 10100, 100, TimeInForceGTC))
+	assert.Equal([]string{"ASK 100 0 0", "ASK 101 6 606"}, deltas(book))
+
+	book.cancelOrder(ctx, bid)
+	assert.Equal([]string{"BID 99 1 99"}, deltas(book))
+	amended := *ask2
+	amended.Price = number.NewInteger(10200, 2)
+	book.amendOrder(ctx, &amended)
+	assert.Equal([]string{"ASK 101 0 0", "ASK 102 6 612"}, deltas(book))
+}
+
+func TestBookTradeEvents(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+	if err := cache.Redis(ctx).Ping().Err(); err != nil {
+		t.Skip("redis unavailable", err)
+	}
+
+	pubsub := cache.Redis(ctx).Subscribe("TRADES")
+	defer pubsub.Close()
+	_, err := pubsub.Receive()
+	assert.Nil(err)
+	market, _ := uuid.NewV4()
+	messages := pubsub.Channel()
+	receive := func(timeout time.Duration) *cache.Event {
+		for {
+			select {
+			case msg := <-messages:
+				var e cache.Event
+				assert.Nil(json.Unmarshal([]byte(msg.Payload), &e))
+				if e.Market == market.String() {
+					return &e
+				}
+			case <-time.After(timeout):
+				return nil
+			}
+		}
+	}
+
+	transacting, transacted := make(chan bool), make(chan bool)
+	book := NewBook(ctx, market.String(), "", func(taker, maker *Order, amount number.Integer) string {
+		transacting <- true
+		<-transacted
+		return "TRADE-ID"
+	}, func(order *Order) {
+	}, func(order *Order) {
+	}, func(order, opponent *Order, amount, funds number.Integer) {
+	}, func(order *Order, amount, funds number.Integer) {
+	})
+	go book.Run(ctx)
+	book.AttachOrderEvent(ctx, testLimitOrder(PageSideAsk, 10000, 10, TimeInForceGTC), OrderActionCreate)
+	book.AttachOrderEvent(ctx, testLimitOrder(PageSideBid, 10000, 10, TimeInForceGTC), OrderActionCreate)
+
+	// the trade is emitted only after the transact callback persists it
+	<-transacting
+	assert.Nil(receive(500 * time.Millisecond))
+	close(transacted)
+	e := receive(time.Second)
+	assert.NotNil(e)
+	assert.Equal(cache.EventTypeTrade, e.Type)
+	assert.Equal("TRADE-ID", e.Data["trade_id"])
+	assert.Equal(PageSideBid, e.Data["side"])
+	assert.Equal("100", e.Data["price"])
+	assert.Equal("1", e.Data["amount"])
+}
+
+func testLimitOrder(side string, price, amount int64, timeInForce string) *Order {
+	id, _ := uuid.NewV4()
+	order := &Order{
+		Id:              id.String(),
+		Side:            side,
+		Type:            OrderTypeLimit,
+		Price:           number.NewInteger(price, 2),
+		RemainingAmount: number.NewInteger(0, 1),
+		FilledAmount:    number.NewInteger(0, 1),
+		RemainingFunds:  number.NewInteger(0, 3),
+		FilledFunds:     number.NewInteger(0, 3),
+		TimeInForce:     timeInForce,
+	}
+	if side == PageSideAsk {
+		order.RemainingAmount = number.NewInteger(amount, 1)
+	} else {
+		order.RemainingFunds = order.Price.Mul(number.NewInteger(amount, 1))
+	}
+	return order
+}
+
+func testStopOrder(side, typ string, trigger, price, amount int64) *Order {
+	order := testLimitOrder(side, price, amount, TimeInForceGTC)
+	order.Type = typ
+	order.TriggerPrice = number.NewInteger(trigger, 2)
+	if typ == OrderTypeStopMarket {
+		order.Price = number.NewInteger(0, 2)
+		order.TimeInForce = ""
+	}
+	return order
+}
+
 func testSetupRedis(ctx context.Context) context.Context {
 	redisClient := redis.NewClient(&redis.Options{
 		Addr:         config.RedisEngineCacheAddress,
@@ -0,0 +1,57 @@
+package engine
+
+import "time"
+
+// Index deduplicates the order actions of a book, a key is kept until a snapshot
+// checkpoint passes the created_at of its action, because the pending actions are
+// listed from the checkpoint and the earlier ones never reach the book again.
+type Index struct {
+	entries []indexEntry
+	set     map[string]bool
+}
+
+type indexEntry struct {
+	key string
+	at  time.Time
+}
+
+func NewIndex() *Index {
+	return &Index{
+		entries: make([]indexEntry, 0),
+		set:     make(map[string]bool),
+	}
+}
+
+func (index *Index) Add(key string, at time.Time) bool {
+	if index.set[key] {
+		return false
+	}
+	index.entries = append(index.entries, indexEntry{key, at})
+	index.set[key] = true
+	return true
+}
+
+// Evict removes the keys added before the checkpoint in the order they are added,
+// the actions arrive by created_at, so a key out of order is only kept longer.
+func (index *Index) Evict(checkpoint time.Time) int {
+	evicted := 0
+	for evicted < len(index.entries) && index.entries[evicted].at.Before(checkpoint) {
+		delete(index.set, index.entries[evicted].key)
+		index.entries[evicted] = indexEntry{}
+		evicted++
+	}
+	index.entries = index.entries[evicted:]
+	return evicted
+}
+
+func (index *Index) Len() int {
+	return len(index.entries)
+}
+
+func (index *Index) Keys() []string {
+	keys := make([]string, len(index.entries))
+	for i, e := range index.entries {
+		keys[i] = e.key
+	}
+	return keys
+}
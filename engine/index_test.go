@@ -0,0 +1,33 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndex(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	index := NewIndex()
+	assert.True(index.Add("a", now))
+	assert.True(index.Add("b", now.Add(time.Second)))
+	assert.False(index.Add("a", now.Add(time.Second)))
+	assert.True(index.Add("c", now.Add(2*time.Second)))
+	assert.Equal(3, index.Len())
+	assert.Equal([]string{"a", "b", "c"}, index.Keys())
+
+	assert.Equal(0, index.Evict(now))
+	assert.Equal(1, index.Evict(now.Add(time.Second)))
+	assert.Equal([]string{"b", "c"}, index.Keys())
+	assert.True(index.Add("a", now))
+	assert.False(index.Add("b", now))
+	assert.Equal(1, index.Evict(now.Add(2*time.Second)))
+	assert.Equal([]string{"c", "a"}, index.Keys())
+	assert.False(index.Add("a", now))
+	assert.Equal(2, index.Evict(now.Add(3*time.Second)))
+	assert.Equal(0, index.Len())
+	assert.True(index.Add("c", now))
+}
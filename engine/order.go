@@ -2,13 +2,21 @@ package engine
 
 import (
 	"log"
+	"time"
 
 	"github.com/MixinNetwork/go-number"
 )
 
 const (
-	OrderTypeLimit  = "LIMIT"
-	OrderTypeMarket = "MARKET"
+	OrderTypeLimit      = "LIMIT"
+	OrderTypeMarket     = "MARKET"
+	OrderTypeStopLimit  = "STOP_LIMIT"
+	OrderTypeStopMarket = "STOP_MARKET"
+
+	TimeInForceGTC      = "GTC"
+	TimeInForceIOC      = "IOC"
+	TimeInForceFOK      = "FOK"
+	TimeInForcePostOnly = "POST_ONLY"
 )
 
 type Order struct {
@@ -20,11 +28,20 @@ type Order struct {
 	FilledAmount    number.Integer
 	RemainingFunds  number.Integer
 	FilledFunds     number.Integer
+	TriggerPrice    number.Integer
+	TimeInForce     string
+	ExpiredAt       time.Time
+	DisplayAmount   number.Integer
 
 	Quote    string
 	Base     string
 	UserId   string
 	BrokerId string
+
+	// the visible slice of an iceberg order on the book, the amount of an ask or the funds of a bid
+	Displayed number.Integer
+	// the created_at of the action carrying the order, the create and cancel indexes evict by it
+	ActionAt time.Time
 }
 
 func (order *Order) filled() bool {
@@ -34,6 +51,122 @@ func (order *Order) filled() bool {
 	return order.RemainingFunds.IsZero()
 }
 
+func (order *Order) fill(amount, funds number.Integer) {
+	order.FilledAmount = order.FilledAmount.Add(amount)
+	order.FilledFunds = order.FilledFunds.Add(funds)
+	order.decrement(amount, funds)
+}
+
+func (order *Order) decrement(amount, funds number.Integer) {
+	if order.Side == PageSideAsk {
+		order.RemainingAmount = order.RemainingAmount.Sub(amount)
+		if !order.Displayed.IsZero() {
+			order.Displayed = order.Displayed.Sub(amount)
+		}
+	}
+	if order.Side == PageSideBid {
+		order.RemainingFunds = order.RemainingFunds.Sub(funds)
+		if !order.Displayed.IsZero() {
+			order.Displayed = order.Displayed.Sub(funds)
+		}
+	}
+}
+
+func (order *Order) iceberg() bool {
+	return !order.DisplayAmount.IsZero()
+}
+
+func (order *Order) remaining() number.Integer {
+	if order.Side == PageSideAsk {
+		return order.RemainingAmount
+	}
+	return order.RemainingFunds
+}
+
+func (order *Order) slice() number.Integer {
+	remaining := order.remaining()
+	if !order.iceberg() {
+		return remaining
+	}
+	display := order.DisplayAmount
+	if order.Side == PageSideBid {
+		display = order.DisplayAmount.Mul(order.Price)
+	}
+	if display.Cmp(remaining) < 0 {
+		return display
+	}
+	return remaining
+}
+
+func (order *Order) visible() number.Integer {
+	if !order.iceberg() {
+		return order.remaining()
+	}
+	return order.Displayed
+}
+
+func (order *Order) resize(quantity number.Integer) {
+	if order.Side == PageSideAsk {
+		order.RemainingAmount = quantity
+	} else {
+		order.RemainingFunds = quantity
+	}
+	if order.iceberg() && order.Displayed.Cmp(quantity) > 0 {
+		order.Displayed = quantity
+	}
+}
+
+func (order *Order) replenish() bool {
+	if !order.iceberg() || order.filled() || !order.Displayed.IsZero() {
+		return false
+	}
+	order.Displayed = order.slice()
+	return true
+}
+
+func (order *Order) crosses(opponent *Order) bool {
+	if order.Type != OrderTypeLimit {
+		return true
+	}
+	if order.Side == PageSideAsk {
+		return opponent.Price.Cmp(order.Price) >= 0
+	}
+	return opponent.Price.Cmp(order.Price) <= 0
+}
+
+func (order *Order) resting() bool {
+	if order.Type != OrderTypeLimit {
+		return false
+	}
+	return order.TimeInForce != TimeInForceIOC && order.TimeInForce != TimeInForceFOK
+}
+
+func (order *Order) expired(now time.Time) bool {
+	return !order.ExpiredAt.IsZero() && !order.ExpiredAt.After(now)
+}
+
+func (order *Order) stopped() bool {
+	return order.Type == OrderTypeStopLimit || order.Type == OrderTypeStopMarket
+}
+
+func (order *Order) triggered(price number.Integer) bool {
+	if order.Side == PageSideAsk {
+		return price.Cmp(order.TriggerPrice) <= 0
+	}
+	return price.Cmp(order.TriggerPrice) >= 0
+}
+
+func (order *Order) release() {
+	switch order.Type {
+	case OrderTypeStopLimit:
+		order.Type = OrderTypeLimit
+	case OrderTypeStopMarket:
+		order.Type = OrderTypeMarket
+	default:
+		log.Panicln(order)
+	}
+}
+
 func (order *Order) assert() {
 	switch order.Side {
 	case PageSideAsk:
@@ -54,7 +187,15 @@ func (order *Order) assert() {
 			log.Panicln(order)
 		}
 	case OrderTypeMarket:
-		if !order.Price.IsZero() {
+		if !order.Price.IsZero() || order.iceberg() {
+			log.Panicln(order)
+		}
+	case OrderTypeStopLimit:
+		if order.Price.IsZero() || order.TriggerPrice.IsZero() {
+			log.Panicln(order)
+		}
+	case OrderTypeStopMarket:
+		if !order.Price.IsZero() || order.TriggerPrice.IsZero() || order.iceberg() {
 			log.Panicln(order)
 		}
 	default:
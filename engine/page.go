@@ -1,10 +1,10 @@
 package engine
 
 import (
+	"container/list"
 	"log"
 
 	"github.com/MixinNetwork/go-number"
-	"github.com/emirpasic/gods/lists/arraylist"
 	"github.com/emirpasic/gods/trees/redblacktree"
 )
 
@@ -18,14 +18,23 @@ type Entry struct {
 	Price  number.Integer `json:"price"`
 	Amount number.Decimal `json:"amount"`
 	Funds  number.Decimal `json:"funds"`
-	list   *arraylist.List
-	orders map[string]*Order
+	list   *list.List
+	orders map[string]*list.Element
+}
+
+type OrderEntry struct {
+	OrderId string         `json:"order_id"`
+	Side    string         `json:"side"`
+	Price   number.Integer `json:"price"`
+	Amount  number.Decimal `json:"amount"`
+	Funds   number.Decimal `json:"funds"`
 }
 
 type Page struct {
 	Side    string
 	points  *redblacktree.Tree
 	entries map[int64]*Entry
+	orders  map[string]*Order
 }
 
 func NewPage(side string) *Page {
@@ -36,10 +45,27 @@ func NewPage(side string) *Page {
 		Side:    side,
 		points:  redblacktree.NewWith(entryCompare),
 		entries: make(map[int64]*Entry),
+		orders:  make(map[string]*Order),
 	}
 }
 
 func (page *Page) Put(order *Order) {
+	if order.iceberg() {
+		order.Displayed = order.slice()
+	}
+	page.insert(order)
+}
+
+// Restore puts the order of a snapshot back with its displayed slice, an iceberg order
+// is only sliced again if the slice is missing.
+func (page *Page) Restore(order *Order) {
+	if order.iceberg() && order.Displayed.IsZero() {
+		order.Displayed = order.slice()
+	}
+	page.insert(order)
+}
+
+func (page *Page) insert(order *Order) {
 	if page.Side != order.Side {
 		log.Panicln(page, order)
 	}
@@ -50,8 +76,8 @@ func (page *Page) Put(order *Order) {
 			Price:  order.Price,
 			Amount: number.Zero(),
 			Funds:  number.Zero(),
-			list:   arraylist.New(),
-			orders: make(map[string]*Order),
+			list:   list.New(),
+			orders: make(map[string]*list.Element),
 		}
 		page.entries[entry.Price.Value()] = entry
 		page.points.Put(entry, true)
@@ -62,76 +88,106 @@ func (page *Page) Put(order *Order) {
 	if _, found := entry.orders[order.Id]; found {
 		log.Panicln(order)
 	}
-	if entry.Side == PageSideAsk {
-		entry.Amount = entry.Amount.Add(order.RemainingAmount.Decimal())
-	} else {
-		entry.Funds = entry.Funds.Add(order.RemainingFunds.Decimal())
+	entry.add(order.visible())
+	entry.orders[order.Id] = entry.list.PushBack(order)
+	page.orders[order.Id] = order
+}
+
+func (page *Page) Get(id string) *Order {
+	return page.orders[id]
+}
+
+func (page *Page) Resize(o *Order, quantity number.Integer) *Order {
+	order, found := page.orders[o.Id]
+	if !found {
+		return nil
+	}
+	entry, found := page.entries[order.Price.Value()]
+	if !found {
+		log.Panicln(order)
 	}
-	entry.orders[order.Id] = order
-	entry.list.Add(order.Id)
+	entry.sub(order.visible())
+	order.resize(quantity)
+	entry.add(order.visible())
+	return order
 }
 
 func (page *Page) Remove(o *Order) *Order {
 	if page.Side != o.Side {
 		return nil
 	}
-	entry, found := page.entries[o.Price.Value()]
+	order, found := page.orders[o.Id]
 	if !found {
 		return nil
 	}
-	order, found := entry.orders[o.Id]
+	entry, found := page.entries[order.Price.Value()]
 	if !found {
-		return nil
+		log.Panicln(order)
 	}
-	index := entry.list.IndexOf(order.Id)
-	if index < 0 {
+	element, found := entry.orders[order.Id]
+	if !found {
 		log.Panicln(order)
 	}
 	delete(entry.orders, order.Id)
-	if entry.Side == PageSideAsk {
-		entry.Amount = entry.Amount.Sub(order.RemainingAmount.Decimal())
-	} else {
-		entry.Funds = entry.Funds.Sub(order.RemainingFunds.Decimal())
+	delete(page.orders, order.Id)
+	entry.sub(order.visible())
+	entry.list.Remove(element)
+	if entry.list.Len() == 0 {
+		delete(page.entries, entry.Price.Value())
+		page.points.Remove(entry)
 	}
-	entry.list.Remove(index)
 	return order
 }
 
 func (page *Page) Iterate(hook func(*Order) (number.Integer, number.Integer, bool)) {
 	for it := page.points.Iterator(); it.Next(); {
 		entry := it.Key().(*Entry)
-		for eit := entry.list.Iterator(); eit.Next(); {
-			order := entry.orders[eit.Value().(string)]
+		for element := entry.list.Front(); element != nil; {
+			order, next := element.Value.(*Order), element.Next()
 			matchedAmount, matchedFunds, done := hook(order)
 			if entry.Side == PageSideAsk {
-				entry.Amount = entry.Amount.Sub(matchedAmount.Decimal())
+				entry.sub(matchedAmount)
 			} else {
-				entry.Funds = entry.Funds.Sub(matchedFunds.Decimal())
+				entry.sub(matchedFunds)
 			}
+			if order.replenish() {
+				entry.add(order.visible())
+				entry.list.MoveToBack(element)
+				if next == nil {
+					next = element
+				}
+			}
+			element = next
 			if done {
-				eit.End()
-				it.End()
+				return
 			}
 		}
 	}
 }
 
+func (page *Page) Orders() []*Order {
+	orders := make([]*Order, 0, len(page.orders))
+	for it := page.points.Iterator(); it.Next(); {
+		entry := it.Key().(*Entry)
+		for element := entry.list.Front(); element != nil; element = element.Next() {
+			orders = append(orders, element.Value.(*Order))
+		}
+	}
+	return orders
+}
+
+func (page *Page) Level(price number.Integer) *Entry {
+	entry, found := page.entries[price.Value()]
+	if !found {
+		return &Entry{Side: page.Side, Price: price, Amount: number.Zero(), Funds: number.Zero()}
+	}
+	return entry.level()
+}
+
 func (page *Page) List(count int, filterEmpty bool) []*Entry {
 	entries := make([]*Entry, 0)
 	for it := page.points.Iterator(); it.Next(); {
-		ie := it.Key().(*Entry)
-		entry := &Entry{
-			Side:   ie.Side,
-			Price:  ie.Price,
-			Amount: ie.Amount,
-			Funds:  ie.Funds,
-		}
-		price := ie.Price.Decimal()
-		if entry.Amount.IsZero() {
-			entry.Amount = entry.Funds.Div(price)
-		} else if entry.Funds.IsZero() {
-			entry.Funds = price.Mul(entry.Amount)
-		}
+		entry := it.Key().(*Entry).level()
 		if filterEmpty && entry.Funds.IsZero() {
 			continue
 		}
@@ -143,6 +199,58 @@ func (page *Page) List(count int, filterEmpty bool) []*Entry {
 	return entries
 }
 
+func (page *Page) ListOrders() []*OrderEntry {
+	entries := make([]*OrderEntry, 0, len(page.orders))
+	for _, order := range page.Orders() {
+		entry := &Entry{Side: order.Side, Price: order.Price, Amount: number.Zero(), Funds: number.Zero()}
+		entry.add(order.visible())
+		level := entry.level()
+		if level.Funds.IsZero() {
+			continue
+		}
+		entries = append(entries, &OrderEntry{
+			OrderId: order.Id,
+			Side:    level.Side,
+			Price:   level.Price,
+			Amount:  level.Amount,
+			Funds:   level.Funds,
+		})
+	}
+	return entries
+}
+
+func (entry *Entry) level() *Entry {
+	level := &Entry{
+		Side:   entry.Side,
+		Price:  entry.Price,
+		Amount: entry.Amount,
+		Funds:  entry.Funds,
+	}
+	price := entry.Price.Decimal()
+	if level.Amount.IsZero() {
+		level.Amount = level.Funds.Div(price)
+	} else if level.Funds.IsZero() {
+		level.Funds = price.Mul(level.Amount)
+	}
+	return level
+}
+
+func (entry *Entry) add(quantity number.Integer) {
+	if entry.Side == PageSideAsk {
+		entry.Amount = entry.Amount.Add(quantity.Decimal())
+	} else {
+		entry.Funds = entry.Funds.Add(quantity.Decimal())
+	}
+}
+
+func (entry *Entry) sub(quantity number.Integer) {
+	if entry.Side == PageSideAsk {
+		entry.Amount = entry.Amount.Sub(quantity.Decimal())
+	} else {
+		entry.Funds = entry.Funds.Sub(quantity.Decimal())
+	}
+}
+
 func entryCompare(a, b interface{}) int {
 	entry := a.(*Entry)
 	opponent := b.(*Entry)
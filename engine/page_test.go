@@ -1,9 +1,11 @@
 package engine
 
 import (
+	"math/rand"
 	"testing"
 
 	"github.com/MixinNetwork/go-number"
+	"github.com/emirpasic/gods/lists/arraylist"
 	"github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
 )
@@ -116,16 +118,14 @@ func TestPageAsk(t *testing.T) {
 
 	page.Remove(o3)
 	entries = page.List(0, false)
-	assert.Len(entries, 3)
+	assert.Len(entries, 2)
 	e = entries[0]
 	assert.Equal("0.5", e.Amount.Persist())
 	assert.Equal(int64(10000), e.Price.Value())
 	e = entries[1]
 	assert.Equal("4", e.Amount.Persist())
 	assert.Equal(int64(20000), e.Price.Value())
-	e = entries[2]
-	assert.Equal("0", e.Amount.Persist())
-	assert.Equal(int64(30000), e.Price.Value())
+	assert.Nil(page.entries[30000])
 
 	page.Iterate(func(order *Order) (number.Integer, number.Integer, bool) {
 		matchedAmount := number.NewInteger(5, 1)
@@ -135,29 +135,23 @@ func TestPageAsk(t *testing.T) {
 	})
 
 	entries = page.List(0, false)
-	assert.Len(entries, 3)
+	assert.Len(entries, 2)
 	e = entries[0]
 	assert.Equal("0", e.Amount.Persist())
 	assert.Equal(int64(10000), e.Price.Value())
 	e = entries[1]
 	assert.Equal("3.5", e.Amount.Persist())
 	assert.Equal(int64(20000), e.Price.Value())
-	e = entries[2]
-	assert.Equal("0", e.Amount.Persist())
-	assert.Equal(int64(30000), e.Price.Value())
+	assert.Len(page.List(0, true), 1)
 
 	page.Remove(o2)
 	entries = page.List(0, false)
-	assert.Len(entries, 3)
+	assert.Len(entries, 1)
 	e = entries[0]
 	assert.Equal("0", e.Amount.Persist())
 	assert.Equal(int64(10000), e.Price.Value())
-	e = entries[1]
-	assert.Equal("0", e.Amount.Persist())
-	assert.Equal(int64(20000), e.Price.Value())
-	e = entries[2]
-	assert.Equal("0", e.Amount.Persist())
-	assert.Equal(int64(30000), e.Price.Value())
+	assert.Nil(page.entries[20000])
+	assert.Equal(1, page.points.Size())
 }
 
 func TestPageBid(t *testing.T) {
@@ -286,19 +280,16 @@ func TestPageBid(t *testing.T) {
 
 	page.Remove(o3)
 	entries = page.List(0, false)
-	assert.Len(entries, 3)
+	assert.Len(entries, 2)
 	e = entries[0]
-	assert.Equal("0", e.Amount.Persist())
-	assert.Equal("0", e.Funds.Persist())
-	assert.Equal(int64(30000), e.Price.Value())
-	e = entries[1]
 	assert.Equal("4", e.Amount.Persist())
 	assert.Equal("800", e.Funds.Persist())
 	assert.Equal(int64(20000), e.Price.Value())
-	e = entries[2]
+	e = entries[1]
 	assert.Equal("1", e.Amount.Persist())
 	assert.Equal("100", e.Funds.Persist())
 	assert.Equal(int64(10000), e.Price.Value())
+	assert.Nil(page.entries[30000])
 
 	page.Iterate(func(order *Order) (number.Integer, number.Integer, bool) {
 		matchedFunds := number.NewInteger(50000, 3)
@@ -308,33 +299,155 @@ func TestPageBid(t *testing.T) {
 	})
 
 	entries = page.List(0, false)
-	assert.Len(entries, 3)
+	assert.Len(entries, 2)
 	e = entries[0]
-	assert.Equal("0", e.Amount.Persist())
-	assert.Equal("0", e.Funds.Persist())
-	assert.Equal(int64(30000), e.Price.Value())
-	e = entries[1]
 	assert.Equal("3.75", e.Amount.Persist())
 	assert.Equal("750", e.Funds.Persist())
 	assert.Equal(int64(20000), e.Price.Value())
-	e = entries[2]
+	e = entries[1]
 	assert.Equal("0.5", e.Amount.Persist())
 	assert.Equal("50", e.Funds.Persist())
 	assert.Equal(int64(10000), e.Price.Value())
 
 	page.Remove(o2)
 	entries = page.List(0, false)
-	assert.Len(entries, 3)
+	assert.Len(entries, 1)
 	e = entries[0]
-	assert.Equal("0", e.Amount.Persist())
-	assert.Equal("0", e.Funds.Persist())
-	assert.Equal(int64(30000), e.Price.Value())
-	e = entries[1]
-	assert.Equal("0", e.Amount.Persist())
-	assert.Equal("0", e.Funds.Persist())
-	assert.Equal(int64(20000), e.Price.Value())
-	e = entries[2]
 	assert.Equal("0.5", e.Amount.Persist())
 	assert.Equal("50", e.Funds.Persist())
 	assert.Equal(int64(10000), e.Price.Value())
+	assert.Nil(page.entries[20000])
+	assert.Equal(1, page.points.Size())
+}
+
+func TestPageIceberg(t *testing.T) {
+	assert := assert.New(t)
+
+	page := NewPage(PageSideAsk)
+	id, _ := uuid.NewV4()
+	o1 := &Order{
+		Id:              id.String(),
+		Side:            page.Side,
+		Type:            OrderTypeLimit,
+		Price:           number.NewInteger(10000, 2),
+		RemainingAmount: number.NewInteger(100, 1),
+		FilledAmount:    number.NewInteger(0, 1),
+		RemainingFunds:  number.NewInteger(0, 3),
+		FilledFunds:     number.NewInteger(0, 3),
+		DisplayAmount:   number.NewInteger(40, 1),
+	}
+	page.Put(o1)
+	id, _ = uuid.NewV4()
+	o2 := &Order{
+		Id:              id.String(),
+		Side:            page.Side,
+		Type:            OrderTypeLimit,
+		Price:           number.NewInteger(10000, 2),
+		RemainingAmount: number.NewInteger(10, 1),
+		FilledAmount:    number.NewInteger(0, 1),
+		RemainingFunds:  number.NewInteger(0, 3),
+		FilledFunds:     number.NewInteger(0, 3),
+	}
+	page.Put(o2)
+
+	entries := page.List(0, false)
+	assert.Len(entries, 1)
+	assert.Equal("5", entries[0].Amount.Persist())
+
+	matched := make([]string, 0)
+	page.Iterate(func(order *Order) (number.Integer, number.Integer, bool) {
+		matched = append(matched, order.Id)
+		amount := order.visible()
+		order.fill(amount, amount.Mul(order.Price))
+		return amount, amount.Mul(order.Price), order.Id == o2.Id
+	})
+	assert.Equal([]string{o1.Id, o2.Id}, matched)
+	assert.Equal("6", o1.RemainingAmount.Persist())
+	assert.Equal("4", page.List(0, false)[0].Amount.Persist())
+
+	page.Remove(o2)
+	assert.Equal("4", page.List(0, false)[0].Amount.Persist())
+	page.Remove(o1)
+	assert.Len(page.List(0, false), 0)
+}
+
+const benchmarkLevelSize = 10000
+
+func BenchmarkPageRemoveArrayList(b *testing.B) {
+	list, orders := arraylist.New(), benchmarkLevelOrders()
+	for _, o := range orders {
+		list.Add(o.Id)
+	}
+	random := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o := orders[random.Intn(len(orders))]
+		list.Remove(list.IndexOf(o.Id))
+		list.Add(o.Id)
+	}
+}
+
+func BenchmarkPageRemove(b *testing.B) {
+	page, orders := NewPage(PageSideAsk), benchmarkLevelOrders()
+	for _, o := range orders {
+		page.Put(o)
+	}
+	random := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o := orders[random.Intn(len(orders))]
+		page.Remove(o)
+		page.Put(o)
+	}
+}
+
+func BenchmarkPageIterate(b *testing.B) {
+	page, orders := NewPage(PageSideAsk), benchmarkLevelOrders()
+	for _, o := range orders {
+		page.Put(o)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		page.Iterate(func(order *Order) (number.Integer, number.Integer, bool) {
+			return order.RemainingAmount.Zero(), order.RemainingFunds.Zero(), false
+		})
+	}
+}
+
+func benchmarkLevelOrders() []*Order {
+	orders := make([]*Order, benchmarkLevelSize)
+	for i := range orders {
+		orders[i] = testLimitOrder(PageSideAsk, 10000, 100, TimeInForceGTC)
+	}
+	return orders
+}
+
+func TestPageListOrders(t *testing.T) {
+	assert := assert.New(t)
+
+	page := NewPage(PageSideBid)
+	assert.Len(page.ListOrders(), 0)
+	o1 := testLimitOrder(PageSideBid, 9900, 50, TimeInForceGTC)
+	o2 := testLimitOrder(PageSideBid, 10000, 10, TimeInForceGTC)
+	o3 := testLimitOrder(PageSideBid, 9900, 20, TimeInForceGTC)
+	page.Put(o1)
+	page.Put(o2)
+	page.Put(o3)
+
+	orders := page.ListOrders()
+	assert.Len(orders, 3)
+	for i, o := range []*Order{o2, o1, o3} {
+		assert.Equal(o.Id, orders[i].OrderId)
+	}
+	assert.Equal("100", orders[0].Price.Persist())
+	assert.Equal("1", orders[0].Amount.Persist())
+	assert.Equal("100", orders[0].Funds.Persist())
+	assert.Equal("2", orders[2].Amount.Persist())
+	assert.Equal("198", orders[2].Funds.Persist())
+
+	levels := page.List(0, true)
+	assert.Len(levels, 2)
+	assert.Equal("7", levels[1].Amount.Persist())
+	assert.Equal(levels[1].Amount, page.Level(o1.Price).Amount)
+	assert.True(page.Level(number.NewInteger(9800, 2)).Amount.IsZero())
 }
@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+)
+
+type Snapshot struct {
+	Asks        []*Order
+	Bids        []*Order
+	AskStops    []*Order
+	BidStops    []*Order
+	CreateIndex []string
+	CancelIndex []string
+	Checkpoint  time.Time
+	LastPrice   number.Integer
+	LastTrade   *TickerTrade
+	Buckets     []*TickerBucket
+	Halted      []*OrderEvent
+}
+
+func (book *Book) Snapshot(ctx context.Context, checkpoint time.Time) *Snapshot {
+	reply := make(chan *Snapshot)
+	book.events <- &OrderEvent{snapshot: reply, checkpoint: checkpoint}
+	return <-reply
+}
+
+func (book *Book) Restore(ctx context.Context, snapshot *Snapshot) {
+	if book.createIndex.Len() > 0 || book.cancelIndex.Len() > 0 {
+		log.Panicln(book.market)
+	}
+	for _, o := range snapshot.Asks {
+		book.asks.Restore(o)
+	}
+	for _, o := range snapshot.Bids {
+		book.bids.Restore(o)
+	}
+	for _, o := range snapshot.AskStops {
+		book.askStops.Put(o)
+	}
+	for _, o := range snapshot.BidStops {
+		book.bidStops.Put(o)
+	}
+	for _, orders := range [][]*Order{snapshot.Asks, snapshot.Bids, snapshot.AskStops, snapshot.BidStops} {
+		for _, o := range orders {
+			if !o.ExpiredAt.IsZero() {
+				book.deadlines.Put(o, true)
+			}
+		}
+	}
+	for _, id := range snapshot.CreateIndex {
+		book.createIndex.Add(id, snapshot.Checkpoint)
+	}
+	for _, id := range snapshot.CancelIndex {
+		book.cancelIndex.Add(id, snapshot.Checkpoint)
+	}
+	book.lastPrice = snapshot.LastPrice
+	book.lastTrade = snapshot.LastTrade
+	book.buckets = append(book.buckets, copyBuckets(snapshot.Buckets)...)
+	book.halted = append(book.halted, snapshot.Halted...)
+}
+
+// checkpoint evicts the indexed actions before the checkpoint, so a snapshot keeps only
+// the actions at the checkpoint, which are listed again when the book is restored.
+func (book *Book) checkpoint(checkpoint time.Time) *Snapshot {
+	book.createIndex.Evict(checkpoint)
+	book.cancelIndex.Evict(checkpoint)
+	snapshot := book.snapshot()
+	snapshot.Checkpoint = checkpoint
+	return snapshot
+}
+
+func (book *Book) snapshot() *Snapshot {
+	return &Snapshot{
+		Asks:        copyOrders(book.asks.Orders()),
+		Bids:        copyOrders(book.bids.Orders()),
+		AskStops:    copyOrders(book.askStops.Orders()),
+		BidStops:    copyOrders(book.bidStops.Orders()),
+		CreateIndex: book.createIndex.Keys(),
+		CancelIndex: book.cancelIndex.Keys(),
+		LastPrice:   book.lastPrice,
+		LastTrade:   book.lastTrade,
+		Buckets:     copyBuckets(book.buckets),
+		Halted:      copyEvents(book.halted),
+	}
+}
+
+func copyBuckets(buckets []*TickerBucket) []*TickerBucket {
+	copies := make([]*TickerBucket, len(buckets))
+	for i, b := range buckets {
+		bucket := *b
+		copies[i] = &bucket
+	}
+	return copies
+}
+
+func copyEvents(events []*OrderEvent) []*OrderEvent {
+	copies := make([]*OrderEvent, len(events))
+	for i, e := range events {
+		order := *e.Order
+		copies[i] = &OrderEvent{Order: &order, Action: e.Action}
+	}
+	return copies
+}
+
+func copyOrders(orders []*Order) []*Order {
+	copies := make([]*Order, len(orders))
+	for i, o := range orders {
+		order := *o
+		copies[i] = &order
+	}
+	return copies
+}
@@ -0,0 +1,192 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBookSnapshot(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+
+	a1 := testLimitOrder(PageSideAsk, 10000, 100, TimeInForceGTC)
+	a2 := testLimitOrder(PageSideAsk, 10100, 100, TimeInForceGTC)
+	a3 := testLimitOrder(PageSideAsk, 9900, 80, TimeInForceGTC)
+	b1 := testLimitOrder(PageSideBid, 9900, 50, TimeInForceGTC)
+	b2 := testLimitOrder(PageSideBid, 10000, 40, TimeInForceGTC)
+	b3 := testLimitOrder(PageSideBid, 9800, 20, TimeInForceGTC)
+	s1 := testLimitOrder(PageSideAsk, 9900, 30, TimeInForceGTC)
+	s1.Type = OrderTypeStopLimit
+	s1.TriggerPrice = number.NewInteger(9950, 2)
+	s2 := testLimitOrder(PageSideBid, 10500, 10, TimeInForceGTC)
+	s2.Type = OrderTypeStopLimit
+	s2.TriggerPrice = number.NewInteger(10400, 2)
+	amend := *a1
+	amend.RemainingAmount = number.NewInteger(30, 1)
+
+	events := []*OrderEvent{
+		{Order: a1, Action: OrderActionCreate},
+		{Order: a2, Action: OrderActionCreate},
+		{Order: b1, Action: OrderActionCreate},
+		{Order: s1, Action: OrderActionCreate},
+		{Order: s2, Action: OrderActionCreate},
+		{Order: b2, Action: OrderActionCreate},
+		{Order: a2, Action: OrderActionCancel},
+		{Order: b3, Action: OrderActionCreate},
+		{Order: a3, Action: OrderActionCreate},
+		{Order: &amend, Action: OrderActionAmend},
+	}
+	replay := func(book *Book, events []*OrderEvent) {
+		for _, e := range events {
+			order := *e.Order
+			switch e.Action {
+			case OrderActionCreate:
+				book.createOrder(ctx, &order)
+			case OrderActionCancel:
+				book.cancelOrder(ctx, &order)
+			case OrderActionAmend:
+				book.amendOrder(ctx, &order)
+			}
+		}
+	}
+
+	full := testSnapshotBook(ctx)
+	replay(full, events)
+	partial := testSnapshotBook(ctx)
+	replay(partial, events[:8])
+	snapshot := partial.snapshot()
+	assert.Len(snapshot.CreateIndex, 7)
+	assert.Len(snapshot.CancelIndex, 1)
+	assert.Len(snapshot.AskStops, 1)
+	assert.Len(snapshot.BidStops, 1)
+	assert.Equal("100", snapshot.LastPrice.Persist())
+
+	restored := testSnapshotBook(ctx)
+	restored.Restore(ctx, snapshot)
+	assert.Equal(testBookState(partial), testBookState(restored))
+	replay(restored, events[6:])
+	assert.Equal(testBookState(full), testBookState(restored))
+	assert.Equal("99", restored.lastPrice.Persist())
+	assert.Len(restored.askStops.Orders(), 0)
+	assert.Len(restored.bidStops.Orders(), 1)
+	assert.Equal("3", restored.asks.Get(a1.Id).RemainingAmount.Persist())
+	assert.Equal("3", restored.asks.Get(s1.Id).RemainingAmount.Persist())
+
+	halted := testSnapshotBook(ctx)
+	replay(halted, events[:6])
+	halted.setState(ctx, BookStateHalted)
+	for _, e := range events[6:] {
+		order := *e.Order
+		halted.handleOrderEvent(ctx, &OrderEvent{Order: &order, Action: e.Action})
+	}
+	snapshot = halted.snapshot()
+	assert.Len(snapshot.Halted, 4)
+	restored = testSnapshotBook(ctx)
+	restored.Restore(ctx, snapshot)
+	restored.setState(ctx, BookStateActive)
+	assert.Equal(testBookState(full), testBookState(restored))
+}
+
+func TestBookCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+
+	checkpoint := time.Now()
+	action := func(order *Order, at time.Duration) *Order {
+		o := *order
+		o.ActionAt = checkpoint.Add(at)
+		return &o
+	}
+	a1 := testLimitOrder(PageSideAsk, 10000, 100, TimeInForceGTC)
+	a2 := testLimitOrder(PageSideAsk, 10100, 100, TimeInForceGTC)
+	b1 := testLimitOrder(PageSideBid, 10000, 50, TimeInForceIOC)
+	a3 := testLimitOrder(PageSideAsk, 10200, 100, TimeInForceGTC)
+
+	book := testSnapshotBook(ctx)
+	book.createOrder(ctx, action(a1, -2*time.Second))
+	book.createOrder(ctx, action(a2, -time.Second))
+	book.createOrder(ctx, action(b1, 0))
+	book.cancelOrder(ctx, action(a2, 0))
+	snapshot := book.checkpoint(checkpoint)
+	assert.Equal([]string{b1.Id}, snapshot.CreateIndex)
+	assert.Equal([]string{a2.Id}, snapshot.CancelIndex)
+	assert.Equal(checkpoint, snapshot.Checkpoint)
+
+	// the actions at the checkpoint are listed again and deduplicated by the restored indexes
+	restored := testSnapshotBook(ctx)
+	restored.Restore(ctx, snapshot)
+	for _, b := range []*Book{book, restored} {
+		b.createOrder(ctx, action(b1, 0))
+		b.cancelOrder(ctx, action(a2, 0))
+		b.createOrder(ctx, action(a3, time.Second))
+	}
+	assert.Equal(testBookState(book), testBookState(restored))
+	assert.Equal("5", restored.asks.Get(a1.Id).RemainingAmount.Persist())
+
+	snapshot = restored.checkpoint(checkpoint.Add(time.Second))
+	assert.Equal([]string{a3.Id}, snapshot.CreateIndex)
+	assert.Len(snapshot.CancelIndex, 0)
+}
+
+func TestBookSnapshotIceberg(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+
+	ask := testLimitOrder(PageSideAsk, 10100, 100, TimeInForceGTC)
+	ask.DisplayAmount = number.NewInteger(40, 1)
+	bid := testLimitOrder(PageSideBid, 9900, 50, TimeInForceGTC)
+	bid.DisplayAmount = number.NewInteger(20, 1)
+	replay := func(book *Book, orders ...*Order) {
+		for _, o := range orders {
+			order := *o
+			book.createOrder(ctx, &order)
+		}
+	}
+
+	full := testSnapshotBook(ctx)
+	replay(full, ask, bid, testLimitOrder(PageSideBid, 10100, 10, TimeInForceIOC), testLimitOrder(PageSideAsk, 9900, 5, TimeInForceIOC))
+	assert.Equal("3", full.asks.Get(ask.Id).Displayed.Persist())
+	snapshot := full.snapshot()
+	restored := testSnapshotBook(ctx)
+	restored.Restore(ctx, snapshot)
+	assert.Equal(testBookState(full), testBookState(restored))
+	assert.Equal("3", restored.asks.List(1, true)[0].Amount.Persist())
+	assert.Equal("148.5", restored.bids.List(1, true)[0].Funds.Persist())
+
+	taker := testLimitOrder(PageSideBid, 10100, 50, TimeInForceIOC)
+	replay(full, taker)
+	replay(restored, taker)
+	assert.Equal(testBookState(full), testBookState(restored))
+}
+
+func testSnapshotBook(ctx context.Context) *Book {
+	return NewBook(ctx, "market", "", func(taker, maker *Order, amount number.Integer) string {
+		return "TRADE-ID"
+	}, func(order *Order) {
+	}, func(order *Order) {
+	}, func(order, opponent *Order, amount, funds number.Integer) {
+	}, func(order *Order, amount, funds number.Integer) {
+	})
+}
+
+func testBookState(book *Book) []string {
+	state := make([]string, 0)
+	for _, orders := range [][]*Order{book.asks.Orders(), book.bids.Orders(), book.askStops.Orders(), book.bidStops.Orders()} {
+		for _, o := range orders {
+			state = append(state, fmt.Sprint(o.Id, o.Type, o.Price, o.RemainingAmount, o.RemainingFunds, o.FilledAmount, o.FilledFunds, o.Displayed))
+		}
+		state = append(state, "")
+	}
+	for _, e := range append(book.asks.List(0, true), book.bids.List(0, true)...) {
+		state = append(state, fmt.Sprint(e.Side, e.Price, e.Amount, e.Funds))
+	}
+	return append(state, book.lastPrice.Persist(), fmt.Sprint(book.createIndex.Len(), book.cancelIndex.Len()))
+}
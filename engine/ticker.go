@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/cache"
+)
+
+const (
+	TickerWindow   = 24 * time.Hour
+	TickerInterval = time.Minute
+)
+
+type TickerTrade struct {
+	TradeId   string
+	Price     number.Integer
+	Amount    number.Integer
+	Funds     number.Integer
+	CreatedAt time.Time
+}
+
+// TickerBucket aggregates the trades of one ticker interval, so the 24h ticker is
+// computed from at most one bucket per minute no matter how many trades are matched.
+type TickerBucket struct {
+	StartedAt time.Time
+	Open      number.Integer
+	High      number.Integer
+	Low       number.Integer
+	Volume    number.Integer
+	Funds     number.Integer
+}
+
+func (book *Book) recordTrade(tradeId string, price, amount number.Integer) *TickerTrade {
+	trade := &TickerTrade{
+		TradeId:   tradeId,
+		Price:     price,
+		Amount:    amount,
+		Funds:     price.Mul(amount),
+		CreatedAt: time.Now(),
+	}
+	book.lastTrade = trade
+	book.bucketTrade(trade)
+	return trade
+}
+
+// SeedTicker aggregates the trades of the last ticker window into a book started
+// without a snapshot, the trades must be sorted by their creation time.
+func (book *Book) SeedTicker(trades []*TickerTrade) {
+	for _, t := range trades {
+		book.lastTrade = t
+		book.bucketTrade(t)
+	}
+}
+
+func (book *Book) bucketTrade(trade *TickerTrade) {
+	price, amount := trade.Price, trade.Amount
+	startedAt := trade.CreatedAt.Truncate(TickerInterval)
+	if n := len(book.buckets); n > 0 && book.buckets[n-1].StartedAt.Equal(startedAt) {
+		bucket := book.buckets[n-1]
+		if price.Cmp(bucket.High) > 0 {
+			bucket.High = price
+		}
+		if price.Cmp(bucket.Low) < 0 {
+			bucket.Low = price
+		}
+		bucket.Volume = bucket.Volume.Add(amount)
+		bucket.Funds = bucket.Funds.Add(trade.Funds)
+		return
+	}
+	book.buckets = append(book.buckets, &TickerBucket{
+		StartedAt: startedAt,
+		Open:      price,
+		High:      price,
+		Low:       price,
+		Volume:    amount,
+		Funds:     trade.Funds,
+	})
+}
+
+func (book *Book) expireBuckets(now time.Time) bool {
+	expired := 0
+	for expired < len(book.buckets) && now.Sub(book.buckets[expired].StartedAt) > TickerWindow {
+		expired++
+	}
+	book.buckets = book.buckets[expired:]
+	return expired > 0
+}
+
+func (book *Book) bestPrices() (string, string) {
+	ask, bid := "0", "0"
+	if asks := book.asks.List(1, true); len(asks) > 0 {
+		ask = asks[0].Price.Persist()
+	}
+	if bids := book.bids.List(1, true); len(bids) > 0 {
+		bid = bids[0].Price.Persist()
+	}
+	return ask, bid
+}
+
+func (book *Book) ticker() map[string]interface{} {
+	ask, bid := book.bestPrices()
+	data := map[string]interface{}{
+		"trade_id":   "",
+		"price":      "0",
+		"amount":     "0",
+		"ask":        ask,
+		"bid":        bid,
+		"open_24h":   "0",
+		"high_24h":   "0",
+		"low_24h":    "0",
+		"volume_24h": "0",
+		"funds_24h":  "0",
+	}
+	if t := book.lastTrade; t != nil {
+		data["trade_id"] = t.TradeId
+		data["price"] = t.Price.Persist()
+		data["amount"] = t.Amount.Persist()
+	}
+	if len(book.buckets) == 0 {
+		return data
+	}
+
+	high, low := book.buckets[0].High, book.buckets[0].Low
+	volume, funds := number.Zero(), number.Zero()
+	for _, b := range book.buckets {
+		if b.High.Cmp(high) > 0 {
+			high = b.High
+		}
+		if b.Low.Cmp(low) < 0 {
+			low = b.Low
+		}
+		volume = volume.Add(b.Volume.Decimal())
+		funds = funds.Add(b.Funds.Decimal())
+	}
+	data["open_24h"] = book.buckets[0].Open.Persist()
+	data["high_24h"] = high.Persist()
+	data["low_24h"] = low.Persist()
+	data["volume_24h"] = volume.Persist()
+	data["funds_24h"] = funds.Persist()
+	return data
+}
+
+func (book *Book) cacheTicker(ctx context.Context) {
+	ask, bid := book.bestPrices()
+	book.tickerBest = ask + "-" + bid
+	book.queue.AttachEvent(ctx, cache.EventTypeTicker, book.ticker())
+}
+
+func (book *Book) refreshTicker(ctx context.Context, now time.Time) {
+	expired := book.expireBuckets(now)
+	ask, bid := book.bestPrices()
+	if expired || book.tickerBest != ask+"-"+bid {
+		book.cacheTicker(ctx)
+	}
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBookTicker(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+
+	book := testSnapshotBook(ctx)
+	ticker := book.ticker()
+	assert.Equal("", ticker["trade_id"])
+	assert.Equal("0", ticker["ask"])
+	assert.Equal("0", ticker["open_24h"])
+
+	book.createOrder(ctx, testLimitOrder(PageSideAsk, 10000, 100, TimeInForceGTC))
+	book.createOrder(ctx, testLimitOrder(PageSideAsk, 10100, 100, TimeInForceGTC))
+	book.createOrder(ctx, testLimitOrder(PageSideBid, 9900, 50, TimeInForceGTC))
+	book.createOrder(ctx, testLimitOrder(PageSideBid, 10000, 40, TimeInForceGTC))
+	book.createOrder(ctx, testLimitOrder(PageSideBid, 10100, 80, TimeInForceGTC))
+	assert.True(len(book.buckets) > 0 && len(book.buckets) <= 2)
+	assert.Equal("101-99", book.tickerBest)
+
+	ticker = book.ticker()
+	assert.Equal("TRADE-ID", ticker["trade_id"])
+	assert.Equal("101", ticker["price"])
+	assert.Equal("2", ticker["amount"])
+	assert.Equal("101", ticker["ask"])
+	assert.Equal("99", ticker["bid"])
+	assert.Equal("100", ticker["open_24h"])
+	assert.Equal("101", ticker["high_24h"])
+	assert.Equal("100", ticker["low_24h"])
+	assert.Equal("12", ticker["volume_24h"])
+	assert.Equal("1202", ticker["funds_24h"])
+
+	snapshot := book.snapshot()
+	assert.Len(snapshot.Buckets, len(book.buckets))
+	restored := testSnapshotBook(ctx)
+	restored.Restore(ctx, snapshot)
+	assert.Equal(ticker, restored.ticker())
+
+	assert.False(book.expireBuckets(time.Now()))
+	book.buckets = append([]*TickerBucket{{
+		StartedAt: time.Now().Add(-TickerWindow + time.Minute),
+		Open:      number.NewInteger(9800, 2),
+		High:      number.NewInteger(10500, 2),
+		Low:       number.NewInteger(9800, 2),
+		Volume:    number.NewInteger(100, 1),
+		Funds:     number.NewInteger(1000000, 3),
+	}}, book.buckets...)
+	ticker = book.ticker()
+	assert.Equal("98", ticker["open_24h"])
+	assert.Equal("105", ticker["high_24h"])
+	assert.Equal("98", ticker["low_24h"])
+	assert.Equal("22", ticker["volume_24h"])
+	assert.Equal("2202", ticker["funds_24h"])
+
+	book.createOrder(ctx, testLimitOrder(PageSideAsk, 9900, 50, TimeInForceGTC))
+	assert.Equal("101-0", book.tickerBest)
+	buckets := len(book.buckets)
+	book.buckets[0].StartedAt = time.Now().Add(-TickerWindow - time.Minute)
+	book.refreshTicker(ctx, time.Now())
+	assert.Len(book.buckets, buckets-1)
+	ticker = book.ticker()
+	assert.Equal("99", ticker["price"])
+	assert.Equal("100", ticker["open_24h"])
+	assert.Equal("101", ticker["high_24h"])
+	assert.Equal("99", ticker["low_24h"])
+	assert.Equal("17", ticker["volume_24h"])
+	assert.Equal("1697", ticker["funds_24h"])
+
+	assert.True(book.expireBuckets(time.Now().Add(TickerWindow + 2*time.Minute)))
+	ticker = book.ticker()
+	assert.Len(book.buckets, 0)
+	assert.Equal("99", ticker["price"])
+	assert.Equal("0", ticker["volume_24h"])
+}
+
+func TestBookTickerBuckets(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+
+	book := testSnapshotBook(ctx)
+	for i := 0; i < 1000; i++ {
+		book.recordTrade("TRADE-ID", number.NewInteger(int64(10000+i%7), 2), number.NewInteger(10, 1))
+	}
+	assert.True(len(book.buckets) > 0 && len(book.buckets) <= 2)
+	ticker := book.ticker()
+	assert.Equal("100", ticker["open_24h"])
+	assert.Equal("100.06", ticker["high_24h"])
+	assert.Equal("100", ticker["low_24h"])
+	assert.Equal("1000", ticker["volume_24h"])
+
+	snapshot := book.snapshot()
+	snapshot.Buckets[len(snapshot.Buckets)-1].Volume = number.NewInteger(0, 1)
+	assert.Equal("1000", book.ticker()["volume_24h"])
+}
+
+func TestBookSeedTicker(t *testing.T) {
+	ctx := context.Background()
+	ctx = testSetupRedis(ctx)
+	assert := assert.New(t)
+
+	book := testSnapshotBook(ctx)
+	startedAt := time.Now().Add(-time.Hour).Truncate(TickerInterval)
+	trades := make([]*TickerTrade, 0)
+	for i, p := range []int64{10000, 10200, 9900, 10100} {
+		price, amount := number.NewInteger(p, 2), number.NewInteger(int64(10*(i+1)), 1)
+		trades = append(trades, &TickerTrade{
+			TradeId:   "TRADE-ID",
+			Price:     price,
+			Amount:    amount,
+			Funds:     price.Mul(amount),
+			CreatedAt: startedAt.Add(time.Duration(i) * 40 * time.Second),
+		})
+	}
+	book.SeedTicker(trades)
+	assert.Len(book.buckets, 3)
+
+	ticker := book.ticker()
+	assert.Equal("TRADE-ID", ticker["trade_id"])
+	assert.Equal("101", ticker["price"])
+	assert.Equal("4", ticker["amount"])
+	assert.Equal("100", ticker["open_24h"])
+	assert.Equal("102", ticker["high_24h"])
+	assert.Equal("99", ticker["low_24h"])
+	assert.Equal("10", ticker["volume_24h"])
+	assert.Equal("1005", ticker["funds_24h"])
+}
@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"log"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/emirpasic/gods/trees/redblacktree"
+)
+
+type Trigger struct {
+	Side   string
+	points *redblacktree.Tree
+	orders map[string]*Order
+}
+
+func NewTrigger(side string) *Trigger {
+	var comparator func(a, b interface{}) int
+	switch side {
+	case PageSideAsk:
+		comparator = func(a, b interface{}) int {
+			return b.(number.Integer).Cmp(a.(number.Integer))
+		}
+	case PageSideBid:
+		comparator = func(a, b interface{}) int {
+			return a.(number.Integer).Cmp(b.(number.Integer))
+		}
+	default:
+		return nil
+	}
+	return &Trigger{
+		Side:   side,
+		points: redblacktree.NewWith(comparator),
+		orders: make(map[string]*Order),
+	}
+}
+
+func (trigger *Trigger) Put(order *Order) {
+	if trigger.Side != order.Side || !order.stopped() {
+		log.Panicln(trigger, order)
+	}
+	if _, found := trigger.orders[order.Id]; found {
+		log.Panicln(order)
+	}
+	var list []*Order
+	if v, found := trigger.points.Get(order.TriggerPrice); found {
+		list = v.([]*Order)
+	}
+	trigger.points.Put(order.TriggerPrice, append(list, order))
+	trigger.orders[order.Id] = order
+}
+
+func (trigger *Trigger) Get(id string) *Order {
+	return trigger.orders[id]
+}
+
+func (trigger *Trigger) Remove(o *Order) *Order {
+	order, found := trigger.orders[o.Id]
+	if !found {
+		return nil
+	}
+	delete(trigger.orders, order.Id)
+	v, found := trigger.points.Get(order.TriggerPrice)
+	if !found {
+		log.Panicln(order)
+	}
+	list := make([]*Order, 0)
+	for _, e := range v.([]*Order) {
+		if e.Id != order.Id {
+			list = append(list, e)
+		}
+	}
+	if len(list) == 0 {
+		trigger.points.Remove(order.TriggerPrice)
+	} else {
+		trigger.points.Put(order.TriggerPrice, list)
+	}
+	return order
+}
+
+func (trigger *Trigger) Orders() []*Order {
+	orders := make([]*Order, 0, len(trigger.orders))
+	for it := trigger.points.Iterator(); it.Next(); {
+		orders = append(orders, it.Value().([]*Order)...)
+	}
+	return orders
+}
+
+func (trigger *Trigger) Release(price number.Integer) []*Order {
+	released, points := make([]*Order, 0), make([]interface{}, 0)
+	for it := trigger.points.Iterator(); it.Next(); {
+		list := it.Value().([]*Order)
+		if !list[0].triggered(price) {
+			break
+		}
+		for _, order := range list {
+			delete(trigger.orders, order.Id)
+			released = append(released, order)
+		}
+		points = append(points, it.Key())
+	}
+	for _, p := range points {
+		trigger.points.Remove(p)
+	}
+	return released
+}
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrigger(t *testing.T) {
+	assert := assert.New(t)
+
+	trigger := NewTrigger("ask")
+	assert.Nil(trigger)
+
+	asks := NewTrigger(PageSideAsk)
+	bids := NewTrigger(PageSideBid)
+
+	id, _ := uuid.NewV4()
+	ao1 := &Order{
+		Id:           id.String(),
+		Side:         PageSideAsk,
+		Type:         OrderTypeStopMarket,
+		Price:        number.NewInteger(0, 2),
+		TriggerPrice: number.NewInteger(9000, 2),
+	}
+	asks.Put(ao1)
+	id, _ = uuid.NewV4()
+	ao2 := &Order{
+		Id:           id.String(),
+		Side:         PageSideAsk,
+		Type:         OrderTypeStopLimit,
+		Price:        number.NewInteger(8500, 2),
+		TriggerPrice: number.NewInteger(8000, 2),
+	}
+	asks.Put(ao2)
+	id, _ = uuid.NewV4()
+	bo1 := &Order{
+		Id:           id.String(),
+		Side:         PageSideBid,
+		Type:         OrderTypeStopLimit,
+		Price:        number.NewInteger(12000, 2),
+		TriggerPrice: number.NewInteger(11000, 2),
+	}
+	bids.Put(bo1)
+
+	assert.Len(asks.Release(number.NewInteger(10000, 2)), 0)
+	assert.Len(bids.Release(number.NewInteger(10000, 2)), 0)
+
+	released := asks.Release(number.NewInteger(9000, 2))
+	assert.Len(released, 1)
+	assert.Equal(ao1.Id, released[0].Id)
+	assert.Len(asks.Release(number.NewInteger(9000, 2)), 0)
+
+	assert.Equal(ao2.Id, asks.Remove(ao2).Id)
+	assert.Nil(asks.Remove(ao2))
+	assert.Len(asks.Release(number.NewInteger(1000, 2)), 0)
+
+	released = bids.Release(number.NewInteger(11500, 2))
+	assert.Len(released, 1)
+	assert.Equal(bo1.Id, released[0].Id)
+
+	released[0].release()
+	assert.Equal(OrderTypeLimit, released[0].Type)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+
+	"github.com/MixinNetwork/ocean.one/cache"
+	"github.com/MixinNetwork/ocean.one/engine"
+	"github.com/MixinNetwork/ocean.one/persistence"
+)
+
+func (ex *Exchange) attachTradeEvents(ctx context.Context, taker, maker *engine.Order, trades []*persistence.Trade, transfers []*persistence.Transfer) {
+	market := taker.Base + "-" + taker.Quote
+	for _, t := range trades {
+		order := maker
+		if t.Liquidity == persistence.TradeLiquidityTaker {
+			order = taker
+		}
+		data := userOrderView(order)
+		data["trade_id"] = t.TradeId
+		data["liquidity"] = t.Liquidity
+		data["price"] = t.Price
+		data["amount"] = t.Amount
+		data["fee_asset_id"] = t.FeeAssetId
+		data["fee_amount"] = t.FeeAmount
+		ex.users.AttachEvent(ctx, t.UserId, market, cache.EventTypeOrderFill, data)
+	}
+	for _, t := range transfers {
+		ex.attachTransferEvent(ctx, market, t)
+	}
+}
+
+func (ex *Exchange) attachCancelEvents(ctx context.Context, order *engine.Order, transfer *persistence.Transfer) {
+	market := order.Base + "-" + order.Quote
+	data := userOrderView(order)
+	data["state"] = persistence.OrderStateDone
+	ex.users.AttachEvent(ctx, order.UserId, market, cache.EventTypeOrderCancel, data)
+	ex.attachTransferEvent(ctx, market, transfer)
+}
+
+func (ex *Exchange) attachTransferEvent(ctx context.Context, market string, transfer *persistence.Transfer) {
+	if transfer.UserId == "" {
+		return
+	}
+	ex.users.AttachEvent(ctx, transfer.UserId, market, cache.EventTypeTransfer, map[string]interface{}{
+		"transfer_id": transfer.TransferId,
+		"source":      transfer.Source,
+		"detail":      transfer.Detail,
+		"asset_id":    transfer.AssetId,
+		"amount":      transfer.Amount,
+		"created_at":  transfer.CreatedAt.UTC(),
+	})
+}
+
+func userOrderView(order *engine.Order) map[string]interface{} {
+	state := persistence.OrderStatePending
+	if order.RemainingAmount.IsZero() && order.RemainingFunds.IsZero() {
+		state = persistence.OrderStateDone
+	}
+	return map[string]interface{}{
+		"order_id":         order.Id,
+		"side":             order.Side,
+		"order_price":      order.Price.Persist(),
+		"remaining_amount": order.RemainingAmount.Persist(),
+		"filled_amount":    order.FilledAmount.Persist(),
+		"remaining_funds":  order.RemainingFunds.Persist(),
+		"filled_funds":     order.FilledFunds.Persist(),
+		"state":            state,
+	}
+}
@@ -72,6 +72,13 @@ func (impl *marketsImpl) index(w http.ResponseWriter, r *http.Request, params ma
 			"base_symbol":  m.BaseSymbol(),
 			"quote_symbol": m.QuoteSymbol(),
 			"is_liked_by":  m.IsLikedBy,
+
+			"price_precision":  m.PricePrecision,
+			"amount_precision": m.AmountPrecision,
+			"minimum_funds":    m.MinimumFunds,
+			"tick_size":        m.TickSize,
+			"lot_size":         m.LotSize,
+			"status":           m.Status,
 		})
 	}
 	views.RenderDataResponse(w, r, data)
@@ -84,12 +91,17 @@ func (impl *marketsImpl) market(w http.ResponseWriter, r *http.Request, params m
 		return
 	}
 
+	rule, err := models.FindMarket(r.Context(), base, quote)
+	if err != nil {
+		views.RenderErrorResponse(w, r, err)
+		return
+	}
 	m, err := models.GetMarket(r.Context(), base, quote)
 	if err != nil {
 		views.RenderErrorResponse(w, r, err)
 		return
 	}
-	if m == nil {
+	if m == nil || rule == nil {
 		views.RenderErrorResponse(w, r, session.NotFoundError(r.Context()))
 		return
 	}
@@ -104,6 +116,13 @@ func (impl *marketsImpl) market(w http.ResponseWriter, r *http.Request, params m
 		"quote_usd":    fmt.Sprint(m.QuoteUSD),
 		"base_symbol":  m.BaseSymbol(),
 		"quote_symbol": m.QuoteSymbol(),
+
+		"price_precision":  rule.PricePrecision,
+		"amount_precision": rule.AmountPrecision,
+		"minimum_funds":    rule.MinimumFunds,
+		"tick_size":        rule.TickSize,
+		"lot_size":         rule.LotSize,
+		"status":           rule.Status,
 	}
 	views.RenderDataResponse(w, r, data)
 }
@@ -2,15 +2,25 @@ package models
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/spanner"
+	"github.com/MixinNetwork/ocean.one/example/config"
 	"github.com/MixinNetwork/ocean.one/example/session"
 	"google.golang.org/api/iterator"
 )
 
+const (
+	MarketStatusActive = "ACTIVE"
+	MarketsCacheTTL    = time.Minute
+)
+
 type Market struct {
 	Base     string
 	Quote    string
@@ -20,9 +30,22 @@ type Market struct {
 	Change   float64
 	QuoteUSD float64
 
+	PricePrecision  int32
+	AmountPrecision int32
+	MinimumFunds    string
+	TickSize        string
+	LotSize         string
+	Status          string
+
 	IsLikedBy bool
 }
 
+var marketsCache struct {
+	sync.Mutex
+	markets   []*Market
+	updatedAt time.Time
+}
+
 var marketsColumnsFull = []string{"base", "quote", "price", "volume", "total", "change", "quote_usd"}
 
 func (m *Market) valuesFull() []interface{} {
@@ -37,18 +60,36 @@ func (m *Market) BaseSymbol() string {
 	return symbolsMap[m.Base]
 }
 
-func AllMarkets() []*Market {
-	var markets []*Market
-	for _, b := range usdtMarkets {
-		markets = append(markets, &Market{Quote: "815b0b1a-2764-3736-8faa-42d694fa620a", Base: b})
+func AllMarkets(ctx context.Context) ([]*Market, error) {
+	marketsCache.Lock()
+	defer marketsCache.Unlock()
+
+	if time.Since(marketsCache.updatedAt) > MarketsCacheTTL {
+		markets, err := fetchMarkets(ctx)
+		if err != nil {
+			return nil, err
+		}
+		marketsCache.markets, marketsCache.updatedAt = markets, time.Now()
 	}
-	for _, b := range btcMarkets {
-		markets = append(markets, &Market{Quote: "c6d0c728-2624-429b-8e0d-d9d19b6592fa", Base: b})
+	markets := make([]*Market, len(marketsCache.markets))
+	for i, m := range marketsCache.markets {
+		market := *m
+		markets[i] = &market
 	}
-	for _, b := range xinMarkets {
-		markets = append(markets, &Market{Quote: "c94ac88f-4671-3976-b60a-09064f1811e8", Base: b})
+	return markets, nil
+}
+
+func FindMarket(ctx context.Context, base, quote string) (*Market, error) {
+	markets, err := AllMarkets(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return markets
+	for _, m := range markets {
+		if m.Base == base && m.Quote == quote {
+			return m, nil
+		}
+	}
+	return nil, nil
 }
 
 func GetMarket(ctx context.Context, base, quote string) (*Market, error) {
@@ -72,9 +113,13 @@ func GetMarket(ctx context.Context, base, quote string) (*Market, error) {
 }
 
 func ListActiveMarkets(ctx context.Context, user *User) ([]*Market, error) {
-	filter := make(map[string]bool)
-	for _, m := range AllMarkets() {
-		filter[m.Base+m.Quote] = true
+	all, err := AllMarkets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	filter := make(map[string]*Market)
+	for _, m := range all {
+		filter[m.Base+m.Quote] = m
 	}
 
 	inputs, err := ListMarkets(ctx, user)
@@ -84,7 +129,9 @@ func ListActiveMarkets(ctx context.Context, user *User) ([]*Market, error) {
 
 	var markets []*Market
 	for _, m := range inputs {
-		if filter[m.Base+m.Quote] {
+		if r := filter[m.Base+m.Quote]; r != nil {
+			m.PricePrecision, m.AmountPrecision = r.PricePrecision, r.AmountPrecision
+			m.MinimumFunds, m.TickSize, m.LotSize, m.Status = r.MinimumFunds, r.TickSize, r.LotSize, r.Status
 			markets = append(markets, m)
 		}
 	}
@@ -119,7 +166,11 @@ func ListMarkets(ctx context.Context, user *User) ([]*Market, error) {
 }
 
 func CreateOrUpdateMarket(ctx context.Context, base, quote string, price, volume, total, change, quoteUSD float64) error {
-	for _, m := range AllMarkets() {
+	markets, err := AllMarkets(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range markets {
 		if m.Base == base && m.Quote == quote {
 			m.Price = price
 			m.Volume = volume
@@ -205,6 +256,51 @@ func AggregateCandlesAsStats(ctx context.Context, base, quote string) (*Market,
 	return market, nil
 }
 
+func fetchMarkets(ctx context.Context) ([]*Market, error) {
+	client := &http.Client{Timeout: config.ExternalNetworkTimeout}
+	resp, err := client.Get("https://events.ocean.one/markets")
+	if err != nil {
+		return nil, session.ServerError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data []struct {
+			Base            string `json:"base"`
+			Quote           string `json:"quote"`
+			PricePrecision  int32  `json:"price_precision"`
+			AmountPrecision int32  `json:"amount_precision"`
+			MinimumFunds    string `json:"minimum_funds"`
+			TickSize        string `json:"tick_size"`
+			LotSize         string `json:"lot_size"`
+			Status          string `json:"status"`
+		} `json:"data"`
+		Error string `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, session.ServerError(ctx, err)
+	}
+	if body.Error != "" {
+		return nil, session.ServerError(ctx, errors.New(body.Error))
+	}
+
+	markets := make([]*Market, 0)
+	for _, m := range body.Data {
+		markets = append(markets, &Market{
+			Base:            m.Base,
+			Quote:           m.Quote,
+			PricePrecision:  m.PricePrecision,
+			AmountPrecision: m.AmountPrecision,
+			MinimumFunds:    m.MinimumFunds,
+			TickSize:        m.TickSize,
+			LotSize:         m.LotSize,
+			Status:          m.Status,
+		})
+	}
+	return markets, nil
+}
+
 func marketFromRow(row *spanner.Row) (*Market, error) {
 	var m Market
 	err := row.Columns(&m.Base, &m.Quote, &m.Price, &m.Volume, &m.Total, &m.Change, &m.QuoteUSD)
@@ -225,37 +321,3 @@ var symbolsMap = map[string]string{
 	"23dfb5a5-5d7b-48b6-905f-3970e3176e27": "XRP",
 	"27921032-f73e-434e-955f-43d55672ee31": "XEM",
 }
-
-var usdtMarkets = []string{
-	"c6d0c728-2624-429b-8e0d-d9d19b6592fa", // BTC
-	"6cfe566e-4aad-470b-8c9a-2fd35b49c68d", // EOS
-	"43d61dcd-e413-450d-80b8-101d5e903357", // ETH
-	"990c4c29-57e9-48f6-9819-7d986ea44985", // SC
-	"23dfb5a5-5d7b-48b6-905f-3970e3176e27", // XRP
-	"27921032-f73e-434e-955f-43d55672ee31", // XEM
-	"fd11b6e3-0b87-41f1-a41f-f0e9b49e5bf0", // BCH
-	"76c802a2-7c88-447f-a93e-c29c9e5dd9c8", // LTC
-	"c94ac88f-4671-3976-b60a-09064f1811e8", // XIN
-}
-
-var btcMarkets = []string{
-	"6cfe566e-4aad-470b-8c9a-2fd35b49c68d", // EOS
-	"43d61dcd-e413-450d-80b8-101d5e903357", // ETH
-	"990c4c29-57e9-48f6-9819-7d986ea44985", // SC
-	"23dfb5a5-5d7b-48b6-905f-3970e3176e27", // XRP
-	"27921032-f73e-434e-955f-43d55672ee31", // XEM
-	"fd11b6e3-0b87-41f1-a41f-f0e9b49e5bf0", // BCH
-	"76c802a2-7c88-447f-a93e-c29c9e5dd9c8", // LTC
-	"c94ac88f-4671-3976-b60a-09064f1811e8", // XIN
-}
-
-var xinMarkets = []string{
-	"6cfe566e-4aad-470b-8c9a-2fd35b49c68d", // EOS
-	"43d61dcd-e413-450d-80b8-101d5e903357", // ETH
-	"990c4c29-57e9-48f6-9819-7d986ea44985", // SC
-	"23dfb5a5-5d7b-48b6-905f-3970e3176e27", // XRP
-	"27921032-f73e-434e-955f-43d55672ee31", // XEM
-	"fd11b6e3-0b87-41f1-a41f-f0e9b49e5bf0", // BCH
-	"76c802a2-7c88-447f-a93e-c29c9e5dd9c8", // LTC
-	"43b645fc-a52c-38a3-8d3b-705e7aaefa15", // CANDY
-}
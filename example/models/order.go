@@ -12,12 +12,6 @@ import (
 	"github.com/ugorji/go/codec"
 )
 
-const (
-	MixinAssetId   = "c94ac88f-4671-3976-b60a-09064f1811e8"
-	BitcoinAssetId = "c6d0c728-2624-429b-8e0d-d9d19b6592fa"
-	USDTAssetId    = "815b0b1a-2764-3736-8faa-42d694fa620a"
-)
-
 type OrderAction struct {
 	TraceId string `json:"trace_id"`
 	Quote   string `json:"quote"`
@@ -48,14 +42,15 @@ func (current *User) CreateOrder(ctx context.Context, o *OrderAction) error {
 	if o.Quote == config.OOOAssetId || o.Base == config.OOOAssetId {
 		return session.ForbiddenError(ctx)
 	}
-	if !validateQuoteBase(o.Quote, o.Base) {
+	market, err := FindMarket(ctx, o.Base, o.Quote)
+	if err != nil {
+		return err
+	}
+	if market == nil || market.Status != MarketStatusActive {
 		return session.ForbiddenError(ctx)
 	}
 
-	price := number.FromString(o.Price).RoundFloor(8)
-	if o.Quote == USDTAssetId {
-		price = price.RoundFloor(4)
-	}
+	price := number.FromString(o.Price).RoundFloor(market.PricePrecision)
 	switch o.Type {
 	case engine.OrderTypeLimit:
 		o.Type = "L"
@@ -70,20 +65,26 @@ func (current *User) CreateOrder(ctx context.Context, o *OrderAction) error {
 	default:
 		return session.BadDataError(ctx)
 	}
+	if !isMultipleOf(price, number.FromString(market.TickSize)) {
+		return session.BadDataError(ctx)
+	}
 
-	amount := number.FromString(o.Amount).RoundFloor(4)
+	amount := number.FromString(o.Amount).RoundFloor(market.AmountPrecision)
 	sent, get := o.Quote, o.Base
 	switch o.Side {
 	case engine.PageSideBid:
 		o.Side = "B"
 		funds := number.FromString(o.Funds).RoundFloor(8)
-		if price.IsPositive() && funds.Div(price).RoundFloor(4).Exhausted() {
+		if price.IsPositive() && funds.Div(price).RoundFloor(market.AmountPrecision).Exhausted() {
 			return session.BadDataError(ctx)
 		}
 		amount = funds
 	case engine.PageSideAsk:
 		o.Side = "A"
 		sent, get = o.Base, o.Quote
+		if !isMultipleOf(amount, number.FromString(market.LotSize)) {
+			return session.BadDataError(ctx)
+		}
 	default:
 		return session.BadDataError(ctx)
 	}
@@ -100,7 +101,7 @@ func (current *User) CreateOrder(ctx context.Context, o *OrderAction) error {
 	memo := make([]byte, 140)
 	handle := new(codec.MsgpackHandle)
 	encoder := codec.NewEncoderBytes(&memo, handle)
-	err := encoder.Encode(action)
+	err = encoder.Encode(action)
 	if err != nil {
 		return session.ServerError(ctx, err)
 	}
@@ -125,21 +126,10 @@ func (current *User) CancelOrder(ctx context.Context, id string) error {
 	return current.Key.sendTransfer(ctx, config.RandomBrokerId(), config.OOOAssetId, number.FromString("0.00000001"), uuid.NewV4().String(), base64.StdEncoding.EncodeToString(memo))
 }
 
-func validateQuoteBase(quote, base string) bool {
-	if quote == base {
-		return false
-	}
-	if quote != BitcoinAssetId && quote != USDTAssetId && quote != MixinAssetId {
-		return false
-	}
-	if quote == BitcoinAssetId && base == USDTAssetId {
-		return false
-	}
-	if quote == MixinAssetId && base == USDTAssetId {
-		return false
-	}
-	if quote == MixinAssetId && base == BitcoinAssetId {
-		return false
+func isMultipleOf(value, step number.Decimal) bool {
+	if !step.IsPositive() {
+		return true
 	}
-	return true
+	quotient := value.Div(step)
+	return quotient.RoundFloor(0).Cmp(quotient) == 0
 }
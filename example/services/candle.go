@@ -37,15 +37,24 @@ func (service *CandleService) Run(ctx context.Context) error {
 	if err := standardServiceHealth(ctx); err != nil {
 		return err
 	}
-	for _, m := range models.AllMarkets() {
-		go service.handleMarketCandles(ctx, m.Base, m.Quote)
-		go service.handleMarketStats(ctx, m.Base, m.Quote)
-	}
+	handled := make(map[string]bool)
 	for {
 		err := standardServiceHealth(ctx)
 		if err != nil {
 			session.ServerError(ctx, err)
 		}
+		markets, err := models.AllMarkets(ctx)
+		if err != nil {
+			session.ServerError(ctx, err)
+		}
+		for _, m := range markets {
+			if handled[m.Base+m.Quote] {
+				continue
+			}
+			handled[m.Base+m.Quote] = true
+			go service.handleMarketCandles(ctx, m.Base, m.Quote)
+			go service.handleMarketStats(ctx, m.Base, m.Quote)
+		}
 		time.Sleep(1 * time.Second)
 	}
 }
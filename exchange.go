@@ -8,6 +8,7 @@ import (
 
 	"github.com/MixinNetwork/bot-api-go-client"
 	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/cache"
 	"github.com/MixinNetwork/ocean.one/config"
 	"github.com/MixinNetwork/ocean.one/engine"
 	"github.com/MixinNetwork/ocean.one/persistence"
@@ -17,51 +18,38 @@ import (
 
 const (
 	PollInterval                    = 100 * time.Millisecond
+	SnapshotInterval                = 10 * time.Minute
+	MarketsInterval                 = 10 * time.Second
+	FeeTiersInterval                = 10 * time.Second
+	FeeVolumeInterval               = 10 * time.Minute
+	FeePayoutInterval               = time.Hour
 	CheckpointMixinNetworkSnapshots = "exchange-checkpoint-mixin-network-snapshots"
 )
 
 type Exchange struct {
+	network   MixinNetwork
 	books     map[string]*engine.Book
 	codec     codec.Handle
 	snapshots map[string]bool
 	brokers   map[string]*persistence.Broker
+	markets   *marketRegistry
+	fees      *feeSchedule
+	users     *cache.UserQueue
+	states    map[string]string
 	mutexes   *tmap
 }
 
-func QuotePrecision(assetId string) uint8 {
-	switch assetId {
-	case MixinAssetId:
-		return 8
-	case BitcoinAssetId:
-		return 8
-	case USDTAssetId:
-		return 4
-	default:
-		log.Panicln("QuotePrecision", assetId)
-	}
-	return 0
-}
-
-func QuoteMinimum(assetId string) number.Decimal {
-	switch assetId {
-	case MixinAssetId:
-		return number.FromString("0.0001")
-	case BitcoinAssetId:
-		return number.FromString("0.0001")
-	case USDTAssetId:
-		return number.FromString("1")
-	default:
-		log.Panicln("QuoteMinimum", assetId)
-	}
-	return number.Zero()
-}
-
-func NewExchange() *Exchange {
+func NewExchange(network MixinNetwork) *Exchange {
 	return &Exchange{
+		network:   network,
 		codec:     new(codec.MsgpackHandle),
 		books:     make(map[string]*engine.Book),
 		snapshots: make(map[string]bool),
 		brokers:   make(map[string]*persistence.Broker),
+		markets:   newMarketRegistry(),
+		fees:      newFeeSchedule(),
+		users:     cache.NewUserQueue(),
+		states:    make(map[string]string),
 		mutexes:   newTmap(),
 	}
 }
@@ -75,13 +63,34 @@ func (ex *Exchange) Run(ctx context.Context) {
 		ex.brokers[b.BrokerId] = b
 		go ex.PollTransfers(ctx, b.BrokerId)
 	}
+	for {
+		err := ex.loadMarkets(ctx)
+		if err == nil {
+			break
+		}
+		log.Println("loadMarkets", err)
+		time.Sleep(PollInterval)
+	}
+	for {
+		err := ex.loadFeeTiers(ctx)
+		if err == nil {
+			break
+		}
+		log.Println("loadFeeTiers", err)
+		time.Sleep(PollInterval)
+	}
+	go ex.users.Loop(ctx)
+	go ex.PollMarkets(ctx)
+	go ex.PollFeeTiers(ctx)
+	go ex.PollFeePayouts(ctx)
 	go ex.PollMixinMessages(ctx)
 	go ex.PollMixinNetwork(ctx)
 	ex.PollOrderActions(ctx)
 }
 
 func (ex *Exchange) PollOrderActions(ctx context.Context) {
-	checkpoint, limit := time.Time{}, 500
+	checkpoint, limit := ex.restoreBooks(ctx), 500
+	snapshotAt := time.Now()
 	for {
 		actions, err := persistence.ListPendingActions(ctx, checkpoint, limit)
 		if err != nil {
@@ -89,10 +98,15 @@ func (ex *Exchange) PollOrderActions(ctx context.Context) {
 			time.Sleep(PollInterval)
 			continue
 		}
+		ex.syncBookStates(ctx)
 		for _, a := range actions {
 			ex.ensureProcessOrderAction(ctx, a)
 			checkpoint = a.CreatedAt
 		}
+		if time.Since(snapshotAt) > SnapshotInterval {
+			ex.snapshotBooks(ctx, checkpoint)
+			snapshotAt = time.Now()
+		}
 		if len(actions) < limit {
 			time.Sleep(PollInterval)
 		}
@@ -130,6 +144,7 @@ type TransferAction struct {
 	O uuid.UUID // cancelled order
 	A uuid.UUID // matched ask order
 	B uuid.UUID // matched bid order
+	R string    // refund reason
 }
 
 func (ex *Exchange) ensureProcessTransfer(ctx context.Context, transfer *persistence.Transfer) {
@@ -151,7 +166,9 @@ func (ex *Exchange) processTransfer(ctx context.Context, transfer *persistence.T
 	case persistence.TransferSourceOrderCancelled:
 		data = &TransferAction{S: "CANCEL", O: uuid.FromStringOrNil(transfer.Detail)}
 	case persistence.TransferSourceOrderInvalid:
-		data = &TransferAction{S: "REFUND", O: uuid.FromStringOrNil(transfer.Detail)}
+		data = &TransferAction{S: "REFUND", O: uuid.FromStringOrNil(transfer.Detail), R: transfer.Reason}
+	case persistence.TransferSourceFeePayout:
+		data = &TransferAction{S: "FEE", O: uuid.FromStringOrNil(transfer.Detail)}
 	case persistence.TransferSourceTradeConfirmed:
 		trade, err := persistence.ReadTransferTrade(ctx, transfer.Detail, transfer.AssetId)
 		if err != nil {
@@ -178,9 +195,9 @@ func (ex *Exchange) processTransfer(ctx context.Context, transfer *persistence.T
 }
 
 func (ex *Exchange) buildBook(ctx context.Context, market string) *engine.Book {
-	return engine.NewBook(ctx, market, func(taker, maker *engine.Order, amount number.Integer) string {
+	return engine.NewBook(ctx, market, config.EngineSelfTradePrevention, func(taker, maker *engine.Order, amount number.Integer) string {
 		for {
-			tradeId, err := persistence.Transact(ctx, taker, maker, amount)
+			tradeId, err := ex.transact(ctx, taker, maker, amount)
 			if err == nil {
 				return tradeId
 			}
@@ -189,46 +206,279 @@ func (ex *Exchange) buildBook(ctx context.Context, market string) *engine.Book {
 		}
 	}, func(order *engine.Order) {
 		for {
-			err := persistence.CancelOrder(ctx, order)
+			transfer, err := persistence.CancelOrder(ctx, order)
 			if err == nil {
+				ex.attachCancelEvents(ctx, order, transfer)
 				break
 			}
 			log.Println("Engine Cancel CALLBACK", err)
 			time.Sleep(PollInterval)
 		}
+	}, func(order *engine.Order) {
+		for {
+			err := persistence.TriggerOrder(ctx, order)
+			if err == nil {
+				break
+			}
+			log.Println("Engine Trigger CALLBACK", err)
+			time.Sleep(PollInterval)
+		}
+	}, func(order, opponent *engine.Order, amount, funds number.Integer) {
+		for {
+			err := persistence.DecrementOrder(ctx, order, opponent, amount, funds)
+			if err == nil {
+				break
+			}
+			log.Println("Engine Decrement CALLBACK", err)
+			time.Sleep(PollInterval)
+		}
+	}, func(order *engine.Order, amount, funds number.Integer) {
+		for {
+			err := persistence.AmendOrder(ctx, order, amount, funds)
+			if err == nil {
+				break
+			}
+			log.Println("Engine Amend CALLBACK", err)
+			time.Sleep(PollInterval)
+		}
 	})
 }
 
 func (ex *Exchange) ensureProcessOrderAction(ctx context.Context, action *persistence.Action) {
-	order := action.Order
-	market := order.BaseAssetId + "-" + order.QuoteAssetId
-	book := ex.books[market]
+	market := ex.ensureMarket(ctx, action.Order.BaseAssetId, action.Order.QuoteAssetId)
+	order := ex.buildOrder(action.Order, market)
+	order.ActionAt = action.CreatedAt
+	book := ex.books[market.Id()]
 	if book == nil {
-		book = ex.buildBook(ctx, market)
-		go book.Run(ctx)
-		ex.books[market] = book
+		book = ex.startBook(ctx, market, nil)
+	}
+	if action.Action == engine.OrderActionAmend {
+		pricePrecision, amountPrecision := uint8(market.PricePrecision), uint8(market.AmountPrecision)
+		if amended := number.FromString(action.Price).Integer(pricePrecision); !amended.IsZero() {
+			order.Price = amended
+		}
+		if order.Side == engine.PageSideAsk {
+			order.RemainingAmount = number.FromString(action.Amount).Integer(amountPrecision)
+		} else {
+			order.RemainingFunds = number.FromString(action.Amount).Integer(pricePrecision + amountPrecision)
+		}
 	}
-	pricePrecision := QuotePrecision(order.QuoteAssetId)
-	fundsPrecision := pricePrecision + AmountPrecision
+	book.AttachOrderEvent(ctx, order, action.Action)
+}
+
+func (ex *Exchange) buildOrder(order *persistence.Order, market *persistence.Market) *engine.Order {
+	pricePrecision, amountPrecision := uint8(market.PricePrecision), uint8(market.AmountPrecision)
+	fundsPrecision := pricePrecision + amountPrecision
 	price := number.FromString(order.Price).Integer(pricePrecision)
-	remainingAmount := number.FromString(order.RemainingAmount).Integer(AmountPrecision)
-	filledAmount := number.FromString(order.FilledAmount).Integer(AmountPrecision)
+	remainingAmount := number.FromString(order.RemainingAmount).Integer(amountPrecision)
+	filledAmount := number.FromString(order.FilledAmount).Integer(amountPrecision)
 	remainingFunds := number.FromString(order.RemainingFunds).Integer(fundsPrecision)
 	filledFunds := number.FromString(order.FilledFunds).Integer(fundsPrecision)
-	book.AttachOrderEvent(ctx, &engine.Order{
+	triggerPrice := number.FromString(order.TriggerPrice).Integer(pricePrecision)
+	displayAmount := number.FromString(order.DisplayAmount).Integer(amountPrecision)
+	orderType := order.OrderType
+	if order.State != persistence.OrderStateUntriggered {
+		switch orderType {
+		case engine.OrderTypeStopLimit:
+			orderType = engine.OrderTypeLimit
+		case engine.OrderTypeStopMarket:
+			orderType = engine.OrderTypeMarket
+		}
+	}
+	return &engine.Order{
 		Id:              order.OrderId,
 		Side:            order.Side,
-		Type:            order.OrderType,
+		Type:            orderType,
 		Price:           price,
 		RemainingAmount: remainingAmount,
 		FilledAmount:    filledAmount,
 		RemainingFunds:  remainingFunds,
 		FilledFunds:     filledFunds,
+		TriggerPrice:    triggerPrice,
+		TimeInForce:     order.TimeInForce,
+		ExpiredAt:       order.ExpiredAt,
+		DisplayAmount:   displayAmount,
 		Quote:           order.QuoteAssetId,
 		Base:            order.BaseAssetId,
 		UserId:          order.UserId,
 		BrokerId:        order.BrokerId,
-	}, action.Action)
+	}
+}
+
+func (ex *Exchange) restoreBooks(ctx context.Context) time.Time {
+	var checkpoint time.Time
+	for {
+		snapshots, err := persistence.ReadBookSnapshots(ctx)
+		if err != nil {
+			log.Println("ReadBookSnapshots", err)
+			time.Sleep(PollInterval)
+			continue
+		}
+		for _, s := range snapshots {
+			state, err := s.State()
+			if err != nil {
+				log.Panicln(s.Market, err)
+			}
+			market := ex.ensureMarket(ctx, s.Market[:36], s.Market[37:])
+			snapshot := ex.buildSnapshot(state, market)
+			snapshot.Checkpoint = s.Checkpoint
+			ex.reconcileSnapshot(ctx, snapshot, market)
+			ex.startBook(ctx, market, snapshot)
+			if checkpoint.IsZero() || s.Checkpoint.Before(checkpoint) {
+				checkpoint = s.Checkpoint
+			}
+		}
+		return checkpoint
+	}
+}
+
+// reconcileSnapshot drops the orders done after the snapshot checkpoint and resets the
+// quantities of the others from the orders table, the actions of the orders filled or
+// cancelled after the checkpoint are deleted and never replayed to the restored book.
+// An iceberg order changed after the checkpoint gets a fresh slice, as in a full rebuild.
+func (ex *Exchange) reconcileSnapshot(ctx context.Context, snapshot *engine.Snapshot, market *persistence.Market) {
+	snapshot.Asks = ex.reconcileOrders(ctx, snapshot.Asks, market)
+	snapshot.Bids = ex.reconcileOrders(ctx, snapshot.Bids, market)
+	snapshot.AskStops = ex.reconcileOrders(ctx, snapshot.AskStops, market)
+	snapshot.BidStops = ex.reconcileOrders(ctx, snapshot.BidStops, market)
+}
+
+func (ex *Exchange) reconcileOrders(ctx context.Context, orders []*engine.Order, market *persistence.Market) []*engine.Order {
+	reconciled := make([]*engine.Order, 0)
+	for _, o := range orders {
+		order := ex.ensureReadOrder(ctx, o.Id)
+		if order == nil || order.State == persistence.OrderStateDone {
+			continue
+		}
+		current := ex.buildOrder(order, market)
+		if o.RemainingAmount.Cmp(current.RemainingAmount) != 0 || o.RemainingFunds.Cmp(current.RemainingFunds) != 0 {
+			o.Displayed = number.Integer{}
+		}
+		o.Price = current.Price
+		o.RemainingAmount, o.FilledAmount = current.RemainingAmount, current.FilledAmount
+		o.RemainingFunds, o.FilledFunds = current.RemainingFunds, current.FilledFunds
+		reconciled = append(reconciled, o)
+	}
+	return reconciled
+}
+
+func (ex *Exchange) ensureReadOrder(ctx context.Context, orderId string) *persistence.Order {
+	for {
+		order, err := persistence.ReadOrder(ctx, orderId)
+		if err == nil {
+			return order
+		}
+		log.Println("ReadOrder", orderId, err)
+		time.Sleep(PollInterval)
+	}
+}
+
+func (ex *Exchange) buildSnapshot(state *persistence.BookState, market *persistence.Market) *engine.Snapshot {
+	snapshot := &engine.Snapshot{
+		CreateIndex: state.CreateIndex,
+		CancelIndex: state.CancelIndex,
+		LastPrice:   number.FromString(state.LastPrice).Integer(uint8(market.PricePrecision)),
+	}
+	for _, o := range state.Asks {
+		snapshot.Asks = append(snapshot.Asks, ex.buildBookOrder(o, market))
+	}
+	for _, o := range state.Bids {
+		snapshot.Bids = append(snapshot.Bids, ex.buildBookOrder(o, market))
+	}
+	for _, o := range state.AskStops {
+		snapshot.AskStops = append(snapshot.AskStops, ex.buildOrder(o, market))
+	}
+	for _, o := range state.BidStops {
+		snapshot.BidStops = append(snapshot.BidStops, ex.buildOrder(o, market))
+	}
+	for _, a := range state.Halted {
+		snapshot.Halted = append(snapshot.Halted, &engine.OrderEvent{Order: ex.buildOrder(a.Order, market), Action: a.Action})
+	}
+	for _, b := range state.Buckets {
+		snapshot.Buckets = append(snapshot.Buckets, ex.buildBucket(b, market))
+	}
+	if state.LastTrade != nil {
+		snapshot.LastTrade = ex.buildTrade(state.LastTrade, market)
+	}
+	return snapshot
+}
+
+func (ex *Exchange) buildBookOrder(order *persistence.BookOrder, market *persistence.Market) *engine.Order {
+	o := ex.buildOrder(order.Order, market)
+	if order.Displayed != "" {
+		precision := uint8(market.AmountPrecision)
+		if o.Side == engine.PageSideBid {
+			precision = uint8(market.PricePrecision + market.AmountPrecision)
+		}
+		o.Displayed = number.FromString(order.Displayed).Integer(precision)
+	}
+	return o
+}
+
+func (ex *Exchange) buildTrade(trade *persistence.BookTrade, market *persistence.Market) *engine.TickerTrade {
+	pricePrecision, amountPrecision := uint8(market.PricePrecision), uint8(market.AmountPrecision)
+	return &engine.TickerTrade{
+		TradeId:   trade.TradeId,
+		Price:     number.FromString(trade.Price).Integer(pricePrecision),
+		Amount:    number.FromString(trade.Amount).Integer(amountPrecision),
+		Funds:     number.FromString(trade.Funds).Integer(pricePrecision + amountPrecision),
+		CreatedAt: trade.CreatedAt,
+	}
+}
+
+func (ex *Exchange) buildBucket(bucket *persistence.BookBucket, market *persistence.Market) *engine.TickerBucket {
+	pricePrecision, amountPrecision := uint8(market.PricePrecision), uint8(market.AmountPrecision)
+	return &engine.TickerBucket{
+		StartedAt: bucket.StartedAt,
+		Open:      number.FromString(bucket.Open).Integer(pricePrecision),
+		High:      number.FromString(bucket.High).Integer(pricePrecision),
+		Low:       number.FromString(bucket.Low).Integer(pricePrecision),
+		Volume:    number.FromString(bucket.Volume).Integer(amountPrecision),
+		Funds:     number.FromString(bucket.Funds).Integer(pricePrecision + amountPrecision),
+	}
+}
+
+func (ex *Exchange) tickerTrades(ctx context.Context, market *persistence.Market, now time.Time) []*engine.TickerTrade {
+	pricePrecision, amountPrecision := uint8(market.PricePrecision), uint8(market.AmountPrecision)
+	trades, offset := make([]*engine.TickerTrade, 0), now.Add(-engine.TickerWindow)
+	for {
+		page, err := persistence.MarketTrades(ctx, market.Id(), offset, "ASC", 100)
+		if err != nil {
+			log.Println("MarketTrades", err)
+			time.Sleep(PollInterval)
+			continue
+		}
+		for _, t := range page {
+			price := number.FromString(t.Price).Integer(pricePrecision)
+			amount := number.FromString(t.Amount).Integer(amountPrecision)
+			trades = append(trades, &engine.TickerTrade{
+				TradeId:   t.TradeId,
+				Price:     price,
+				Amount:    amount,
+				Funds:     price.Mul(amount),
+				CreatedAt: t.CreatedAt,
+			})
+			offset = t.CreatedAt
+		}
+		if len(page) < 100 {
+			return trades
+		}
+	}
+}
+
+func (ex *Exchange) snapshotBooks(ctx context.Context, checkpoint time.Time) {
+	snapshots := make(map[string]*engine.Snapshot)
+	for market, book := range ex.books {
+		snapshots[market] = book.Snapshot(ctx, checkpoint)
+	}
+	for {
+		err := persistence.WriteBookSnapshots(ctx, checkpoint, snapshots)
+		if err == nil {
+			break
+		}
+		log.Println("WriteBookSnapshots", err)
+		time.Sleep(PollInterval)
+	}
 }
 
 func (ex *Exchange) PollMixinNetwork(ctx context.Context) {
@@ -243,7 +493,7 @@ func (ex *Exchange) PollMixinNetwork(ctx context.Context) {
 		if checkpoint.IsZero() {
 			checkpoint = time.Now().UTC()
 		}
-		snapshots, err := ex.requestMixinNetwork(ctx, checkpoint, limit)
+		snapshots, err := ex.network.ReadSnapshots(ctx, checkpoint, limit)
 		if err != nil {
 			log.Println("PollMixinNetwork ERROR", err)
 			time.Sleep(PollInterval)
@@ -269,8 +519,7 @@ func (ex *Exchange) PollMixinNetwork(ctx context.Context) {
 
 func (ex *Exchange) PollMixinMessages(ctx context.Context) {
 	for {
-		blazeClient := bot.NewBlazeClient(config.ClientId, config.SessionId, config.SessionKey)
-		err := blazeClient.Loop(ctx, ex)
+		err := ex.network.LoopMessages(ctx, ex)
 		if err != nil {
 			log.Println("PollMixinMessages", err)
 			time.Sleep(1 * time.Second)
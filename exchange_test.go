@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/bot-api-go-client"
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/cache"
+	"github.com/MixinNetwork/ocean.one/config"
+	"github.com/MixinNetwork/ocean.one/engine"
+	"github.com/MixinNetwork/ocean.one/persistence"
+	"github.com/go-redis/redis"
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/ugorji/go/codec"
+)
+
+func TestExchangeSnapshotTradeTransfer(t *testing.T) {
+	assert := assert.New(t)
+	ctx, ex, network := testSetupExchange()
+
+	seller, buyer, stranger := testUUID(), testUUID(), testUUID()
+	network.Deposit(seller, MixinAssetId, number.FromString("2.0001"))
+	network.Deposit(buyer, USDTAssetId, number.FromString("150"))
+	network.Deposit(buyer, MixinAssetId, number.FromString("0.0001"))
+	network.Deposit(stranger, USDTAssetId, number.FromString("10"))
+
+	ask := testSendOrderAction(ex, network, seller, MixinAssetId, "2", &OrderAction{S: "A", A: uuid.FromStringOrNil(USDTAssetId), P: "100", T: "L"})
+	testSendOrderAction(ex, network, buyer, USDTAssetId, "150", &OrderAction{S: "B", A: uuid.FromStringOrNil(MixinAssetId), P: "100", T: "L"})
+	_, err := network.Transfer(stranger, config.ClientId, USDTAssetId, number.FromString("10"), testUUID(), "invalid")
+	assert.Nil(err)
+	checkpoint := testProcessExchange(ctx, ex, network, time.Time{})
+
+	orders, err := persistence.UserOrders(ctx, buyer, MixinAssetId+"-"+USDTAssetId, persistence.OrderStateDone, time.Time{}, "ASC", 10)
+	assert.Nil(err)
+	assert.Len(orders, 1)
+	assert.Equal("1.5", orders[0].FilledAmount)
+	order, err := persistence.ReadOrder(ctx, ask.TraceId)
+	assert.Nil(err)
+	assert.Equal(persistence.OrderStatePending, order.State)
+	trade, err := persistence.LastTrade(ctx, MixinAssetId+"-"+USDTAssetId)
+	assert.Nil(err)
+	assert.Equal("100", trade.Price)
+	assert.Equal("1.5", trade.Amount)
+
+	assert.Equal("9.99", network.Balance(stranger, USDTAssetId).Persist())
+	assert.Equal([]string{"REFUND"}, testTransferSources(ex, network, stranger))
+	assert.Equal("150", network.Balance(seller, USDTAssetId).Persist())
+	assert.Equal([]string{"MATCH"}, testTransferSources(ex, network, seller))
+	assert.Equal("1.4986", network.Balance(buyer, MixinAssetId).Persist())
+	assert.Equal([]string{"MATCH"}, testTransferSources(ex, network, buyer))
+
+	testSendOrderAction(ex, network, buyer, MixinAssetId, "0.0001", &OrderAction{O: uuid.FromStringOrNil(ask.TraceId)})
+	checkpoint = testProcessExchange(ctx, ex, network, checkpoint)
+	assert.Len(testTransferSources(ex, network, buyer), 1)
+	testSendOrderAction(ex, network, seller, MixinAssetId, "0.0001", &OrderAction{O: uuid.FromStringOrNil(ask.TraceId)})
+	testProcessExchange(ctx, ex, network, checkpoint)
+
+	order, _ = persistence.ReadOrder(ctx, ask.TraceId)
+	assert.Equal(persistence.OrderStateDone, order.State)
+	assert.Equal("0.5", network.Balance(seller, MixinAssetId).Persist())
+	assert.Equal([]string{"MATCH", "CANCEL"}, testTransferSources(ex, network, seller))
+	assert.Equal("0.0017", network.Balance(config.ClientId, MixinAssetId).Persist())
+	assert.Equal("0.01", network.Balance(config.ClientId, USDTAssetId).Persist())
+	count, _ := persistence.CountPendingActions(ctx)
+	assert.Equal(int64(0), count)
+	count, _ = persistence.CountPendingTransfers(ctx)
+	assert.Equal(int64(0), count)
+}
+
+func TestExchangeMarketRegistry(t *testing.T) {
+	assert := assert.New(t)
+	ctx, ex, network := testSetupExchange()
+
+	market, err := persistence.ReadMarket(ctx, MixinAssetId+"-"+USDTAssetId)
+	assert.Nil(err)
+	assert.Equal(int64(4), market.PricePrecision)
+	assert.Equal("1", market.MinimumFunds)
+	market, err = persistence.ReadMarket(ctx, USDTAssetId+"-"+MixinAssetId)
+	assert.Nil(err)
+	assert.Nil(market)
+
+	user := testUUID()
+	network.Deposit(user, MixinAssetId, number.FromString("2"))
+	testSendOrderAction(ex, network, user, MixinAssetId, "1", &OrderAction{S: "B", A: uuid.FromStringOrNil(USDTAssetId), P: "0.01", T: "L"})
+	checkpoint := testProcessExchange(ctx, ex, network, time.Time{})
+	assert.Equal([]string{"REFUND"}, testTransferSources(ex, network, user))
+
+	err = persistence.WriteMarket(ctx, &persistence.Market{BaseAssetId: USDTAssetId, QuoteAssetId: MixinAssetId, PricePrecision: 6, AmountPrecision: 2, MinimumFunds: "0.1", TickSize: "0.000001", LotSize: "0.01", Status: persistence.MarketStatusActive})
+	assert.Nil(err)
+	assert.Nil(ex.loadMarkets(ctx))
+	order := testSendOrderAction(ex, network, user, MixinAssetId, "1", &OrderAction{S: "B", A: uuid.FromStringOrNil(USDTAssetId), P: "0.012345", T: "L"})
+	testSendOrderAction(ex, network, user, MixinAssetId, "0.1", &OrderAction{S: "B", A: uuid.FromStringOrNil(USDTAssetId), P: "0.0123456789", T: "L"})
+	testProcessExchange(ctx, ex, network, checkpoint)
+	assert.Equal([]string{"REFUND", "REFUND"}, testTransferSources(ex, network, user))
+	o, err := persistence.ReadOrder(ctx, order.TraceId)
+	assert.Nil(err)
+	assert.Equal("0.012345", o.Price)
+	assert.Equal(persistence.OrderStatePending, o.State)
+}
+
+func TestExchangeMarketStatus(t *testing.T) {
+	assert := assert.New(t)
+	ctx, ex, network := testSetupExchange()
+	market := MixinAssetId + "-" + USDTAssetId
+
+	seller, buyer := testUUID(), testUUID()
+	network.Deposit(seller, MixinAssetId, number.FromString("3"))
+	network.Deposit(buyer, USDTAssetId, number.FromString("200"))
+	ask := testSendOrderAction(ex, network, seller, MixinAssetId, "2", &OrderAction{S: "A", A: uuid.FromStringOrNil(USDTAssetId), P: "100", T: "L"})
+	checkpoint := testProcessExchange(ctx, ex, network, time.Time{})
+
+	_, err := persistence.UpdateMarketStatus(ctx, market, persistence.MarketStatusHalted, "operator", "maintenance")
+	assert.Nil(err)
+	testSendOrderAction(ex, network, buyer, USDTAssetId, "100", &OrderAction{S: "B", A: uuid.FromStringOrNil(MixinAssetId), P: "100", T: "L"})
+	testSendOrderAction(ex, network, seller, MixinAssetId, "0.0001", &OrderAction{O: uuid.FromStringOrNil(ask.TraceId)})
+	checkpoint = testProcessExchange(ctx, ex, network, checkpoint)
+	assert.Equal([]string{"REFUND"}, testTransferSources(ex, network, buyer))
+	assert.Len(testTransferSources(ex, network, seller), 0)
+	order, _ := persistence.ReadOrder(ctx, ask.TraceId)
+	assert.Equal(persistence.OrderStatePending, order.State)
+
+	_, err = persistence.UpdateMarketStatus(ctx, market, persistence.MarketStatusCancelOnly, "operator", "withdrawals only")
+	assert.Nil(err)
+	testProcessExchange(ctx, ex, network, checkpoint)
+	order, _ = persistence.ReadOrder(ctx, ask.TraceId)
+	assert.Equal(persistence.OrderStateDone, order.State)
+	assert.Equal([]string{"CANCEL"}, testTransferSources(ex, network, seller))
+	assert.Equal("2.9999", network.Balance(seller, MixinAssetId).Persist())
+
+	audits, err := persistence.MarketAudits(ctx, market, 10)
+	assert.Nil(err)
+	assert.Len(audits, 2)
+}
+
+func TestExchangeFeeTiers(t *testing.T) {
+	assert := assert.New(t)
+	ctx, ex, network := testSetupExchange()
+	market := MixinAssetId + "-" + USDTAssetId
+
+	assert.Nil(persistence.WriteFeeTier(ctx, &persistence.FeeTier{Market: market, MakerFeeRate: "-0.0001", TakerFeeRate: "0.002"}))
+	assert.Nil(persistence.WriteFeeTier(ctx, &persistence.FeeTier{BrokerId: config.ClientId, MinimumVolume: "1000000", MakerFeeRate: "0", TakerFeeRate: "0"}))
+	seller, buyer := testUUID(), testUUID()
+	network.Deposit(config.ClientId, USDTAssetId, number.FromString("1"))
+	network.Deposit(seller, MixinAssetId, number.FromString("1"))
+	network.Deposit(buyer, USDTAssetId, number.FromString("100"))
+	testSendOrderAction(ex, network, seller, MixinAssetId, "1", &OrderAction{S: "A", A: uuid.FromStringOrNil(USDTAssetId), P: "100", T: "L"})
+	testSendOrderAction(ex, network, buyer, USDTAssetId, "100", &OrderAction{S: "B", A: uuid.FromStringOrNil(MixinAssetId), P: "100", T: "L"})
+	testProcessExchange(ctx, ex, network, time.Time{})
+
+	assert.Equal("100.01", network.Balance(seller, USDTAssetId).Persist())
+	assert.Equal("0.998", network.Balance(buyer, MixinAssetId).Persist())
+	assert.Equal("0.99", network.Balance(config.ClientId, USDTAssetId).Persist())
+	assert.Equal("0.002", network.Balance(config.ClientId, MixinAssetId).Persist())
+	trade, err := persistence.LastTrade(ctx, market)
+	assert.Nil(err)
+	assert.Equal(persistence.TradeLiquidityMaker, trade.Liquidity)
+	assert.Equal("-0.0001", trade.FeeRate)
+	assert.Equal("-0.01", trade.FeeAmount)
+
+	treasury := testUUID()
+	payouts, err := persistence.PayoutFees(ctx, treasury, 100)
+	assert.Nil(err)
+	assert.Len(payouts, 2)
+	assert.Equal(USDTAssetId, payouts[0].AssetId)
+	assert.Equal("0", payouts[0].Amount)
+	testProcessExchange(ctx, ex, network, time.Time{})
+	assert.Equal("0.002", network.Balance(treasury, MixinAssetId).Persist())
+	assert.Equal("0", network.Balance(config.ClientId, MixinAssetId).Persist())
+	assert.Equal("0.99", network.Balance(config.ClientId, USDTAssetId).Persist())
+	assert.Equal([]string{"FEE"}, testTransferSources(ex, network, treasury))
+	entries, err := persistence.ListUnpaidFees(ctx, "", 100)
+	assert.Nil(err)
+	assert.Len(entries, 1)
+	assert.Equal(persistence.FeeLiquidityCarry, entries[0].Liquidity)
+	assert.Equal("-0.01", entries[0].Amount)
+}
+
+func TestExchangeBrokerFeeShares(t *testing.T) {
+	assert := assert.New(t)
+	ctx, ex, network := testSetupExchange()
+
+	payout := testUUID()
+	assert.Nil(persistence.WriteFeeTier(ctx, &persistence.FeeTier{MakerFeeRate: "0", TakerFeeRate: "0.002"}))
+	assert.Nil(persistence.WriteBrokerFeeShare(ctx, &persistence.BrokerFeeShare{BrokerId: config.ClientId, ShareRate: "0.25", PayoutUserId: payout}))
+	seller, buyer := testUUID(), testUUID()
+	network.Deposit(seller, MixinAssetId, number.FromString("1"))
+	network.Deposit(buyer, USDTAssetId, number.FromString("100"))
+	testSendOrderAction(ex, network, seller, MixinAssetId, "1", &OrderAction{S: "A", A: uuid.FromStringOrNil(USDTAssetId), P: "100", T: "L"})
+	testSendOrderAction(ex, network, buyer, USDTAssetId, "100", &OrderAction{S: "B", A: uuid.FromStringOrNil(MixinAssetId), P: "100", T: "L"})
+	testProcessExchange(ctx, ex, network, time.Time{})
+	assert.Equal("0.998", network.Balance(buyer, MixinAssetId).Persist())
+
+	payouts, err := persistence.PayoutFees(ctx, "", 100)
+	assert.Nil(err)
+	assert.Len(payouts, 1)
+	assert.Equal(config.ClientId, payouts[0].BeneficiaryId)
+	testProcessExchange(ctx, ex, network, time.Time{})
+	assert.Equal("0.0005", network.Balance(payout, MixinAssetId).Persist())
+	assert.Equal("0.0015", network.Balance(config.ClientId, MixinAssetId).Persist())
+	assert.Equal([]string{"FEE"}, testTransferSources(ex, network, payout))
+
+	treasury := testUUID()
+	payouts, err = persistence.PayoutFees(ctx, treasury, 100)
+	assert.Nil(err)
+	assert.Len(payouts, 1)
+	testProcessExchange(ctx, ex, network, time.Time{})
+	assert.Equal("0.0015", network.Balance(treasury, MixinAssetId).Persist())
+	assert.Equal("0", network.Balance(config.ClientId, MixinAssetId).Persist())
+}
+
+func TestExchangeRefundReasons(t *testing.T) {
+	assert := assert.New(t)
+	ctx, ex, network := testSetupExchange()
+
+	user := testUUID()
+	network.Deposit(user, MixinAssetId, number.FromString("100"))
+	network.Deposit(user, USDTAssetId, number.FromString("10000"))
+	usdt, xin := uuid.FromStringOrNil(USDTAssetId), uuid.FromStringOrNil(MixinAssetId)
+	expected := map[string]string{
+		persistence.RefundReasonPriceTick:          testSendOrderAction(ex, network, user, MixinAssetId, "1", &OrderAction{S: "A", A: usdt, P: "100.00005", T: "L"}).TraceId,
+		persistence.RefundReasonAmountLot:          testSendOrderAction(ex, network, user, MixinAssetId, "1.00005", &OrderAction{S: "A", A: usdt, P: "100", T: "L"}).TraceId,
+		persistence.RefundReasonInvalidTrigger:     testSendOrderAction(ex, network, user, USDTAssetId, "100", &OrderAction{S: "B", A: xin, P: "90", T: "SL"}).TraceId,
+		persistence.RefundReasonInvalidPair:        testSendOrderAction(ex, network, user, MixinAssetId, "1", &OrderAction{S: "A", A: xin, P: "100", T: "L"}).TraceId,
+		persistence.RefundReasonInvalidType:        testSendOrderAction(ex, network, user, MixinAssetId, "1", &OrderAction{S: "A", A: usdt, P: "100", T: "X"}).TraceId,
+		persistence.RefundReasonInvalidPrice:       testSendOrderAction(ex, network, user, MixinAssetId, "1", &OrderAction{S: "A", A: usdt, T: "L"}).TraceId,
+		persistence.RefundReasonPriceTooHigh:       testSendOrderAction(ex, network, user, MixinAssetId, "1", &OrderAction{S: "A", A: usdt, P: "100000000", T: "L"}).TraceId,
+		persistence.RefundReasonInvalidTimeInForce: testSendOrderAction(ex, network, user, MixinAssetId, "1", &OrderAction{S: "A", A: usdt, P: "100", T: "L", F: "DAY"}).TraceId,
+		persistence.RefundReasonInvalidExpiry:      testSendOrderAction(ex, network, user, MixinAssetId, "1", &OrderAction{S: "A", A: usdt, P: "100", T: "L", E: 1}).TraceId,
+		persistence.RefundReasonInvalidDisplay:     testSendOrderAction(ex, network, user, MixinAssetId, "1", &OrderAction{S: "A", A: usdt, T: "M", D: "0.5"}).TraceId,
+		persistence.RefundReasonFundsTooLow:        testSendOrderAction(ex, network, user, USDTAssetId, "0.5", &OrderAction{S: "B", A: xin, P: "100", T: "L"}).TraceId,
+	}
+	memo, err := network.Transfer(user, config.ClientId, USDTAssetId, number.FromString("1"), testUUID(), "invalid")
+	assert.Nil(err)
+	expected[persistence.RefundReasonInvalidMemo] = memo.TraceId
+	valid := testSendOrderAction(ex, network, user, MixinAssetId, "1.5", &OrderAction{S: "A", A: usdt, P: "100.0001", T: "L"})
+	testProcessExchange(ctx, ex, network, time.Time{})
+
+	reasons := make(map[string]string)
+	for _, a := range testTransferActions(ex, network, user) {
+		assert.Equal("REFUND", a.S)
+		reasons[a.R] = a.O.String()
+	}
+	assert.Equal(expected, reasons)
+	snapshots, _ := network.ReadSnapshots(ctx, time.Time{}, 500)
+	for _, s := range snapshots {
+		assert.True(len(s.Data) <= 140)
+	}
+	order, _ := persistence.ReadOrder(ctx, valid.TraceId)
+	assert.NotNil(order)
+	assert.Equal("100.0001", order.Price)
+}
+
+func TestExchangeAmendRefunds(t *testing.T) {
+	assert := assert.New(t)
+	ctx, ex, network := testSetupExchange()
+	market := MixinAssetId + "-" + USDTAssetId
+
+	user, other := testUUID(), testUUID()
+	network.Deposit(user, MixinAssetId, number.FromString("10"))
+	network.Deposit(user, USDTAssetId, number.FromString("100"))
+	network.Deposit(other, USDTAssetId, number.FromString("100"))
+	ask := testSendOrderAction(ex, network, user, MixinAssetId, "2", &OrderAction{S: "A", A: uuid.FromStringOrNil(USDTAssetId), P: "100", T: "L"})
+	checkpoint := testProcessExchange(ctx, ex, network, time.Time{})
+
+	id := uuid.FromStringOrNil(ask.TraceId)
+	amends := []*OrderAction{
+		{O: uuid.FromStringOrNil(testUUID()), R: "1"},
+		{O: id, P: "-1"},
+		{O: id, P: "100000000"},
+		{O: id, P: "100.00005"},
+		{O: id, R: "-1"},
+		{O: id, R: "100000000"},
+		{O: id, R: "1.00005"},
+	}
+	for _, a := range amends {
+		testSendOrderAction(ex, network, user, USDTAssetId, "1", a)
+	}
+	testSendOrderAction(ex, network, other, USDTAssetId, "1", &OrderAction{O: id, R: "1"})
+	checkpoint = testProcessExchange(ctx, ex, network, checkpoint)
+
+	reasons := make([]string, 0)
+	for _, a := range testTransferActions(ex, network, user) {
+		assert.Equal("REFUND", a.S)
+		reasons = append(reasons, a.R)
+	}
+	assert.Equal([]string{
+		persistence.RefundReasonInvalidOrder,
+		persistence.RefundReasonInvalidPrice,
+		persistence.RefundReasonPriceTooHigh,
+		persistence.RefundReasonPriceTick,
+		persistence.RefundReasonInvalidAmount,
+		persistence.RefundReasonAmountTooHigh,
+		persistence.RefundReasonAmountLot,
+	}, reasons)
+	assert.Equal([]string{"REFUND"}, testTransferSources(ex, network, other))
+	assert.Equal("99.993", network.Balance(user, USDTAssetId).Persist())
+
+	_, err := persistence.UpdateMarketStatus(ctx, market, persistence.MarketStatusHalted, "operator", "maintenance")
+	assert.Nil(err)
+	testSendOrderAction(ex, network, user, USDTAssetId, "1", &OrderAction{O: id, R: "1"})
+	testProcessExchange(ctx, ex, network, checkpoint)
+	actions := testTransferActions(ex, network, user)
+	assert.Len(actions, 8)
+	assert.Equal(persistence.RefundReasonMarketInactive, actions[7].R)
+	order, _ := persistence.ReadOrder(ctx, ask.TraceId)
+	assert.Equal(persistence.OrderStatePending, order.State)
+}
+
+func TestExchangeRestoreBooks(t *testing.T) {
+	assert := assert.New(t)
+	ctx, ex, network := testSetupExchange()
+	usdt, xin := uuid.FromStringOrNil(USDTAssetId), uuid.FromStringOrNil(MixinAssetId)
+
+	seller, buyer := testUUID(), testUUID()
+	network.Deposit(seller, MixinAssetId, number.FromString("100"))
+	network.Deposit(buyer, USDTAssetId, number.FromString("10000"))
+	a1 := testSendOrderAction(ex, network, seller, MixinAssetId, "2", &OrderAction{S: "A", A: usdt, P: "100", T: "L"})
+	a2 := testSendOrderAction(ex, network, seller, MixinAssetId, "3", &OrderAction{S: "A", A: usdt, P: "101", T: "L"})
+	a3 := testSendOrderAction(ex, network, seller, MixinAssetId, "1", &OrderAction{S: "A", A: usdt, P: "102", T: "L"})
+	testSendOrderAction(ex, network, seller, MixinAssetId, "4", &OrderAction{S: "A", A: usdt, P: "103", T: "L", D: "1"})
+	testSendOrderAction(ex, network, buyer, USDTAssetId, "90", &OrderAction{S: "B", A: xin, P: "90", T: "L"})
+	b2 := testSendOrderAction(ex, network, buyer, USDTAssetId, "380", &OrderAction{S: "B", A: xin, P: "95", T: "L", D: "1"})
+	testSendOrderAction(ex, network, seller, MixinAssetId, "0.5", &OrderAction{S: "A", A: usdt, P: "95", T: "L"})
+	checkpoint := testProcessExchange(ctx, ex, network, time.Time{})
+	ex.snapshotBooks(ctx, time.Now())
+
+	testSendOrderAction(ex, network, buyer, USDTAssetId, "250.5", &OrderAction{S: "B", A: xin, P: "101", T: "L"})
+	testSendOrderAction(ex, network, seller, MixinAssetId, "0.0001", &OrderAction{O: uuid.FromStringOrNil(a3.TraceId)})
+	testSendOrderAction(ex, network, seller, MixinAssetId, "1", &OrderAction{S: "A", A: usdt, P: "95", T: "L"})
+	testSendOrderAction(ex, network, seller, MixinAssetId, "1", &OrderAction{S: "A", A: usdt, P: "110", T: "L"})
+	testProcessExchange(ctx, ex, network, checkpoint)
+	order, _ := persistence.ReadOrder(ctx, a1.TraceId)
+	assert.Equal(persistence.OrderStateDone, order.State)
+	order, _ = persistence.ReadOrder(ctx, a2.TraceId)
+	assert.Equal("2.5", order.RemainingAmount)
+	order, _ = persistence.ReadOrder(ctx, b2.TraceId)
+	assert.Equal("237.5", order.RemainingFunds)
+
+	restarted := testNewExchange(ctx, network)
+	restored := testReplayActions(ctx, restarted, restarted.restoreBooks(ctx))
+	rebuilt := testReplayActions(ctx, testNewExchange(ctx, network), time.Time{})
+	assert.Len(rebuilt, 5)
+	assert.Equal(rebuilt, restored)
+	order, _ = persistence.ReadOrder(ctx, a2.TraceId)
+	assert.Equal("2.5", order.RemainingAmount)
+}
+
+func TestExchangeSeedTicker(t *testing.T) {
+	assert := assert.New(t)
+	ctx, ex, network := testSetupExchange()
+	usdt, xin := uuid.FromStringOrNil(USDTAssetId), uuid.FromStringOrNil(MixinAssetId)
+
+	seller, buyer := testUUID(), testUUID()
+	network.Deposit(seller, MixinAssetId, number.FromString("100"))
+	network.Deposit(buyer, USDTAssetId, number.FromString("10000"))
+	testSendOrderAction(ex, network, seller, MixinAssetId, "2", &OrderAction{S: "A", A: usdt, P: "100", T: "L"})
+	testSendOrderAction(ex, network, seller, MixinAssetId, "1", &OrderAction{S: "A", A: usdt, P: "101", T: "L"})
+	testSendOrderAction(ex, network, seller, MixinAssetId, "1", &OrderAction{S: "A", A: usdt, P: "110", T: "L"})
+	testSendOrderAction(ex, network, buyer, USDTAssetId, "301", &OrderAction{S: "B", A: xin, P: "101", T: "L"})
+	testProcessExchange(ctx, ex, network, time.Time{})
+
+	restarted := testNewExchange(ctx, network)
+	assert.Len(testReplayActions(ctx, restarted, time.Time{}), 1)
+	snapshot := restarted.books[MixinAssetId+"-"+USDTAssetId].Snapshot(ctx, time.Time{})
+	assert.NotNil(snapshot.LastTrade)
+	assert.Equal("101", snapshot.LastTrade.Price.Persist())
+	assert.Equal("1", snapshot.LastTrade.Amount.Persist())
+	volume, funds := number.Zero(), number.Zero()
+	for _, b := range snapshot.Buckets {
+		volume = volume.Add(b.Volume.Decimal())
+		funds = funds.Add(b.Funds.Decimal())
+	}
+	assert.Equal("3", volume.Persist())
+	assert.Equal("301", funds.Persist())
+	assert.Equal("100", snapshot.Buckets[0].Open.Persist())
+}
+
+func TestMixinNetworkSimulator(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	network := NewMixinNetworkSimulator()
+	broker := &persistence.Broker{BrokerId: config.ClientId}
+	user := testUUID()
+
+	in := &bot.TransferInput{AssetId: USDTAssetId, RecipientId: user, Amount: number.FromString("10"), TraceId: testUUID(), Memo: "memo"}
+	assert.NotNil(network.CreateTransfer(ctx, broker, in))
+	network.Deposit(config.ClientId, USDTAssetId, number.FromString("15"))
+	assert.Nil(network.CreateTransfer(ctx, broker, in))
+	assert.Nil(network.CreateTransfer(ctx, broker, in))
+	assert.Equal("5", network.Balance(config.ClientId, USDTAssetId).Persist())
+	assert.Equal("10", network.Balance(user, USDTAssetId).Persist())
+
+	conflict := *in
+	conflict.Amount = number.FromString("1")
+	assert.NotNil(network.CreateTransfer(ctx, broker, &conflict))
+	_, err := network.Transfer(user, config.ClientId, USDTAssetId, number.FromString("-1"), testUUID(), "")
+	assert.NotNil(err)
+	assert.Equal("10", network.Balance(user, USDTAssetId).Persist())
+
+	snapshots, err := network.ReadSnapshots(ctx, time.Time{}, 10)
+	assert.Nil(err)
+	assert.Len(snapshots, 2)
+	assert.Equal(config.ClientId, snapshots[0].UserId)
+	assert.Equal("-10", snapshots[0].Amount)
+	assert.Equal(user, snapshots[1].UserId)
+	assert.Equal("10", snapshots[1].Amount)
+	assert.Equal(in.TraceId, snapshots[1].TraceId)
+	assert.Equal("memo", snapshots[1].Data)
+	snapshots, _ = network.ReadSnapshots(ctx, snapshots[1].CreatedAt, 10)
+	assert.Len(snapshots, 1)
+
+	network.SendMessage(bot.MessageView{UserId: user})
+	ctx, cancel := context.WithCancel(ctx)
+	listener := &testMessageListener{cancel: cancel}
+	assert.Equal(context.Canceled, network.LoopMessages(ctx, listener))
+	assert.Equal([]string{user}, listener.users)
+}
+
+type testMessageListener struct {
+	cancel func()
+	users  []string
+}
+
+func (l *testMessageListener) OnMessage(ctx context.Context, msg bot.MessageView, userId string) error {
+	l.users = append(l.users, userId)
+	l.cancel()
+	return nil
+}
+
+func testSendOrderAction(ex *Exchange, network *MixinNetworkSimulator, userId, assetId, amount string, action *OrderAction) *Snapshot {
+	out := make([]byte, 140)
+	encoder := codec.NewEncoderBytes(&out, ex.codec)
+	err := encoder.Encode(action)
+	if err != nil {
+		panic(err)
+	}
+	s, err := network.Transfer(userId, config.ClientId, assetId, number.FromString(amount), testUUID(), base64.StdEncoding.EncodeToString(out))
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func testProcessExchange(ctx context.Context, ex *Exchange, network MixinNetwork, checkpoint time.Time) time.Time {
+	ex.loadMarkets(ctx)
+	ex.loadFeeTiers(ctx)
+	snapshots, _ := network.ReadSnapshots(ctx, checkpoint, 500)
+	for _, s := range snapshots {
+		if ex.snapshots[s.SnapshotId] {
+			continue
+		}
+		ex.ensureProcessSnapshot(ctx, s)
+		ex.snapshots[s.SnapshotId] = true
+		checkpoint = s.CreatedAt
+	}
+
+	ex.syncBookStates(ctx)
+	actions, _ := persistence.ListPendingActions(ctx, time.Time{}, 500)
+	for _, a := range actions {
+		ex.ensureProcessOrderAction(ctx, a)
+	}
+	for _, book := range ex.books {
+		book.Snapshot(ctx, time.Time{})
+	}
+
+	transfers, _ := persistence.ListPendingTransfers(ctx, config.ClientId, 500)
+	for _, t := range transfers {
+		ex.ensureProcessTransfer(ctx, t)
+	}
+	persistence.ExpireTransfers(ctx, transfers)
+	return checkpoint
+}
+
+func testTransferSources(ex *Exchange, network *MixinNetworkSimulator, userId string) []string {
+	sources := make([]string, 0)
+	for _, action := range testTransferActions(ex, network, userId) {
+		sources = append(sources, action.S)
+	}
+	return sources
+}
+
+func testTransferActions(ex *Exchange, network *MixinNetworkSimulator, userId string) []*TransferAction {
+	snapshots, _ := network.ReadSnapshots(context.Background(), time.Time{}, 500)
+	actions := make([]*TransferAction, 0)
+	for _, s := range snapshots {
+		if s.UserId != userId || s.OpponentId != config.ClientId || number.FromString(s.Amount).Exhausted() {
+			continue
+		}
+		payload, _ := base64.StdEncoding.DecodeString(s.Data)
+		var action TransferAction
+		codec.NewDecoderBytes(payload, ex.codec).Decode(&action)
+		actions = append(actions, &action)
+	}
+	return actions
+}
+
+func testSetupExchange() (context.Context, *Exchange, *MixinNetworkSimulator) {
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:         config.RedisEngineCacheAddress,
+		DB:           config.RedisEngineCacheDatabase,
+		ReadTimeout:  3 * time.Second,
+		WriteTimeout: 3 * time.Second,
+		PoolTimeout:  4 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		PoolSize:     1024,
+	})
+	ctx := cache.SetupRedis(context.Background(), redisClient)
+	ctx = persistence.SetupStore(ctx, persistence.NewMemoryStore())
+
+	network := NewMixinNetworkSimulator()
+	return ctx, testNewExchange(ctx, network), network
+}
+
+func testNewExchange(ctx context.Context, network *MixinNetworkSimulator) *Exchange {
+	ex := NewExchange(network)
+	brokers, err := persistence.AllBrokers(ctx, false)
+	if err != nil {
+		panic(err)
+	}
+	for _, b := range brokers {
+		ex.brokers[b.BrokerId] = b
+	}
+	err = ex.loadMarkets(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return ex
+}
+
+func testReplayActions(ctx context.Context, ex *Exchange, checkpoint time.Time) []string {
+	actions, _ := persistence.ListPendingActions(ctx, checkpoint, 500)
+	for _, a := range actions {
+		ex.ensureProcessOrderAction(ctx, a)
+	}
+	state := make([]string, 0)
+	for _, book := range ex.books {
+		snapshot := book.Snapshot(ctx, time.Time{})
+		for _, orders := range [][]*engine.Order{snapshot.Asks, snapshot.Bids, snapshot.AskStops, snapshot.BidStops} {
+			for _, o := range orders {
+				state = append(state, fmt.Sprint(o.Id, o.Side, o.Price, o.RemainingAmount, o.RemainingFunds, o.FilledAmount, o.FilledFunds, o.Displayed))
+			}
+		}
+	}
+	return state
+}
+
+func testUUID() string {
+	id, _ := uuid.NewV4()
+	return id.String()
+}
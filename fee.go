@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/config"
+	"github.com/MixinNetwork/ocean.one/engine"
+	"github.com/MixinNetwork/ocean.one/persistence"
+)
+
+type feeVolume struct {
+	volume    number.Decimal
+	updatedAt time.Time
+}
+
+type feeSchedule struct {
+	sync.Mutex
+	tiers   []*persistence.FeeTier
+	shares  map[string]string
+	volumes map[string]*feeVolume
+}
+
+func newFeeSchedule() *feeSchedule {
+	return &feeSchedule{
+		shares:  make(map[string]string),
+		volumes: make(map[string]*feeVolume),
+	}
+}
+
+func (s *feeSchedule) fetch() []*persistence.FeeTier {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.tiers
+}
+
+func (s *feeSchedule) share(brokerId string) string {
+	s.Lock()
+	defer s.Unlock()
+
+	if rate, found := s.shares[brokerId]; found {
+		return rate
+	}
+	return "0"
+}
+
+func (s *feeSchedule) store(tiers []*persistence.FeeTier, shares []*persistence.BrokerFeeShare) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.tiers = tiers
+	s.shares = make(map[string]string)
+	for _, b := range shares {
+		s.shares[b.BrokerId] = b.ShareRate
+	}
+	for k, v := range s.volumes {
+		if time.Since(v.updatedAt) > FeeVolumeInterval {
+			delete(s.volumes, k)
+		}
+	}
+}
+
+func (s *feeSchedule) volume(ctx context.Context, userId, market string) (number.Decimal, error) {
+	s.Lock()
+	v := s.volumes[userId+market]
+	s.Unlock()
+	if v != nil && time.Since(v.updatedAt) < FeeVolumeInterval {
+		return v.volume, nil
+	}
+
+	now := time.Now()
+	volume, err := persistence.UserTradeVolume(ctx, userId, market, now.Add(-persistence.FeeVolumeWindow))
+	if err != nil {
+		return volume, err
+	}
+	s.Lock()
+	s.volumes[userId+market] = &feeVolume{volume: volume, updatedAt: now}
+	s.Unlock()
+	return volume, nil
+}
+
+func (ex *Exchange) PollFeeTiers(ctx context.Context) {
+	for {
+		time.Sleep(FeeTiersInterval)
+		err := ex.loadFeeTiers(ctx)
+		if err != nil {
+			log.Println("PollFeeTiers", err)
+		}
+	}
+}
+
+func (ex *Exchange) loadFeeTiers(ctx context.Context) error {
+	tiers, err := persistence.AllFeeTiers(ctx)
+	if err != nil {
+		return err
+	}
+	shares, err := persistence.AllBrokerFeeShares(ctx)
+	if err != nil {
+		return err
+	}
+	ex.fees.store(tiers, shares)
+	return nil
+}
+
+func (ex *Exchange) transact(ctx context.Context, taker, maker *engine.Order, amount number.Integer) (string, error) {
+	takerFeeRate, err := ex.feeRate(ctx, taker, persistence.TradeLiquidityTaker)
+	if err != nil {
+		return "", err
+	}
+	makerFeeRate, err := ex.feeRate(ctx, maker, persistence.TradeLiquidityMaker)
+	if err != nil {
+		return "", err
+	}
+	trades, transfers, err := persistence.Transact(ctx, taker, maker, amount, takerFeeRate, makerFeeRate, ex.fees.share(taker.BrokerId))
+	if err != nil {
+		return "", err
+	}
+	ex.attachTradeEvents(ctx, taker, maker, trades, transfers)
+	return trades[0].TradeId, nil
+}
+
+func (ex *Exchange) feeRate(ctx context.Context, order *engine.Order, liquidity string) (string, error) {
+	tiers := ex.fees.fetch()
+	if len(tiers) > 0 {
+		market := order.Base + "-" + order.Quote
+		volume, err := ex.fees.volume(ctx, order.UserId, market)
+		if err != nil {
+			return "", err
+		}
+		if tier := persistence.MatchFeeTier(tiers, market, order.BrokerId, volume); tier != nil {
+			return tier.Rate(liquidity), nil
+		}
+	}
+	if liquidity == persistence.TradeLiquidityMaker {
+		return persistence.MakerFeeRate, nil
+	}
+	return persistence.TakerFeeRate, nil
+}
+
+func (ex *Exchange) PollFeePayouts(ctx context.Context) {
+	for {
+		time.Sleep(FeePayoutInterval)
+		payouts, err := persistence.PayoutFees(ctx, config.EngineTreasuryAccountId, 5000)
+		if err != nil {
+			log.Println("PollFeePayouts", err)
+		}
+		for _, p := range payouts {
+			log.Println("PollFeePayouts", p.PayoutId, p.BeneficiaryId, p.BrokerId, p.AssetId, p.Amount, p.Entries)
+		}
+	}
+}
@@ -88,7 +88,7 @@ func (handler *RequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 }
 
 func StartHTTP(ctx context.Context) error {
-	hub := cache.NewHub()
+	hub := cache.NewHub(persistence.Authenticate)
 	go hub.Run(ctx)
 
 	rh := &RequestHandler{
@@ -116,7 +116,7 @@ func StartHTTP(ctx context.Context) error {
 func handleContext(handler http.Handler, src context.Context) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := cache.SetupRedis(r.Context(), cache.Redis(src))
-		ctx = persistence.SetupSpanner(ctx, persistence.Spanner(src))
+		ctx = persistence.SetupStore(ctx, persistence.CurrentStore(src))
 		handler.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
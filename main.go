@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
 	"time"
 
 	"cloud.google.com/go/spanner"
@@ -11,18 +15,27 @@ import (
 	"github.com/MixinNetwork/ocean.one/config"
 	"github.com/MixinNetwork/ocean.one/persistence"
 	"github.com/go-redis/redis"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 func main() {
 	service := flag.String("service", "http", "run a service")
+	market := flag.String("market", "", "the market to change status or fee tier")
+	status := flag.String("status", "", "the new market status, ACTIVE, HALTED or CANCEL_ONLY")
+	operator := flag.String("operator", os.Getenv("USER"), "the operator changing the market status")
+	reason := flag.String("reason", "", "the reason of the market status change")
+	broker := flag.String("broker", "", "the broker of the fee tier, share or report, empty for all brokers or the treasury")
+	volume := flag.String("volume", "0", "the trailing 30 days volume in quote asset to reach the fee tier")
+	maker := flag.String("maker", persistence.MakerFeeRate, "the maker fee rate of the fee tier, negative for rebates")
+	taker := flag.String("taker", persistence.TakerFeeRate, "the taker fee rate of the fee tier")
+	remove := flag.Bool("remove", false, "remove the fee tier")
+	share := flag.String("share", "0", "the share of the taker fees credited to the broker")
+	payout := flag.String("payout", "", "the user to receive the broker fee share payouts")
 	flag.Parse()
 
 	ctx := context.Background()
-	spannerClient, err := spanner.NewClientWithConfig(ctx, config.GoogleCloudSpanner, spanner.ClientConfig{NumChannels: 4,
-		SessionPoolConfig: spanner.SessionPoolConfig{
-			HealthCheckInterval: 5 * time.Second,
-		},
-	})
+	store, err := openStore(ctx)
 	if err != nil {
 		log.Panicln(err)
 	}
@@ -41,13 +54,79 @@ func main() {
 		log.Panicln(err)
 	}
 
-	ctx = persistence.SetupSpanner(ctx, spannerClient)
+	ctx = persistence.SetupStore(ctx, store)
 	ctx = cache.SetupRedis(ctx, redisClient)
 
 	switch *service {
 	case "engine":
-		NewExchange().Run(ctx)
+		NewExchange(openMixinNetwork()).Run(ctx)
 	case "http":
 		StartHTTP(ctx)
+	case "market":
+		m, err := persistence.UpdateMarketStatus(ctx, *market, *status, *operator, *reason)
+		if err != nil {
+			log.Panicln(err)
+		}
+		log.Println(m.Id(), m.Status)
+	case "fee":
+		if *remove {
+			err = persistence.RemoveFeeTier(ctx, *market, *broker, *volume)
+		} else {
+			err = persistence.WriteFeeTier(ctx, &persistence.FeeTier{Market: *market, BrokerId: *broker, MinimumVolume: *volume, MakerFeeRate: *maker, TakerFeeRate: *taker})
+		}
+		if err != nil {
+			log.Panicln(err)
+		}
+		log.Println(*market, *broker, *volume, *remove)
+	case "share":
+		err = persistence.WriteBrokerFeeShare(ctx, &persistence.BrokerFeeShare{BrokerId: *broker, ShareRate: *share, PayoutUserId: *payout})
+		if err != nil {
+			log.Panicln(err)
+		}
+		log.Println(*broker, *share, *payout)
+	case "report":
+		reports, err := persistence.BeneficiaryFeeReports(ctx, *broker, 100000)
+		if err != nil {
+			log.Panicln(err)
+		}
+		for _, r := range reports {
+			log.Println(r.BeneficiaryId, r.AssetId, r.Paid, r.Unpaid)
+		}
+	}
+}
+
+func openStore(ctx context.Context) (persistence.Store, error) {
+	switch config.PersistenceBackend {
+	case "spanner":
+		client, err := spanner.NewClientWithConfig(ctx, config.GoogleCloudSpanner, spanner.ClientConfig{NumChannels: 4,
+			SessionPoolConfig: spanner.SessionPoolConfig{
+				HealthCheckInterval: 5 * time.Second,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return persistence.NewSpannerStore(client), nil
+	case persistence.SQLDialectPostgres, persistence.SQLDialectSQLite:
+		db, err := sql.Open(config.PersistenceBackend, config.PersistenceDataSource)
+		if err != nil {
+			return nil, err
+		}
+		if config.PersistenceBackend == persistence.SQLDialectSQLite {
+			db.SetMaxOpenConns(1)
+		}
+		return persistence.NewSQLStore(db, config.PersistenceBackend), db.PingContext(ctx)
+	}
+	return nil, fmt.Errorf("unknown persistence backend %s", config.PersistenceBackend)
+}
+
+func openMixinNetwork() MixinNetwork {
+	if !config.MixinNetworkSimulator {
+		return NewMixinNetworkClient()
 	}
+	simulator := NewMixinNetworkSimulator()
+	go func() {
+		log.Println(http.ListenAndServe(config.MixinNetworkSimulatorAddress, simulator))
+	}()
+	return simulator
 }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/MixinNetwork/ocean.one/engine"
+	"github.com/MixinNetwork/ocean.one/persistence"
+)
+
+type marketRegistry struct {
+	sync.RWMutex
+	markets map[string]*persistence.Market
+}
+
+func newMarketRegistry() *marketRegistry {
+	return &marketRegistry{
+		markets: make(map[string]*persistence.Market),
+	}
+}
+
+func (r *marketRegistry) fetch(base, quote string) *persistence.Market {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.markets[base+"-"+quote]
+}
+
+func (r *marketRegistry) store(markets ...*persistence.Market) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, m := range markets {
+		r.markets[m.Id()] = m
+	}
+}
+
+func (ex *Exchange) PollMarkets(ctx context.Context) {
+	for {
+		time.Sleep(MarketsInterval)
+		err := ex.loadMarkets(ctx)
+		if err != nil {
+			log.Println("PollMarkets", err)
+		}
+	}
+}
+
+func (ex *Exchange) loadMarkets(ctx context.Context) error {
+	markets, err := persistence.AllMarkets(ctx)
+	if err != nil {
+		return err
+	}
+	if len(markets) == 0 {
+		markets = defaultMarkets()
+		for _, m := range markets {
+			err = persistence.WriteMarket(ctx, m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	ex.markets.store(markets...)
+	return nil
+}
+
+func (ex *Exchange) ensureMarket(ctx context.Context, base, quote string) *persistence.Market {
+	for {
+		if m := ex.markets.fetch(base, quote); m != nil {
+			return m
+		}
+		m := legacyMarket(base, quote)
+		if m == nil {
+			log.Panicln("ensureMarket", base, quote)
+		}
+		err := persistence.WriteMarket(ctx, m)
+		if err == nil {
+			ex.markets.store(m)
+			return m
+		}
+		log.Println("ensureMarket", err)
+		time.Sleep(PollInterval)
+	}
+}
+
+func (ex *Exchange) startBook(ctx context.Context, market *persistence.Market, snapshot *engine.Snapshot) *engine.Book {
+	book := ex.buildBook(ctx, market.Id())
+	if snapshot != nil {
+		book.Restore(ctx, snapshot)
+	} else {
+		book.SeedTicker(ex.tickerTrades(ctx, market, time.Now()))
+	}
+	book.SetState(ctx, market.Status)
+	ex.states[market.Id()] = market.Status
+	go book.Run(ctx)
+	ex.books[market.Id()] = book
+	return book
+}
+
+func (ex *Exchange) syncBookStates(ctx context.Context) {
+	for id, book := range ex.books {
+		market := ex.markets.fetch(id[:36], id[37:])
+		if market == nil || ex.states[id] == market.Status {
+			continue
+		}
+		book.SetState(ctx, market.Status)
+		ex.states[id] = market.Status
+	}
+}
+
+func legacyMarket(base, quote string) *persistence.Market {
+	market := &persistence.Market{
+		BaseAssetId:     base,
+		QuoteAssetId:    quote,
+		AmountPrecision: AmountPrecision,
+		LotSize:         "0.0001",
+		Status:          persistence.MarketStatusActive,
+	}
+	switch quote {
+	case MixinAssetId, BitcoinAssetId:
+		market.PricePrecision = 8
+		market.MinimumFunds = "0.0001"
+		market.TickSize = "0.00000001"
+	case USDTAssetId:
+		market.PricePrecision = 4
+		market.MinimumFunds = "1"
+		market.TickSize = "0.0001"
+	default:
+		return nil
+	}
+	return market
+}
+
+func defaultMarkets() []*persistence.Market {
+	var markets []*persistence.Market
+	for quote, bases := range map[string][]string{
+		USDTAssetId: {
+			"c6d0c728-2624-429b-8e0d-d9d19b6592fa", // BTC
+			"6cfe566e-4aad-470b-8c9a-2fd35b49c68d", // EOS
+			"43d61dcd-e413-450d-80b8-101d5e903357", // ETH
+			"990c4c29-57e9-48f6-9819-7d986ea44985", // SC
+			"23dfb5a5-5d7b-48b6-905f-3970e3176e27", // XRP
+			"27921032-f73e-434e-955f-43d55672ee31", // XEM
+			"fd11b6e3-0b87-41f1-a41f-f0e9b49e5bf0", // BCH
+			"76c802a2-7c88-447f-a93e-c29c9e5dd9c8", // LTC
+			"c94ac88f-4671-3976-b60a-09064f1811e8", // XIN
+		},
+		BitcoinAssetId: {
+			"6cfe566e-4aad-470b-8c9a-2fd35b49c68d", // EOS
+			"43d61dcd-e413-450d-80b8-101d5e903357", // ETH
+			"990c4c29-57e9-48f6-9819-7d986ea44985", // SC
+			"23dfb5a5-5d7b-48b6-905f-3970e3176e27", // XRP
+			"27921032-f73e-434e-955f-43d55672ee31", // XEM
+			"fd11b6e3-0b87-41f1-a41f-f0e9b49e5bf0", // BCH
+			"76c802a2-7c88-447f-a93e-c29c9e5dd9c8", // LTC
+			"c94ac88f-4671-3976-b60a-09064f1811e8", // XIN
+		},
+		MixinAssetId: {
+			"6cfe566e-4aad-470b-8c9a-2fd35b49c68d", // EOS
+			"43d61dcd-e413-450d-80b8-101d5e903357", // ETH
+			"990c4c29-57e9-48f6-9819-7d986ea44985", // SC
+			"23dfb5a5-5d7b-48b6-905f-3970e3176e27", // XRP
+			"27921032-f73e-434e-955f-43d55672ee31", // XEM
+			"fd11b6e3-0b87-41f1-a41f-f0e9b49e5bf0", // BCH
+			"76c802a2-7c88-447f-a93e-c29c9e5dd9c8", // LTC
+			"43b645fc-a52c-38a3-8d3b-705e7aaefa15", // CANDY
+		},
+	} {
+		for _, base := range bases {
+			markets = append(markets, legacyMarket(base, quote))
+		}
+	}
+	return markets
+}
@@ -4,9 +4,6 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/hex"
-	"encoding/json"
-	"errors"
-	"fmt"
 	"log"
 	"math/big"
 	"sync"
@@ -14,7 +11,6 @@ import (
 
 	"github.com/MixinNetwork/bot-api-go-client"
 	"github.com/MixinNetwork/go-number"
-	"github.com/MixinNetwork/ocean.one/config"
 	"github.com/MixinNetwork/ocean.one/engine"
 	"github.com/MixinNetwork/ocean.one/persistence"
 	"github.com/satori/go.uuid"
@@ -58,6 +54,11 @@ type OrderAction struct {
 	A uuid.UUID // asset
 	P string    // price
 	T string    // type
+	K string    // trigger price
+	F string    // time in force
+	E int64     // expire timestamp
+	D string    // display amount
+	R string    // amended remaining amount
 	O uuid.UUID // order
 }
 
@@ -85,63 +86,137 @@ func (ex *Exchange) processSnapshot(ctx context.Context, s *Snapshot) error {
 
 	action, err := ex.decryptOrderAction(ctx, s.Data)
 	if err != nil {
-		return ex.refundSnapshot(ctx, s)
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidMemo)
 	}
 	if len(action.U) > 16 {
 		return persistence.UpdateUserPublicKey(ctx, s.OpponentId, hex.EncodeToString(action.U))
 	}
 	if action.O.String() != uuid.Nil.String() {
-		return persistence.CancelOrderAction(ctx, action.O.String(), s.CreatedAt, s.OpponentId)
+		if action.P == "" && action.R == "" {
+			return persistence.CancelOrderAction(ctx, action.O.String(), s.CreatedAt, s.OpponentId)
+		}
+		return ex.amendOrder(ctx, s, action)
 	}
 
 	if action.A.String() == s.Asset.AssetId {
-		return ex.refundSnapshot(ctx, s)
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidPair)
 	}
-	if action.T != engine.OrderTypeLimit && action.T != engine.OrderTypeMarket {
-		return ex.refundSnapshot(ctx, s)
+	switch action.T {
+	case engine.OrderTypeLimit, engine.OrderTypeMarket, engine.OrderTypeStopLimit, engine.OrderTypeStopMarket:
+	default:
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidType)
 	}
 
-	quote, base := ex.getQuoteBasePair(s, action)
-	if quote == "" {
-		return ex.refundSnapshot(ctx, s)
+	market := ex.getMarket(s, action)
+	if market == nil {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidPair)
 	}
+	if market.Status != persistence.MarketStatusActive {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonMarketInactive)
+	}
+	pricePrecision, amountPrecision := uint8(market.PricePrecision), uint8(market.AmountPrecision)
+	tickSize, lotSize := number.FromString(market.TickSize), number.FromString(market.LotSize)
 
 	priceDecimal := number.FromString(action.P)
-	maxPrice := number.NewDecimal(MaxPrice, int32(QuotePrecision(quote)))
+	maxPrice := number.NewDecimal(MaxPrice, int32(pricePrecision))
 	if priceDecimal.Cmp(maxPrice) > 0 {
-		return ex.refundSnapshot(ctx, s)
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonPriceTooHigh)
 	}
-	price := priceDecimal.Integer(QuotePrecision(quote))
-	if action.T == engine.OrderTypeLimit {
+	price := priceDecimal.Integer(pricePrecision)
+	if action.T == engine.OrderTypeLimit || action.T == engine.OrderTypeStopLimit {
 		if price.IsZero() {
-			return ex.refundSnapshot(ctx, s)
+			return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidPrice)
 		}
 	} else if !price.IsZero() {
-		return ex.refundSnapshot(ctx, s)
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidPrice)
+	}
+	if !isMultipleOf(priceDecimal, tickSize) {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonPriceTick)
+	}
+
+	triggerDecimal := number.FromString(action.K)
+	if triggerDecimal.Cmp(maxPrice) > 0 {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidTrigger)
+	}
+	triggerPrice := triggerDecimal.Integer(pricePrecision)
+	if action.T == engine.OrderTypeStopLimit || action.T == engine.OrderTypeStopMarket {
+		if triggerPrice.IsZero() {
+			return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidTrigger)
+		}
+	} else if !triggerPrice.IsZero() {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidTrigger)
+	}
+	if !isMultipleOf(triggerDecimal, tickSize) {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonPriceTick)
 	}
 
-	fundsPrecision := AmountPrecision + QuotePrecision(quote)
+	timeInForce := engine.TimeInForceIOC
+	if action.T == engine.OrderTypeLimit || action.T == engine.OrderTypeStopLimit {
+		timeInForce = action.F
+		if timeInForce == "" {
+			timeInForce = engine.TimeInForceGTC
+		}
+	} else if action.F != "" {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidTimeInForce)
+	}
+	switch timeInForce {
+	case engine.TimeInForceGTC, engine.TimeInForceIOC, engine.TimeInForceFOK, engine.TimeInForcePostOnly:
+	default:
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidTimeInForce)
+	}
+
+	var expiredAt time.Time
+	if action.E > 0 {
+		if action.T != engine.OrderTypeLimit && action.T != engine.OrderTypeStopLimit {
+			return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidExpiry)
+		}
+		if timeInForce != engine.TimeInForceGTC && timeInForce != engine.TimeInForcePostOnly {
+			return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidExpiry)
+		}
+		expiredAt = time.Unix(action.E, 0).UTC()
+		if !expiredAt.After(s.CreatedAt) {
+			return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidExpiry)
+		}
+	}
+
+	displayDecimal := number.FromString(action.D)
+	if displayDecimal.Cmp(number.Zero()) < 0 || displayDecimal.Cmp(number.NewDecimal(MaxAmount, int32(amountPrecision))) > 0 {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidDisplay)
+	}
+	displayAmount := displayDecimal.Integer(amountPrecision)
+	if !displayAmount.IsZero() && action.T != engine.OrderTypeLimit && action.T != engine.OrderTypeStopLimit {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidDisplay)
+	}
+	if !isMultipleOf(displayDecimal, lotSize) {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonAmountLot)
+	}
+
+	fundsPrecision := amountPrecision + pricePrecision
 	funds := number.NewInteger(0, fundsPrecision)
-	amount := number.NewInteger(0, AmountPrecision)
+	amount := number.NewInteger(0, amountPrecision)
+	minimumFunds := number.FromString(market.MinimumFunds)
 
 	assetDecimal := number.FromString(s.Amount)
 	if action.S == engine.PageSideBid {
 		maxFunds := number.NewDecimal(MaxFunds, int32(fundsPrecision))
 		if assetDecimal.Cmp(maxFunds) > 0 {
-			return ex.refundSnapshot(ctx, s)
+			return ex.refundSnapshot(ctx, s, persistence.RefundReasonFundsTooHigh)
 		}
 		funds = assetDecimal.Integer(fundsPrecision)
-		if funds.Decimal().Cmp(QuoteMinimum(quote)) < 0 {
-			return ex.refundSnapshot(ctx, s)
+		if funds.Decimal().Cmp(minimumFunds) < 0 {
+			return ex.refundSnapshot(ctx, s, persistence.RefundReasonFundsTooLow)
 		}
 	} else {
-		maxAmount := number.NewDecimal(MaxAmount, AmountPrecision)
+		maxAmount := number.NewDecimal(MaxAmount, int32(amountPrecision))
 		if assetDecimal.Cmp(maxAmount) > 0 {
-			return ex.refundSnapshot(ctx, s)
+			return ex.refundSnapshot(ctx, s, persistence.RefundReasonAmountTooHigh)
+		}
+		if !isMultipleOf(assetDecimal, lotSize) {
+			return ex.refundSnapshot(ctx, s, persistence.RefundReasonAmountLot)
 		}
-		amount = assetDecimal.Integer(AmountPrecision)
-		if action.T == engine.OrderTypeLimit && price.Mul(amount).Decimal().Cmp(QuoteMinimum(quote)) < 0 {
-			return ex.refundSnapshot(ctx, s)
+		amount = assetDecimal.Integer(amountPrecision)
+		if !price.IsZero() && price.Mul(amount).Decimal().Cmp(minimumFunds) < 0 {
+			return ex.refundSnapshot(ctx, s, persistence.RefundReasonFundsTooLow)
 		}
 	}
 
@@ -149,49 +224,86 @@ func (ex *Exchange) processSnapshot(ctx context.Context, s *Snapshot) error {
 		Id:              s.TraceId,
 		Type:            action.T,
 		Side:            action.S,
-		Quote:           quote,
-		Base:            base,
+		Quote:           market.QuoteAssetId,
+		Base:            market.BaseAssetId,
 		Price:           price,
 		RemainingAmount: amount,
 		FilledAmount:    amount.Zero(),
 		RemainingFunds:  funds,
 		FilledFunds:     funds.Zero(),
+		TriggerPrice:    triggerPrice,
+		TimeInForce:     timeInForce,
+		ExpiredAt:       expiredAt,
+		DisplayAmount:   displayAmount,
 	}, s.OpponentId, s.UserId, s.CreatedAt)
 }
 
-func (ex *Exchange) getQuoteBasePair(s *Snapshot, a *OrderAction) (string, string) {
+func (ex *Exchange) amendOrder(ctx context.Context, s *Snapshot, action *OrderAction) error {
+	order, err := persistence.ReadOrder(ctx, action.O.String())
+	if err != nil {
+		return err
+	}
+	if order == nil || order.UserId != s.OpponentId {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidOrder)
+	}
+
+	market := ex.ensureMarket(ctx, order.BaseAssetId, order.QuoteAssetId)
+	if market.Status != persistence.MarketStatusActive {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonMarketInactive)
+	}
+	pricePrecision, amountPrecision := int32(market.PricePrecision), int32(market.AmountPrecision)
+	price := number.FromString(action.P)
+	if price.Cmp(number.Zero()) < 0 {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidPrice)
+	}
+	if price.Cmp(number.NewDecimal(MaxPrice, pricePrecision)) > 0 {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonPriceTooHigh)
+	}
+	if !isMultipleOf(price, number.FromString(market.TickSize)) {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonPriceTick)
+	}
+	amount := number.FromString(action.R)
+	if amount.Cmp(number.Zero()) < 0 {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonInvalidAmount)
+	}
+	if order.Side == engine.PageSideBid && amount.Cmp(number.NewDecimal(MaxFunds, amountPrecision+pricePrecision)) > 0 {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonFundsTooHigh)
+	}
+	if order.Side == engine.PageSideAsk && amount.Cmp(number.NewDecimal(MaxAmount, amountPrecision)) > 0 {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonAmountTooHigh)
+	}
+	if order.Side == engine.PageSideAsk && !isMultipleOf(amount, number.FromString(market.LotSize)) {
+		return ex.refundSnapshot(ctx, s, persistence.RefundReasonAmountLot)
+	}
+	return persistence.AmendOrderAction(ctx, order.OrderId, price.Persist(), amount.Persist(), s.CreatedAt, s.OpponentId)
+}
+
+func (ex *Exchange) getMarket(s *Snapshot, a *OrderAction) *persistence.Market {
 	var quote, base string
 	if a.S == engine.PageSideAsk {
 		quote, base = a.A.String(), s.Asset.AssetId
 	} else if a.S == engine.PageSideBid {
 		quote, base = s.Asset.AssetId, a.A.String()
 	} else {
-		return "", ""
-	}
-	if quote == base {
-		return "", ""
-	}
-	if quote != BitcoinAssetId && quote != USDTAssetId && quote != MixinAssetId {
-		return "", ""
-	}
-	if quote == BitcoinAssetId && base == USDTAssetId {
-		return "", ""
-	}
-	if quote == MixinAssetId && base == USDTAssetId {
-		return "", ""
-	}
-	if quote == MixinAssetId && base == BitcoinAssetId {
-		return "", ""
+		return nil
 	}
-	return quote, base
+	return ex.markets.fetch(base, quote)
 }
 
-func (ex *Exchange) refundSnapshot(ctx context.Context, s *Snapshot) error {
+func (ex *Exchange) refundSnapshot(ctx context.Context, s *Snapshot, reason string) error {
 	amount := number.FromString(s.Amount).Mul(number.FromString("0.999"))
 	if amount.Exhausted() {
 		return nil
 	}
-	return persistence.CreateRefundTransfer(ctx, s.UserId, s.OpponentId, s.Asset.AssetId, amount, s.TraceId)
+	return persistence.CreateRefundTransfer(ctx, s.UserId, s.OpponentId, s.Asset.AssetId, amount, s.TraceId, reason)
+}
+
+func isMultipleOf(value, step number.Decimal) bool {
+	if !step.IsPositive() {
+		return true
+	}
+	quotient := value.Div(step)
+	return quotient.RoundFloor(0).Cmp(quotient) == 0
 }
 
 func (ex *Exchange) decryptOrderAction(ctx context.Context, data string) (*OrderAction, error) {
@@ -213,6 +325,20 @@ func (ex *Exchange) decryptOrderAction(ctx context.Context, data string) (*Order
 		action.T = engine.OrderTypeLimit
 	case "M":
 		action.T = engine.OrderTypeMarket
+	case "SL":
+		action.T = engine.OrderTypeStopLimit
+	case "SM":
+		action.T = engine.OrderTypeStopMarket
+	}
+	switch action.F {
+	case "G":
+		action.F = engine.TimeInForceGTC
+	case "I":
+		action.F = engine.TimeInForceIOC
+	case "F":
+		action.F = engine.TimeInForceFOK
+	case "P":
+		action.F = engine.TimeInForcePostOnly
 	}
 	switch action.S {
 	case "A":
@@ -223,43 +349,18 @@ func (ex *Exchange) decryptOrderAction(ctx context.Context, data string) (*Order
 	return &action, nil
 }
 
-func (ex *Exchange) requestMixinNetwork(ctx context.Context, checkpoint time.Time, limit int) ([]*Snapshot, error) {
-	uri := fmt.Sprintf("/network/snapshots?offset=%s&order=ASC&limit=%d", checkpoint.Format(time.RFC3339Nano), limit)
-	token, err := bot.SignAuthenticationToken(config.ClientId, config.SessionId, config.SessionKey, "GET", uri, "")
-	if err != nil {
-		return nil, err
-	}
-	body, err := bot.Request(ctx, "GET", uri, nil, token)
-	if err != nil {
-		return nil, err
-	}
-	var resp struct {
-		Data  []*Snapshot `json:"data"`
-		Error string      `json:"error"`
-	}
-	err = json.Unmarshal(body, &resp)
-	if err != nil {
-		return nil, err
-	}
-	if resp.Error != "" {
-		return nil, errors.New(resp.Error)
-	}
-	return resp.Data, nil
-}
-
 func (ex *Exchange) sendTransfer(ctx context.Context, brokerId, recipientId, assetId string, amount number.Decimal, traceId, memo string) error {
 	mutex := ex.mutexes.fetch(recipientId, assetId)
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	broker := ex.brokers[brokerId]
-	return bot.CreateTransfer(ctx, &bot.TransferInput{
+	return ex.network.CreateTransfer(ctx, ex.brokers[brokerId], &bot.TransferInput{
 		AssetId:     assetId,
 		RecipientId: recipientId,
 		Amount:      amount,
 		TraceId:     traceId,
 		Memo:        memo,
-	}, broker.BrokerId, broker.SessionId, broker.SessionKey, broker.DecryptedPIN, broker.PINToken)
+	})
 }
 
 type tmap struct {
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MixinNetwork/bot-api-go-client"
+	"github.com/MixinNetwork/ocean.one/config"
+	"github.com/MixinNetwork/ocean.one/persistence"
+)
+
+type MixinNetwork interface {
+	ReadSnapshots(ctx context.Context, checkpoint time.Time, limit int) ([]*Snapshot, error)
+	CreateTransfer(ctx context.Context, broker *persistence.Broker, in *bot.TransferInput) error
+	LoopMessages(ctx context.Context, listener bot.BlazeListener) error
+}
+
+type mixinNetworkClient struct{}
+
+func NewMixinNetworkClient() MixinNetwork {
+	return &mixinNetworkClient{}
+}
+
+func (c *mixinNetworkClient) ReadSnapshots(ctx context.Context, checkpoint time.Time, limit int) ([]*Snapshot, error) {
+	uri := fmt.Sprintf("/network/snapshots?offset=%s&order=ASC&limit=%d", checkpoint.Format(time.RFC3339Nano), limit)
+	token, err := bot.SignAuthenticationToken(config.ClientId, config.SessionId, config.SessionKey, "GET", uri, "")
+	if err != nil {
+		return nil, err
+	}
+	body, err := bot.Request(ctx, "GET", uri, nil, token)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Data  []*Snapshot `json:"data"`
+		Error string      `json:"error"`
+	}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Data, nil
+}
+
+func (c *mixinNetworkClient) CreateTransfer(ctx context.Context, broker *persistence.Broker, in *bot.TransferInput) error {
+	return bot.CreateTransfer(ctx, in, broker.BrokerId, broker.SessionId, broker.SessionKey, broker.DecryptedPIN, broker.PINToken)
+}
+
+func (c *mixinNetworkClient) LoopMessages(ctx context.Context, listener bot.BlazeListener) error {
+	return bot.NewBlazeClient(config.ClientId, config.SessionId, config.SessionKey).Loop(ctx, listener)
+}
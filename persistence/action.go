@@ -2,18 +2,16 @@ package persistence
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"time"
 
-	"cloud.google.com/go/spanner"
 	"github.com/MixinNetwork/ocean.one/engine"
-	"google.golang.org/api/iterator"
 )
 
 const (
-	OrderStatePending = "PENDING"
-	OrderStateDone    = "DONE"
+	OrderStateUntriggered = "UNTRIGGERED"
+	OrderStatePending     = "PENDING"
+	OrderStateDone        = "DONE"
 )
 
 type Order struct {
@@ -27,6 +25,10 @@ type Order struct {
 	FilledAmount    string    `spanner:"filled_amount"`
 	RemainingFunds  string    `spanner:"remaining_funds"`
 	FilledFunds     string    `spanner:"filled_funds"`
+	TriggerPrice    string    `spanner:"trigger_price"`
+	TimeInForce     string    `spanner:"time_in_force"`
+	ExpiredAt       time.Time `spanner:"expired_at"`
+	DisplayAmount   string    `spanner:"display_amount"`
 	CreatedAt       time.Time `spanner:"created_at"`
 	State           string    `spanner:"state"`
 	UserId          string    `spanner:"user_id"`
@@ -36,86 +38,19 @@ type Order struct {
 type Action struct {
 	OrderId   string    `spanner:"order_id"`
 	Action    string    `spanner:"action"`
+	Price     string    `spanner:"price"`
+	Amount    string    `spanner:"amount"`
 	CreatedAt time.Time `spanner:"created_at"`
 
 	Order *Order `spanner:"-"`
 }
 
 func CountPendingActions(ctx context.Context) (int64, error) {
-	it := Spanner(ctx).Single().Query(ctx, spanner.Statement{
-		SQL: "SELECT COUNT(*) FROM actions",
-	})
-	defer it.Stop()
-
-	row, err := it.Next()
-	if err == iterator.Done {
-		return 0, nil
-	} else if err != nil {
-		return 0, err
-	}
-	var count int64
-	err = row.Columns(&count)
-	return count, err
+	return CurrentStore(ctx).CountPendingActions(ctx)
 }
 
 func ListPendingActions(ctx context.Context, checkpoint time.Time, limit int) ([]*Action, error) {
-	txn := Spanner(ctx).ReadOnlyTransaction()
-	defer txn.Close()
-
-	it := txn.Query(ctx, spanner.Statement{
-		SQL:    fmt.Sprintf("SELECT * FROM actions@{FORCE_INDEX=actions_by_created} WHERE created_at>=@checkpoint ORDER BY created_at LIMIT %d", limit),
-		Params: map[string]interface{}{"checkpoint": checkpoint},
-	})
-	defer it.Stop()
-
-	orderFilters := make(map[string]bool)
-	actions, orderIds := make([]*Action, 0), make([]string, 0)
-	for {
-		row, err := it.Next()
-		if err == iterator.Done {
-			break
-		} else if err != nil {
-			return actions, err
-		}
-		var action Action
-		err = row.ToStruct(&action)
-		if err != nil {
-			return actions, err
-		}
-		actions = append(actions, &action)
-		if orderFilters[action.OrderId] {
-			continue
-		}
-		orderFilters[action.OrderId] = true
-		orderIds = append(orderIds, action.OrderId)
-	}
-
-	oit := txn.Query(ctx, spanner.Statement{
-		SQL:    "SELECT * FROM orders WHERE order_id IN UNNEST(@order_ids)",
-		Params: map[string]interface{}{"order_ids": orderIds},
-	})
-	defer oit.Stop()
-
-	orders := make(map[string]*Order)
-	for {
-		row, err := oit.Next()
-		if err == iterator.Done {
-			break
-		} else if err != nil {
-			return actions, err
-		}
-		var order Order
-		err = row.ToStruct(&order)
-		if err != nil {
-			return actions, err
-		}
-		orders[order.OrderId] = &order
-	}
-
-	for _, a := range actions {
-		a.Order = orders[a.OrderId]
-	}
-	return actions, nil
+	return CurrentStore(ctx).ListPendingActions(ctx, checkpoint, limit)
 }
 
 func CreateOrderAction(ctx context.Context, o *engine.Order, userId, brokerId string, createdAt time.Time) error {
@@ -133,85 +68,70 @@ func CreateOrderAction(ctx context.Context, o *engine.Order, userId, brokerId st
 		FilledAmount:    o.FilledAmount.Persist(),
 		RemainingFunds:  o.RemainingFunds.Persist(),
 		FilledFunds:     o.FilledFunds.Persist(),
+		TriggerPrice:    o.TriggerPrice.Persist(),
+		TimeInForce:     o.TimeInForce,
+		ExpiredAt:       o.ExpiredAt,
+		DisplayAmount:   o.DisplayAmount.Persist(),
 		CreatedAt:       createdAt,
 		State:           OrderStatePending,
 		UserId:          userId,
 		BrokerId:        brokerId,
 	}
+	if o.Type == engine.OrderTypeStopLimit || o.Type == engine.OrderTypeStopMarket {
+		order.State = OrderStateUntriggered
+	}
 	action := Action{
 		OrderId:   order.OrderId,
 		Action:    engine.OrderActionCreate,
+		Price:     order.Price,
+		Amount:    order.RemainingAmount,
 		CreatedAt: createdAt,
 	}
-	_, err := Spanner(ctx).ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
-		state, err := checkOrderState(ctx, txn, action.OrderId)
-		if err != nil || state != nil {
-			return err
-		}
-		orderMutation, err := spanner.InsertStruct("orders", order)
-		if err != nil {
-			return err
-		}
-		actionMutation, err := spanner.InsertStruct("actions", action)
-		if err != nil {
-			return err
-		}
-		return txn.BufferWrite([]*spanner.Mutation{orderMutation, actionMutation})
-	})
-	return err
+	if o.Side == engine.PageSideBid {
+		action.Amount = order.RemainingFunds
+	}
+	return CurrentStore(ctx).CreateOrderAction(ctx, &order, &action)
 }
 
 func CancelOrderAction(ctx context.Context, orderId string, createdAt time.Time, userId string) error {
 	action := Action{
 		OrderId:   orderId,
 		Action:    engine.OrderActionCancel,
+		Price:     "0",
+		Amount:    "0",
 		CreatedAt: createdAt,
 	}
-	_, err := Spanner(ctx).ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
-		exist, err := checkActionExistence(ctx, txn, action.OrderId, action.Action)
-		if err != nil || exist {
-			return err
-		}
-		state, err := checkOrderState(ctx, txn, action.OrderId)
-		if err != nil || state == nil {
-			return err
-		}
-		if state.State != OrderStatePending || state.UserId != userId || state.OrderType != engine.OrderTypeLimit {
-			return nil
-		}
-		actionMutation, err := spanner.InsertStruct("actions", action)
-		if err != nil {
-			return err
-		}
-		return txn.BufferWrite([]*spanner.Mutation{actionMutation})
-	})
-	return err
+	return CurrentStore(ctx).CancelOrderAction(ctx, &action, userId)
 }
 
-func checkActionExistence(ctx context.Context, txn *spanner.ReadWriteTransaction, orderId, action string) (bool, error) {
-	it := txn.Read(ctx, "actions", spanner.Key{orderId, action}, []string{"created_at"})
-	defer it.Stop()
-
-	_, err := it.Next()
-	if err == iterator.Done {
-		return false, nil
-	} else if err != nil {
-		return false, err
+func AmendOrderAction(ctx context.Context, orderId, price, amount string, createdAt time.Time, userId string) error {
+	action := Action{
+		OrderId:   orderId,
+		Action:    engine.OrderActionAmend,
+		Price:     price,
+		Amount:    amount,
+		CreatedAt: createdAt,
 	}
-	return true, nil
+	return CurrentStore(ctx).AmendOrderAction(ctx, &action, userId)
+}
+
+func ReadOrder(ctx context.Context, orderId string) (*Order, error) {
+	return CurrentStore(ctx).ReadOrder(ctx, orderId)
 }
 
-func checkOrderState(ctx context.Context, txn *spanner.ReadWriteTransaction, orderId string) (*Order, error) {
-	it := txn.Read(ctx, "orders", spanner.Key{orderId}, []string{"order_type", "state", "user_id"})
-	defer it.Stop()
+func amendable(order *Order) bool {
+	if order.State != OrderStatePending {
+		return false
+	}
+	return order.OrderType == engine.OrderTypeLimit || order.OrderType == engine.OrderTypeStopLimit
+}
 
-	row, err := it.Next()
-	if err == iterator.Done {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
+func cancellable(order *Order) bool {
+	switch order.State {
+	case OrderStateUntriggered:
+		return order.OrderType == engine.OrderTypeStopLimit || order.OrderType == engine.OrderTypeStopMarket
+	case OrderStatePending:
+		return order.OrderType == engine.OrderTypeLimit || order.OrderType == engine.OrderTypeStopLimit
 	}
-	var o Order
-	err = row.Columns(&o.OrderType, &o.State, &o.UserId)
-	return &o, err
+	return false
 }
@@ -17,10 +17,8 @@ import (
 	"fmt"
 	"time"
 
-	"cloud.google.com/go/spanner"
 	"github.com/MixinNetwork/bot-api-go-client"
 	"github.com/MixinNetwork/ocean.one/config"
-	"google.golang.org/api/iterator"
 )
 
 const encryptionHeaderLength = 16
@@ -38,9 +36,6 @@ type Broker struct {
 }
 
 func AllBrokers(ctx context.Context, decryptPIN bool) ([]*Broker, error) {
-	it := Spanner(ctx).Single().Query(ctx, spanner.Statement{SQL: "SELECT * FROM brokers"})
-	defer it.Stop()
-
 	brokers := []*Broker{
 		&Broker{
 			BrokerId:     config.ClientId,
@@ -51,26 +46,20 @@ func AllBrokers(ctx context.Context, decryptPIN bool) ([]*Broker, error) {
 		},
 	}
 
-	for {
-		row, err := it.Next()
-		if err == iterator.Done {
-			return brokers, nil
-		} else if err != nil {
-			return brokers, err
-		}
-		var broker Broker
-		err = row.ToStruct(&broker)
-		if err != nil {
-			return brokers, err
-		}
+	stored, err := CurrentStore(ctx).ReadBrokers(ctx)
+	if err != nil {
+		return brokers, err
+	}
+	for _, broker := range stored {
 		if decryptPIN {
 			err = broker.decryptPIN()
 			if err != nil {
 				return brokers, err
 			}
 		}
-		brokers = append(brokers, &broker)
+		brokers = append(brokers, broker)
 	}
+	return brokers, nil
 }
 
 func AddBroker(ctx context.Context) (*Broker, error) {
@@ -131,11 +120,7 @@ func AddBroker(ctx context.Context) (*Broker, error) {
 	if err != nil {
 		return nil, err
 	}
-	insertBroker, err := spanner.InsertStruct("brokers", broker)
-	if err != nil {
-		return nil, err
-	}
-	_, err = Spanner(ctx).Apply(ctx, []*spanner.Mutation{insertBroker})
+	err = CurrentStore(ctx).CreateBroker(ctx, broker)
 	return broker, err
 }
 
@@ -2,21 +2,19 @@ package persistence
 
 import (
 	"context"
-
-	"cloud.google.com/go/spanner"
 )
 
 type contextValueKey int
 
 const (
-	keySpanner contextValueKey = 1
+	keyStore contextValueKey = 1
 )
 
-func SetupSpanner(ctx context.Context, client *spanner.Client) context.Context {
-	return context.WithValue(ctx, keySpanner, client)
+func SetupStore(ctx context.Context, store Store) context.Context {
+	return context.WithValue(ctx, keyStore, store)
 }
 
-func Spanner(ctx context.Context) *spanner.Client {
-	v, _ := ctx.Value(keySpanner).(*spanner.Client)
+func CurrentStore(ctx context.Context) Store {
+	v, _ := ctx.Value(keyStore).(Store)
 	return v
 }
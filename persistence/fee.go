@@ -0,0 +1,352 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	FeeVolumeWindow = 30 * 24 * time.Hour
+	MaxFeeRate      = "0.1"
+
+	FeeLiquidityCarry = "CARRY"
+)
+
+type FeeTier struct {
+	Market        string    `spanner:"market"`
+	BrokerId      string    `spanner:"broker_id"`
+	MinimumVolume string    `spanner:"minimum_volume"`
+	MakerFeeRate  string    `spanner:"maker_fee_rate"`
+	TakerFeeRate  string    `spanner:"taker_fee_rate"`
+	UpdatedAt     time.Time `spanner:"updated_at"`
+}
+
+func (t *FeeTier) Rate(liquidity string) string {
+	if liquidity == TradeLiquidityMaker {
+		return t.MakerFeeRate
+	}
+	return t.TakerFeeRate
+}
+
+func AllFeeTiers(ctx context.Context) ([]*FeeTier, error) {
+	return CurrentStore(ctx).ReadFeeTiers(ctx)
+}
+
+func WriteFeeTier(ctx context.Context, tier *FeeTier) error {
+	if tier.Market != "" {
+		base, quote := getBaseQuote(tier.Market)
+		if base == "" || quote == "" {
+			return fmt.Errorf("invalid fee tier market %s", tier.Market)
+		}
+		tier.Market = base + "-" + quote
+	}
+	if tier.BrokerId != "" {
+		id, err := uuid.FromString(tier.BrokerId)
+		if err != nil {
+			return fmt.Errorf("invalid fee tier broker %s", tier.BrokerId)
+		}
+		tier.BrokerId = id.String()
+	}
+	volume := number.FromString(tier.MinimumVolume)
+	if volume.Cmp(number.Zero()) < 0 {
+		return fmt.Errorf("invalid fee tier minimum volume %s", tier.MinimumVolume)
+	}
+	maxRate := number.FromString(MaxFeeRate)
+	taker := number.FromString(tier.TakerFeeRate)
+	if taker.Cmp(number.Zero()) < 0 || taker.Cmp(maxRate) > 0 {
+		return fmt.Errorf("invalid fee tier taker rate %s", tier.TakerFeeRate)
+	}
+	maker := number.FromString(tier.MakerFeeRate)
+	if maker.Add(taker).Cmp(number.Zero()) < 0 || maker.Cmp(maxRate) > 0 {
+		return fmt.Errorf("invalid fee tier maker rate %s", tier.MakerFeeRate)
+	}
+
+	tier.MinimumVolume = volume.Persist()
+	tier.MakerFeeRate = maker.Persist()
+	tier.TakerFeeRate = taker.Persist()
+	tier.UpdatedAt = time.Now().UTC()
+	return CurrentStore(ctx).WriteFeeTier(ctx, tier)
+}
+
+func RemoveFeeTier(ctx context.Context, market, brokerId, minimumVolume string) error {
+	return CurrentStore(ctx).DeleteFeeTier(ctx, market, brokerId, number.FromString(minimumVolume).Persist())
+}
+
+func UserTradeVolume(ctx context.Context, userId, market string, since time.Time) (number.Decimal, error) {
+	base, quote := getBaseQuote(market)
+	if base == "" || quote == "" {
+		return number.Zero(), nil
+	}
+	return CurrentStore(ctx).UserTradeVolume(ctx, userId, base, quote, since)
+}
+
+// MatchFeeTier searches the broker market, broker, market and default scopes in order,
+// and returns the highest tier reached by the volume in the first scope reached.
+func MatchFeeTier(tiers []*FeeTier, market, brokerId string, volume number.Decimal) *FeeTier {
+	for _, scope := range [][2]string{{market, brokerId}, {"", brokerId}, {market, ""}, {"", ""}} {
+		var match *FeeTier
+		for _, t := range tiers {
+			if t.Market != scope[0] || t.BrokerId != scope[1] {
+				continue
+			}
+			minimum := number.FromString(t.MinimumVolume)
+			if minimum.Cmp(volume) > 0 {
+				continue
+			}
+			if match == nil || minimum.Cmp(number.FromString(match.MinimumVolume)) > 0 {
+				match = t
+			}
+		}
+		if match != nil {
+			return match
+		}
+	}
+	return nil
+}
+
+type BrokerFeeShare struct {
+	BrokerId     string    `spanner:"broker_id"`
+	ShareRate    string    `spanner:"share_rate"`
+	PayoutUserId string    `spanner:"payout_user_id"`
+	UpdatedAt    time.Time `spanner:"updated_at"`
+}
+
+func AllBrokerFeeShares(ctx context.Context) ([]*BrokerFeeShare, error) {
+	return CurrentStore(ctx).ReadBrokerFeeShares(ctx)
+}
+
+func WriteBrokerFeeShare(ctx context.Context, share *BrokerFeeShare) error {
+	id, err := uuid.FromString(share.BrokerId)
+	if err != nil {
+		return fmt.Errorf("invalid fee share broker %s", share.BrokerId)
+	}
+	share.BrokerId = id.String()
+	id, err = uuid.FromString(share.PayoutUserId)
+	if err != nil {
+		return fmt.Errorf("invalid fee share payout user %s", share.PayoutUserId)
+	}
+	share.PayoutUserId = id.String()
+	rate := number.FromString(share.ShareRate)
+	if rate.Cmp(number.Zero()) < 0 || rate.Cmp(number.FromString("1")) > 0 {
+		return fmt.Errorf("invalid fee share rate %s", share.ShareRate)
+	}
+
+	share.ShareRate = rate.Persist()
+	share.UpdatedAt = time.Now().UTC()
+	return CurrentStore(ctx).WriteBrokerFeeShare(ctx, share)
+}
+
+// FeeEntry is a fee collected by a trade and held by the BrokerId account, it's paid out
+// to the treasury when BeneficiaryId is empty, otherwise to the beneficiary broker. A negative
+// entry is a maker rebate, and a CARRY entry is the balance carried forward by a payout.
+type FeeEntry struct {
+	TradeId       string    `spanner:"trade_id"`
+	Liquidity     string    `spanner:"liquidity"`
+	BeneficiaryId string    `spanner:"beneficiary_id"`
+	BrokerId      string    `spanner:"broker_id"`
+	AssetId       string    `spanner:"asset_id"`
+	Amount        string    `spanner:"amount"`
+	CreatedAt     time.Time `spanner:"created_at"`
+	PayoutId      string    `spanner:"payout_id"`
+}
+
+type FeePayout struct {
+	PayoutId      string    `spanner:"payout_id"`
+	BeneficiaryId string    `spanner:"beneficiary_id"`
+	BrokerId      string    `spanner:"broker_id"`
+	AssetId       string    `spanner:"asset_id"`
+	Amount        string    `spanner:"amount"`
+	Entries       int64     `spanner:"entries"`
+	UserId        string    `spanner:"user_id"`
+	CreatedAt     time.Time `spanner:"created_at"`
+}
+
+type FeeReport struct {
+	BeneficiaryId string
+	AssetId       string
+	Paid          string
+	Unpaid        string
+}
+
+func ListUnpaidFees(ctx context.Context, beneficiaryId string, limit int) ([]*FeeEntry, error) {
+	return CurrentStore(ctx).ListUnpaidFees(ctx, beneficiaryId, limit)
+}
+
+func BeneficiaryFeeReports(ctx context.Context, beneficiaryId string, limit int) ([]*FeeReport, error) {
+	payouts, err := CurrentStore(ctx).ReadFeePayouts(ctx, beneficiaryId)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ListUnpaidFees(ctx, beneficiaryId, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var assets []string
+	paid, unpaid := make(map[string]number.Decimal), make(map[string]number.Decimal)
+	for _, p := range payouts {
+		if _, found := paid[p.AssetId]; !found {
+			assets = append(assets, p.AssetId)
+			paid[p.AssetId], unpaid[p.AssetId] = number.Zero(), number.Zero()
+		}
+		paid[p.AssetId] = paid[p.AssetId].Add(number.FromString(p.Amount))
+	}
+	for _, e := range entries {
+		if _, found := paid[e.AssetId]; !found {
+			assets = append(assets, e.AssetId)
+			paid[e.AssetId], unpaid[e.AssetId] = number.Zero(), number.Zero()
+		}
+		unpaid[e.AssetId] = unpaid[e.AssetId].Add(number.FromString(e.Amount))
+	}
+
+	reports := make([]*FeeReport, 0)
+	for _, a := range assets {
+		reports = append(reports, &FeeReport{
+			BeneficiaryId: beneficiaryId,
+			AssetId:       a,
+			Paid:          paid[a].Persist(),
+			Unpaid:        unpaid[a].Persist(),
+		})
+	}
+	return reports, nil
+}
+
+// PayoutFees pays the unpaid fees to the treasury, and the broker shares to the
+// payout users of the brokers, the treasury payouts are skipped if treasury is empty.
+func PayoutFees(ctx context.Context, treasury string, limit int) ([]*FeePayout, error) {
+	shares, err := AllBrokerFeeShares(ctx)
+	if err != nil {
+		return nil, err
+	}
+	payouts := make([]*FeePayout, 0)
+	if treasury != "" {
+		payouts, err = payoutFees(ctx, "", treasury, limit)
+		if err != nil {
+			return payouts, err
+		}
+	}
+	for _, s := range shares {
+		p, err := payoutFees(ctx, s.BrokerId, s.PayoutUserId, limit)
+		payouts = append(payouts, p...)
+		if err != nil {
+			return payouts, err
+		}
+	}
+	return payouts, nil
+}
+
+func payoutFees(ctx context.Context, beneficiaryId, userId string, limit int) ([]*FeePayout, error) {
+	entries, err := ListUnpaidFees(ctx, beneficiaryId, limit)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	groups := make(map[string][]*FeeEntry)
+	for _, e := range entries {
+		key := e.BrokerId + e.AssetId
+		if groups[key] == nil {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], e)
+	}
+
+	payouts := make([]*FeePayout, 0)
+	for _, key := range keys {
+		group := groups[key]
+		first := group[0]
+		if len(group) == 1 && first.Liquidity == FeeLiquidityCarry {
+			continue
+		}
+		total := number.Zero()
+		for _, e := range group {
+			total = total.Add(number.FromString(e.Amount))
+		}
+		amount := total.RoundFloor(8)
+		if !amount.IsPositive() {
+			amount = number.Zero()
+		}
+		payout := &FeePayout{
+			PayoutId:      getSettlementId(first.TradeId, first.Liquidity+beneficiaryId+TransferSourceFeePayout),
+			BeneficiaryId: beneficiaryId,
+			BrokerId:      first.BrokerId,
+			AssetId:       first.AssetId,
+			Amount:        amount.Persist(),
+			Entries:       int64(len(group)),
+			UserId:        userId,
+			CreatedAt:     time.Now().UTC(),
+		}
+		var transfer *Transfer
+		if amount.IsPositive() {
+			transfer = &Transfer{
+				TransferId: getSettlementId(payout.PayoutId, TransferSourceFeePayout),
+				Source:     TransferSourceFeePayout,
+				Detail:     payout.PayoutId,
+				AssetId:    payout.AssetId,
+				Amount:     payout.Amount,
+				CreatedAt:  payout.CreatedAt,
+				UserId:     userId,
+				BrokerId:   payout.BrokerId,
+			}
+		}
+		// the rebates exceeding the fees and the rounded off fees are netted by later payouts
+		var carry *FeeEntry
+		if rest := total.Sub(amount); !rest.IsZero() {
+			carry = &FeeEntry{
+				TradeId:       payout.PayoutId,
+				Liquidity:     FeeLiquidityCarry,
+				BeneficiaryId: beneficiaryId,
+				BrokerId:      payout.BrokerId,
+				AssetId:       payout.AssetId,
+				Amount:        rest.Persist(),
+				CreatedAt:     payout.CreatedAt,
+			}
+		}
+		err = CurrentStore(ctx).CreateFeePayout(ctx, payout, group, transfer, carry)
+		if err != nil {
+			return payouts, err
+		}
+		payouts = append(payouts, payout)
+	}
+	return payouts, nil
+}
+
+func makeFeeEntries(trades []*Trade, transfers []*Transfer, takerBrokerId, brokerShareRate string) []*FeeEntry {
+	entries := make([]*FeeEntry, 0)
+	for i, t := range trades {
+		amount := number.FromString(t.FeeAmount)
+		if amount.Cmp(number.Zero()) == 0 {
+			continue
+		}
+		entry := &FeeEntry{
+			TradeId:   t.TradeId,
+			Liquidity: t.Liquidity,
+			BrokerId:  transfers[i].BrokerId,
+			AssetId:   t.FeeAssetId,
+			Amount:    t.FeeAmount,
+			CreatedAt: t.CreatedAt,
+		}
+		entries = append(entries, entry)
+		if t.Liquidity != TradeLiquidityTaker || !amount.IsPositive() {
+			continue
+		}
+		share := amount.Mul(number.FromString(brokerShareRate)).RoundFloor(8)
+		if !share.IsPositive() {
+			continue
+		}
+		entry.Amount = amount.Sub(share).Persist()
+		entries = append(entries, &FeeEntry{
+			TradeId:       t.TradeId,
+			Liquidity:     t.Liquidity,
+			BeneficiaryId: takerBrokerId,
+			BrokerId:      entry.BrokerId,
+			AssetId:       entry.AssetId,
+			Amount:        share.Persist(),
+			CreatedAt:     t.CreatedAt,
+		})
+	}
+	return entries
+}
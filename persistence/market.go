@@ -3,108 +3,187 @@ package persistence
 import (
 	"context"
 	"fmt"
-	"sort"
-	"strings"
 	"time"
 
-	"cloud.google.com/go/spanner"
+	"github.com/MixinNetwork/go-number"
 	"github.com/satori/go.uuid"
-	"google.golang.org/api/iterator"
 )
 
-func LastTrade(ctx context.Context, market string) (*Trade, error) {
+const (
+	MarketStatusActive     = "ACTIVE"
+	MarketStatusHalted     = "HALTED"
+	MarketStatusCancelOnly = "CANCEL_ONLY"
+
+	MaxMarketPrecision = 8
+)
+
+type Market struct {
+	BaseAssetId     string    `spanner:"base_asset_id"`
+	QuoteAssetId    string    `spanner:"quote_asset_id"`
+	PricePrecision  int64     `spanner:"price_precision"`
+	AmountPrecision int64     `spanner:"amount_precision"`
+	MinimumFunds    string    `spanner:"minimum_funds"`
+	TickSize        string    `spanner:"tick_size"`
+	LotSize         string    `spanner:"lot_size"`
+	Status          string    `spanner:"status"`
+	CreatedAt       time.Time `spanner:"created_at"`
+	UpdatedAt       time.Time `spanner:"updated_at"`
+}
+
+type MarketAudit struct {
+	AuditId        string    `spanner:"audit_id"`
+	BaseAssetId    string    `spanner:"base_asset_id"`
+	QuoteAssetId   string    `spanner:"quote_asset_id"`
+	PreviousStatus string    `spanner:"previous_status"`
+	Status         string    `spanner:"status"`
+	Operator       string    `spanner:"operator"`
+	Reason         string    `spanner:"reason"`
+	CreatedAt      time.Time `spanner:"created_at"`
+}
+
+func (m *Market) Id() string {
+	return m.BaseAssetId + "-" + m.QuoteAssetId
+}
+
+func AllMarkets(ctx context.Context) ([]*Market, error) {
+	return CurrentStore(ctx).ReadMarkets(ctx)
+}
+
+func ReadMarket(ctx context.Context, market string) (*Market, error) {
 	base, quote := getBaseQuote(market)
 	if base == "" || quote == "" {
 		return nil, nil
 	}
+	markets, err := AllMarkets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range markets {
+		if m.BaseAssetId == base && m.QuoteAssetId == quote {
+			return m, nil
+		}
+	}
+	return nil, nil
+}
 
-	it := Spanner(ctx).Single().Query(ctx, spanner.Statement{
-		SQL:    "SELECT * FROM trades@{FORCE_INDEX=trades_by_base_quote_created_desc} WHERE base_asset_id=@base AND quote_asset_id=@quote ORDER BY base_asset_id,quote_asset_id,created_at DESC",
-		Params: map[string]interface{}{"base": base, "quote": quote},
-	})
-	defer it.Stop()
+func WriteMarket(ctx context.Context, market *Market) error {
+	if market.BaseAssetId == market.QuoteAssetId {
+		return fmt.Errorf("invalid market %s", market.Id())
+	}
+	if base, quote := getBaseQuote(market.Id()); base == "" || quote == "" {
+		return fmt.Errorf("invalid market %s", market.Id())
+	}
+	switch market.Status {
+	case MarketStatusActive, MarketStatusHalted, MarketStatusCancelOnly:
+	default:
+		return fmt.Errorf("invalid market status %s", market.Status)
+	}
+	if market.PricePrecision < 0 || market.PricePrecision > MaxMarketPrecision {
+		return fmt.Errorf("invalid market price precision %d", market.PricePrecision)
+	}
+	if market.AmountPrecision < 0 || market.AmountPrecision > MaxMarketPrecision {
+		return fmt.Errorf("invalid market amount precision %d", market.AmountPrecision)
+	}
+	minimum := number.FromString(market.MinimumFunds)
+	if minimum.Cmp(number.Zero()) < 0 {
+		return fmt.Errorf("invalid market minimum funds %s", market.MinimumFunds)
+	}
+	tick := number.FromString(market.TickSize)
+	if tick.Cmp(number.Zero()) <= 0 || tick.Integer(uint8(market.PricePrecision)).Decimal().Cmp(tick) != 0 {
+		return fmt.Errorf("invalid market tick size %s", market.TickSize)
+	}
+	lot := number.FromString(market.LotSize)
+	if lot.Cmp(number.Zero()) <= 0 || lot.Integer(uint8(market.AmountPrecision)).Decimal().Cmp(lot) != 0 {
+		return fmt.Errorf("invalid market lot size %s", market.LotSize)
+	}
 
-	row, err := it.Next()
-	if err == iterator.Done {
-		return nil, nil
-	} else if err != nil {
+	old, err := ReadMarket(ctx, market.Id())
+	if err != nil {
+		return err
+	}
+	market.UpdatedAt = time.Now().UTC()
+	market.CreatedAt = market.UpdatedAt
+	if old != nil {
+		if old.PricePrecision != market.PricePrecision || old.AmountPrecision != market.AmountPrecision {
+			return fmt.Errorf("market %s precision can not be changed", market.Id())
+		}
+		market.CreatedAt = old.CreatedAt
+		market.Status = old.Status
+	}
+	market.MinimumFunds = minimum.Persist()
+	market.TickSize = tick.Persist()
+	market.LotSize = lot.Persist()
+	return CurrentStore(ctx).WriteMarket(ctx, market, nil)
+}
+
+func UpdateMarketStatus(ctx context.Context, id, status, operator, reason string) (*Market, error) {
+	switch status {
+	case MarketStatusActive, MarketStatusHalted, MarketStatusCancelOnly:
+	default:
+		return nil, fmt.Errorf("invalid market status %s", status)
+	}
+	if operator == "" || reason == "" {
+		return nil, fmt.Errorf("market %s status change requires operator and reason", id)
+	}
+	market, err := ReadMarket(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if market == nil {
+		return nil, fmt.Errorf("market %s not found", id)
+	}
+
+	auditId, err := uuid.NewV4()
+	if err != nil {
 		return nil, err
 	}
-	var t Trade
-	err = row.ToStruct(&t)
-	return &t, err
+	audit := &MarketAudit{
+		AuditId:        auditId.String(),
+		BaseAssetId:    market.BaseAssetId,
+		QuoteAssetId:   market.QuoteAssetId,
+		PreviousStatus: market.Status,
+		Status:         status,
+		Operator:       operator,
+		Reason:         reason,
+		CreatedAt:      time.Now().UTC(),
+	}
+	market.Status = status
+	market.UpdatedAt = audit.CreatedAt
+	return market, CurrentStore(ctx).WriteMarket(ctx, market, audit)
 }
 
-func MarketTrades(ctx context.Context, market string, offset time.Time, order string, limit int) ([]*Trade, error) {
-	txn := Spanner(ctx).ReadOnlyTransaction()
-	defer txn.Close()
+func MarketAudits(ctx context.Context, id string, limit int) ([]*MarketAudit, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 100
+	}
+	base, quote := getBaseQuote(id)
+	if base == "" || quote == "" {
+		return nil, nil
+	}
+	return CurrentStore(ctx).ReadMarketAudits(ctx, base, quote, limit)
+}
 
+func LastTrade(ctx context.Context, market string) (*Trade, error) {
+	base, quote := getBaseQuote(market)
+	if base == "" || quote == "" {
+		return nil, nil
+	}
+	return CurrentStore(ctx).LastTrade(ctx, base, quote)
+}
+
+func MarketTrades(ctx context.Context, market string, offset time.Time, order string, limit int) ([]*Trade, error) {
 	if limit > 100 {
 		limit = 100
 	}
-	cmp := "<"
 	if order != "DESC" {
 		order = "ASC"
-		cmp = ">"
 	}
 
 	base, quote := getBaseQuote(market)
 	if base == "" || quote == "" {
 		return nil, nil
 	}
-
-	query := "SELECT trade_id FROM trades@{FORCE_INDEX=trades_by_base_quote_created_%s} WHERE base_asset_id=@base AND quote_asset_id=@quote AND created_at%s=@offset AND liquidity=@liquidity"
-	query = fmt.Sprintf(query, strings.ToLower(order), cmp)
-	query = query + " ORDER BY base_asset_id,quote_asset_id,created_at " + order
-	query = fmt.Sprintf("%s LIMIT %d", query, limit)
-	params := map[string]interface{}{"base": base, "quote": quote, "offset": offset, "liquidity": TradeLiquidityMaker}
-
-	iit := txn.Query(ctx, spanner.Statement{query, params})
-	defer iit.Stop()
-
-	var tradeIds []string
-	for {
-		row, err := iit.Next()
-		if err == iterator.Done {
-			break
-		} else if err != nil {
-			return nil, err
-		}
-		var id string
-		err = row.Columns(&id)
-		if err != nil {
-			return nil, err
-		}
-		tradeIds = append(tradeIds, id)
-	}
-
-	tit := txn.Query(ctx, spanner.Statement{
-		SQL:    "SELECT * FROM trades WHERE trade_id IN UNNEST(@trade_ids) AND liquidity=@liquidity",
-		Params: map[string]interface{}{"trade_ids": tradeIds, "liquidity": TradeLiquidityMaker},
-	})
-	defer tit.Stop()
-
-	var trades []*Trade
-	for {
-		row, err := tit.Next()
-		if err == iterator.Done {
-			break
-		} else if err != nil {
-			return trades, err
-		}
-		var t Trade
-		err = row.ToStruct(&t)
-		if err != nil {
-			return trades, err
-		}
-		trades = append(trades, &t)
-	}
-	if order == "DESC" {
-		sort.Slice(trades, func(i, j int) bool { return trades[i].CreatedAt.After(trades[j].CreatedAt) })
-	} else {
-		sort.Slice(trades, func(i, j int) bool { return trades[i].CreatedAt.Before(trades[j].CreatedAt) })
-	}
-	return trades, nil
+	return CurrentStore(ctx).MarketTrades(ctx, base, quote, offset, order, limit)
 }
 
 func getBaseQuote(market string) (string, string) {
@@ -0,0 +1,636 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/engine"
+)
+
+type memoryStore struct {
+	sync.Mutex
+	orders     map[string]*Order
+	actions    map[string]*Action
+	trades     []*Trade
+	transfers  []*Transfer
+	brokers    []*Broker
+	markets    map[string]*Market
+	audits     []*MarketAudit
+	fees       map[string]*FeeTier
+	shares     map[string]*BrokerFeeShare
+	ledger     []*FeeEntry
+	payouts    []*FeePayout
+	properties map[string]string
+	users      map[string]string
+	snapshots  map[string]*BookSnapshot
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{
+		orders:     make(map[string]*Order),
+		actions:    make(map[string]*Action),
+		markets:    make(map[string]*Market),
+		fees:       make(map[string]*FeeTier),
+		shares:     make(map[string]*BrokerFeeShare),
+		properties: make(map[string]string),
+		users:      make(map[string]string),
+		snapshots:  make(map[string]*BookSnapshot),
+	}
+}
+
+func (m *memoryStore) CountPendingActions(ctx context.Context) (int64, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return int64(len(m.actions)), nil
+}
+
+func (m *memoryStore) ListPendingActions(ctx context.Context, checkpoint time.Time, limit int) ([]*Action, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	actions := make([]*Action, 0)
+	for _, a := range m.actions {
+		if a.CreatedAt.Before(checkpoint) {
+			continue
+		}
+		action, order := *a, *m.orders[a.OrderId]
+		action.Order = &order
+		actions = append(actions, &action)
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		if actions[i].CreatedAt.Equal(actions[j].CreatedAt) {
+			return actions[i].OrderId+actions[i].Action < actions[j].OrderId+actions[j].Action
+		}
+		return actions[i].CreatedAt.Before(actions[j].CreatedAt)
+	})
+	if len(actions) > limit {
+		actions = actions[:limit]
+	}
+	return actions, nil
+}
+
+func (m *memoryStore) CreateOrderAction(ctx context.Context, order *Order, action *Action) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.orders[order.OrderId] != nil {
+		return nil
+	}
+	o, a := *order, *action
+	m.orders[o.OrderId] = &o
+	m.actions[a.OrderId+a.Action] = &a
+	return nil
+}
+
+func (m *memoryStore) CancelOrderAction(ctx context.Context, action *Action, userId string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.actions[action.OrderId+action.Action] != nil {
+		return nil
+	}
+	state := m.orders[action.OrderId]
+	if state == nil || state.UserId != userId || !cancellable(state) {
+		return nil
+	}
+	a := *action
+	m.actions[a.OrderId+a.Action] = &a
+	return nil
+}
+
+func (m *memoryStore) AmendOrderAction(ctx context.Context, action *Action, userId string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.actions[action.OrderId+engine.OrderActionCancel] != nil {
+		return nil
+	}
+	state := m.orders[action.OrderId]
+	if state == nil || state.UserId != userId || !amendable(state) {
+		return nil
+	}
+	a := *action
+	m.actions[a.OrderId+a.Action] = &a
+	return nil
+}
+
+func (m *memoryStore) ReadOrder(ctx context.Context, orderId string) (*Order, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	o := m.orders[orderId]
+	if o == nil {
+		return nil, nil
+	}
+	order := *o
+	return &order, nil
+}
+
+func (m *memoryStore) UserOrders(ctx context.Context, userId, base, quote, state string, offset time.Time, order string, limit int) ([]*Order, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var orders []*Order
+	for _, o := range m.orders {
+		if o.UserId != userId || o.State != state {
+			continue
+		}
+		if base != "" && quote != "" && (o.BaseAssetId != base || o.QuoteAssetId != quote) {
+			continue
+		}
+		if order == "DESC" && !o.CreatedAt.Before(offset) || order != "DESC" && !o.CreatedAt.After(offset) {
+			continue
+		}
+		c := *o
+		orders = append(orders, &c)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if order == "DESC" {
+			return orders[i].CreatedAt.After(orders[j].CreatedAt)
+		}
+		return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+	})
+	if len(orders) > limit {
+		orders = orders[:limit]
+	}
+	return orders, nil
+}
+
+func (m *memoryStore) CancelOrder(ctx context.Context, order *engine.Order, transfer *Transfer) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if err := m.checkTransfers(transfer); err != nil {
+		return err
+	}
+	if o := m.orders[order.Id]; o != nil {
+		o.FilledAmount, o.RemainingAmount = order.FilledAmount.Persist(), order.RemainingAmount.Persist()
+		o.FilledFunds, o.RemainingFunds = order.FilledFunds.Persist(), order.RemainingFunds.Persist()
+		o.State = OrderStateDone
+	}
+	m.deleteActions(order.Id)
+	m.createTransfer(transfer)
+	return nil
+}
+
+func (m *memoryStore) DecrementOrder(ctx context.Context, order *engine.Order, transfer *Transfer) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if err := m.checkTransfers(transfer); err != nil {
+		return err
+	}
+	if o := m.orders[order.Id]; o != nil {
+		o.RemainingAmount, o.RemainingFunds = order.RemainingAmount.Persist(), order.RemainingFunds.Persist()
+	}
+	m.createTransfer(transfer)
+	return nil
+}
+
+func (m *memoryStore) AmendOrder(ctx context.Context, order *engine.Order, transfer *Transfer) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if transfer != nil {
+		if err := m.checkTransfers(transfer); err != nil {
+			return err
+		}
+		m.createTransfer(transfer)
+	}
+	if o := m.orders[order.Id]; o != nil {
+		o.Price = order.Price.Persist()
+		o.RemainingAmount, o.RemainingFunds = order.RemainingAmount.Persist(), order.RemainingFunds.Persist()
+	}
+	return nil
+}
+
+func (m *memoryStore) TriggerOrder(ctx context.Context, orderId string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if o := m.orders[orderId]; o != nil {
+		o.State = OrderStatePending
+	}
+	return nil
+}
+
+func (m *memoryStore) Transact(ctx context.Context, taker, maker *engine.Order, trades []*Trade, transfers []*Transfer, fees []*FeeEntry) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if err := m.checkTransfers(transfers...); err != nil {
+		return err
+	}
+	for _, order := range []*engine.Order{taker, maker} {
+		o := m.orders[order.Id]
+		if o == nil {
+			continue
+		}
+		o.FilledAmount, o.RemainingAmount = order.FilledAmount.Persist(), order.RemainingAmount.Persist()
+		o.FilledFunds, o.RemainingFunds = order.FilledFunds.Persist(), order.RemainingFunds.Persist()
+		if order.RemainingAmount.IsZero() && order.RemainingFunds.IsZero() {
+			o.State = OrderStateDone
+			m.deleteActions(order.Id)
+		}
+	}
+	for _, t := range trades {
+		trade := *t
+		m.trades = append(m.trades, &trade)
+	}
+	for _, t := range transfers {
+		m.createTransfer(t)
+	}
+	for _, f := range fees {
+		entry := *f
+		m.ledger = append(m.ledger, &entry)
+	}
+	return nil
+}
+
+func (m *memoryStore) LastTrade(ctx context.Context, base, quote string) (*Trade, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var last *Trade
+	for _, t := range m.trades {
+		if t.BaseAssetId != base || t.QuoteAssetId != quote {
+			continue
+		}
+		if last == nil || !t.CreatedAt.Before(last.CreatedAt) {
+			last = t
+		}
+	}
+	if last == nil {
+		return nil, nil
+	}
+	trade := *last
+	return &trade, nil
+}
+
+func (m *memoryStore) MarketTrades(ctx context.Context, base, quote string, offset time.Time, order string, limit int) ([]*Trade, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var trades []*Trade
+	for _, t := range m.trades {
+		if t.BaseAssetId != base || t.QuoteAssetId != quote || t.Liquidity != TradeLiquidityMaker {
+			continue
+		}
+		if order == "DESC" && !t.CreatedAt.Before(offset) || order != "DESC" && !t.CreatedAt.After(offset) {
+			continue
+		}
+		trade := *t
+		trades = append(trades, &trade)
+	}
+	sort.SliceStable(trades, func(i, j int) bool {
+		if order == "DESC" {
+			return trades[i].CreatedAt.After(trades[j].CreatedAt)
+		}
+		return trades[i].CreatedAt.Before(trades[j].CreatedAt)
+	})
+	if len(trades) > limit {
+		trades = trades[:limit]
+	}
+	return trades, nil
+}
+
+func (m *memoryStore) UserTradeVolume(ctx context.Context, userId, base, quote string, since time.Time) (number.Decimal, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	volume := number.Zero()
+	for _, t := range m.trades {
+		if t.UserId != userId || t.BaseAssetId != base || t.QuoteAssetId != quote || t.CreatedAt.Before(since) {
+			continue
+		}
+		volume = volume.Add(number.FromString(t.Price).Mul(number.FromString(t.Amount)))
+	}
+	return volume, nil
+}
+
+func (m *memoryStore) CountPendingTransfers(ctx context.Context) (int64, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return int64(len(m.transfers)), nil
+}
+
+func (m *memoryStore) ListPendingTransfers(ctx context.Context, broker string, limit int) ([]*Transfer, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	transfers := make([]*Transfer, 0)
+	for _, t := range m.transfers {
+		if t.BrokerId != broker {
+			continue
+		}
+		transfer := *t
+		transfers = append(transfers, &transfer)
+	}
+	sort.SliceStable(transfers, func(i, j int) bool { return transfers[i].CreatedAt.Before(transfers[j].CreatedAt) })
+	if len(transfers) > limit {
+		transfers = transfers[:limit]
+	}
+	return transfers, nil
+}
+
+func (m *memoryStore) ExpireTransfers(ctx context.Context, transfers []*Transfer) error {
+	m.Lock()
+	defer m.Unlock()
+
+	expired := make(map[string]bool)
+	for _, t := range transfers {
+		expired[t.TransferId] = true
+	}
+	pending := make([]*Transfer, 0)
+	for _, t := range m.transfers {
+		if !expired[t.TransferId] {
+			pending = append(pending, t)
+		}
+	}
+	m.transfers = pending
+	return nil
+}
+
+func (m *memoryStore) ReadTransferTrade(ctx context.Context, tradeId, assetId string) (*Trade, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, t := range m.trades {
+		if t.TradeId == tradeId && t.FeeAssetId == assetId {
+			trade := *t
+			return &trade, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryStore) CreateTransfer(ctx context.Context, transfer *Transfer) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if err := m.checkTransfers(transfer); err != nil {
+		return err
+	}
+	m.createTransfer(transfer)
+	return nil
+}
+
+func (m *memoryStore) ReadBrokers(ctx context.Context) ([]*Broker, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	brokers := make([]*Broker, len(m.brokers))
+	for i, b := range m.brokers {
+		broker := *b
+		brokers[i] = &broker
+	}
+	return brokers, nil
+}
+
+func (m *memoryStore) CreateBroker(ctx context.Context, broker *Broker) error {
+	m.Lock()
+	defer m.Unlock()
+
+	b := *broker
+	m.brokers = append(m.brokers, &b)
+	return nil
+}
+
+func (m *memoryStore) ReadMarkets(ctx context.Context) ([]*Market, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	markets := make([]*Market, 0)
+	for _, mk := range m.markets {
+		market := *mk
+		markets = append(markets, &market)
+	}
+	sort.Slice(markets, func(i, j int) bool { return markets[i].Id() < markets[j].Id() })
+	return markets, nil
+}
+
+func (m *memoryStore) WriteMarket(ctx context.Context, market *Market, audit *MarketAudit) error {
+	m.Lock()
+	defer m.Unlock()
+
+	mk := *market
+	m.markets[mk.Id()] = &mk
+	if audit != nil {
+		a := *audit
+		m.audits = append(m.audits, &a)
+	}
+	return nil
+}
+
+func (m *memoryStore) ReadMarketAudits(ctx context.Context, base, quote string, limit int) ([]*MarketAudit, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	audits := make([]*MarketAudit, 0)
+	for i := len(m.audits) - 1; i >= 0 && len(audits) < limit; i-- {
+		if a := m.audits[i]; a.BaseAssetId == base && a.QuoteAssetId == quote {
+			audit := *a
+			audits = append(audits, &audit)
+		}
+	}
+	return audits, nil
+}
+
+func (m *memoryStore) ReadFeeTiers(ctx context.Context) ([]*FeeTier, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	tiers := make([]*FeeTier, 0)
+	for _, t := range m.fees {
+		tier := *t
+		tiers = append(tiers, &tier)
+	}
+	return tiers, nil
+}
+
+func (m *memoryStore) WriteFeeTier(ctx context.Context, tier *FeeTier) error {
+	m.Lock()
+	defer m.Unlock()
+
+	t := *tier
+	m.fees[t.Market+t.BrokerId+t.MinimumVolume] = &t
+	return nil
+}
+
+func (m *memoryStore) DeleteFeeTier(ctx context.Context, market, brokerId, minimumVolume string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.fees, market+brokerId+minimumVolume)
+	return nil
+}
+
+func (m *memoryStore) ReadBrokerFeeShares(ctx context.Context) ([]*BrokerFeeShare, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	shares := make([]*BrokerFeeShare, 0)
+	for _, s := range m.shares {
+		share := *s
+		shares = append(shares, &share)
+	}
+	return shares, nil
+}
+
+func (m *memoryStore) WriteBrokerFeeShare(ctx context.Context, share *BrokerFeeShare) error {
+	m.Lock()
+	defer m.Unlock()
+
+	s := *share
+	m.shares[s.BrokerId] = &s
+	return nil
+}
+
+func (m *memoryStore) ListUnpaidFees(ctx context.Context, beneficiaryId string, limit int) ([]*FeeEntry, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	entries := make([]*FeeEntry, 0)
+	for _, e := range m.ledger {
+		if e.PayoutId == "" && e.BeneficiaryId == beneficiaryId && len(entries) < limit {
+			entry := *e
+			entries = append(entries, &entry)
+		}
+	}
+	return entries, nil
+}
+
+func (m *memoryStore) CreateFeePayout(ctx context.Context, payout *FeePayout, entries []*FeeEntry, transfer *Transfer, carry *FeeEntry) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if transfer != nil {
+		if err := m.checkTransfers(transfer); err != nil {
+			return err
+		}
+	}
+	var paid []*FeeEntry
+	for _, e := range entries {
+		for _, l := range m.ledger {
+			if l.TradeId == e.TradeId && l.Liquidity == e.Liquidity && l.BeneficiaryId == e.BeneficiaryId && l.PayoutId == "" {
+				paid = append(paid, l)
+			}
+		}
+	}
+	if len(paid) != len(entries) {
+		return fmt.Errorf("fee entries already paid for payout %s", payout.PayoutId)
+	}
+	for _, l := range paid {
+		l.PayoutId = payout.PayoutId
+	}
+	p := *payout
+	m.payouts = append(m.payouts, &p)
+	if carry != nil {
+		entry := *carry
+		m.ledger = append(m.ledger, &entry)
+	}
+	if transfer != nil {
+		m.createTransfer(transfer)
+	}
+	return nil
+}
+
+func (m *memoryStore) ReadFeePayouts(ctx context.Context, beneficiaryId string) ([]*FeePayout, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	payouts := make([]*FeePayout, 0)
+	for _, p := range m.payouts {
+		if p.BeneficiaryId == beneficiaryId {
+			payout := *p
+			payouts = append(payouts, &payout)
+		}
+	}
+	return payouts, nil
+}
+
+func (m *memoryStore) ReadProperty(ctx context.Context, key string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.properties[key], nil
+}
+
+func (m *memoryStore) WriteProperty(ctx context.Context, key, value string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.properties[key] = value
+	return nil
+}
+
+func (m *memoryStore) ReadUserPublicKey(ctx context.Context, userId string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.users[userId], nil
+}
+
+func (m *memoryStore) UpdateUserPublicKey(ctx context.Context, userId, publicKey string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, key := range m.users {
+		if key == publicKey {
+			return nil
+		}
+	}
+	m.users[userId] = publicKey
+	return nil
+}
+
+func (m *memoryStore) WriteBookSnapshots(ctx context.Context, snapshots []*BookSnapshot) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, s := range snapshots {
+		snapshot := *s
+		m.snapshots[s.Market] = &snapshot
+	}
+	return nil
+}
+
+func (m *memoryStore) ReadBookSnapshots(ctx context.Context) ([]*BookSnapshot, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	snapshots := make([]*BookSnapshot, 0)
+	for _, s := range m.snapshots {
+		snapshot := *s
+		snapshots = append(snapshots, &snapshot)
+	}
+	return snapshots, nil
+}
+
+func (m *memoryStore) checkTransfers(transfers ...*Transfer) error {
+	for _, t := range transfers {
+		for _, p := range m.transfers {
+			if p.TransferId == t.TransferId {
+				return fmt.Errorf("transfer %s already exists", t.TransferId)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) createTransfer(transfer *Transfer) {
+	t := *transfer
+	m.transfers = append(m.transfers, &t)
+}
+
+func (m *memoryStore) deleteActions(orderId string) {
+	for _, action := range []string{engine.OrderActionCreate, engine.OrderActionCancel, engine.OrderActionAmend} {
+		delete(m.actions, orderId+action)
+	}
+}
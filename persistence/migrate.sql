@@ -0,0 +1,29 @@
+-- Migrations for the Spanner databases created with an earlier schema.sql, the new
+-- columns are added nullable, backfilled with partitioned DML, then made NOT NULL. Run
+-- the sections added after the database was created, in order.
+
+-- stop orders, time in force, expiry and iceberg orders
+ALTER TABLE orders ADD COLUMN trigger_price STRING(128);
+ALTER TABLE orders ADD COLUMN time_in_force STRING(36);
+ALTER TABLE orders ADD COLUMN expired_at TIMESTAMP;
+ALTER TABLE orders ADD COLUMN display_amount STRING(128);
+UPDATE orders SET trigger_price = '0' WHERE trigger_price IS NULL;
+UPDATE orders SET time_in_force = 'GTC' WHERE time_in_force IS NULL AND order_type = 'LIMIT';
+UPDATE orders SET time_in_force = 'IOC' WHERE time_in_force IS NULL;
+UPDATE orders SET expired_at = TIMESTAMP '0001-01-01T00:00:00Z' WHERE expired_at IS NULL;
+UPDATE orders SET display_amount = '0' WHERE display_amount IS NULL;
+ALTER TABLE orders ALTER COLUMN trigger_price STRING(128) NOT NULL;
+ALTER TABLE orders ALTER COLUMN time_in_force STRING(36) NOT NULL;
+ALTER TABLE orders ALTER COLUMN expired_at TIMESTAMP NOT NULL;
+ALTER TABLE orders ALTER COLUMN display_amount STRING(128) NOT NULL;
+
+-- refund reasons
+ALTER TABLE transfers ADD COLUMN reason STRING(36);
+UPDATE transfers SET reason = '' WHERE reason IS NULL;
+ALTER TABLE transfers ALTER COLUMN reason STRING(36) NOT NULL;
+
+-- fee tiers, trades keep the rate they were charged, taker 0.001 and maker 0 before
+ALTER TABLE trades ADD COLUMN fee_rate STRING(128);
+UPDATE trades SET fee_rate = '0.001' WHERE fee_rate IS NULL AND liquidity = 'TAKER';
+UPDATE trades SET fee_rate = '0' WHERE fee_rate IS NULL;
+ALTER TABLE trades ALTER COLUMN fee_rate STRING(128) NOT NULL;
@@ -0,0 +1,17 @@
+-- Migrations for the SQL databases created with an earlier schema_sql.sql, works with
+-- both PostgreSQL and SQLite, the new columns are backfilled by their defaults. Run the
+-- sections added after the database was created, in order.
+
+-- stop orders, time in force, expiry and iceberg orders
+ALTER TABLE orders ADD COLUMN trigger_price VARCHAR(128) NOT NULL DEFAULT '0';
+ALTER TABLE orders ADD COLUMN time_in_force VARCHAR(36) NOT NULL DEFAULT 'GTC';
+ALTER TABLE orders ADD COLUMN expired_at TIMESTAMP NOT NULL DEFAULT '0001-01-01 00:00:00';
+ALTER TABLE orders ADD COLUMN display_amount VARCHAR(128) NOT NULL DEFAULT '0';
+UPDATE orders SET time_in_force = 'IOC' WHERE order_type <> 'LIMIT';
+
+-- refund reasons
+ALTER TABLE transfers ADD COLUMN reason VARCHAR(36) NOT NULL DEFAULT '';
+
+-- fee tiers, trades keep the rate they were charged, taker 0.001 and maker 0 before
+ALTER TABLE trades ADD COLUMN fee_rate VARCHAR(128) NOT NULL DEFAULT '0';
+UPDATE trades SET fee_rate = '0.001' WHERE liquidity = 'TAKER';
@@ -3,9 +3,6 @@ package persistence
 import (
 	"context"
 	"time"
-
-	"cloud.google.com/go/spanner"
-	"google.golang.org/api/iterator"
 )
 
 type Property struct {
@@ -15,26 +12,11 @@ type Property struct {
 }
 
 func ReadProperty(ctx context.Context, key string) (string, error) {
-	it := Spanner(ctx).Single().Read(ctx, "properties", spanner.Key{key}, []string{"value"})
-	defer it.Stop()
-
-	row, err := it.Next()
-	if err == iterator.Done {
-		return "", nil
-	} else if err != nil {
-		return "", err
-	}
-
-	var value string
-	err = row.Column(0, &value)
-	return value, err
+	return CurrentStore(ctx).ReadProperty(ctx, key)
 }
 
 func WriteProperty(ctx context.Context, key, value string) error {
-	_, err := Spanner(ctx).Apply(ctx, []*spanner.Mutation{
-		spanner.InsertOrUpdate("properties", []string{"key", "value", "updated_at"}, []interface{}{key, value, time.Now()}),
-	})
-	return err
+	return CurrentStore(ctx).WriteProperty(ctx, key, value)
 }
 
 func ReadPropertyAsTime(ctx context.Context, key string) (time.Time, error) {
@@ -16,6 +16,34 @@ CREATE TABLE brokers (
 ) PRIMARY KEY(broker_id);
 
 
+CREATE TABLE markets (
+  base_asset_id     STRING(36) NOT NULL,
+  quote_asset_id    STRING(36) NOT NULL,
+  price_precision   INT64 NOT NULL,
+  amount_precision  INT64 NOT NULL,
+  minimum_funds     STRING(128) NOT NULL,
+  tick_size         STRING(128) NOT NULL,
+  lot_size          STRING(128) NOT NULL,
+  status            STRING(36) NOT NULL,
+  created_at        TIMESTAMP NOT NULL,
+  updated_at        TIMESTAMP NOT NULL,
+) PRIMARY KEY(base_asset_id, quote_asset_id);
+
+
+CREATE TABLE market_audits (
+  audit_id          STRING(36) NOT NULL,
+  base_asset_id     STRING(36) NOT NULL,
+  quote_asset_id    STRING(36) NOT NULL,
+  previous_status   STRING(36) NOT NULL,
+  status            STRING(36) NOT NULL,
+  operator          STRING(256) NOT NULL,
+  reason            STRING(1024) NOT NULL,
+  created_at        TIMESTAMP NOT NULL,
+) PRIMARY KEY(audit_id);
+
+CREATE INDEX market_audits_by_base_quote_created ON market_audits(base_asset_id, quote_asset_id, created_at DESC);
+
+
 CREATE TABLE orders (
   order_id          STRING(36) NOT NULL,
   order_type        STRING(36) NOT NULL,
@@ -27,6 +55,10 @@ CREATE TABLE orders (
   filled_amount     STRING(128) NOT NULL,
   remaining_funds   STRING(128) NOT NULL,
   filled_funds      STRING(128) NOT NULL,
+  trigger_price     STRING(128) NOT NULL,
+  time_in_force     STRING(36) NOT NULL,
+  expired_at        TIMESTAMP NOT NULL,
+  display_amount    STRING(128) NOT NULL,
   created_at        TIMESTAMP NOT NULL,
   state             STRING(36) NOT NULL,
   user_id           STRING(36) NOT NULL,
@@ -40,6 +72,8 @@ CREATE INDEX orders_by_user_state_created_asc ON orders(user_id, state, created_
 CREATE TABLE actions (
   order_id     STRING(36) NOT NULL,
   action       STRING(36) NOT NULL,
+  price        STRING(128) NOT NULL,
+  amount       STRING(128) NOT NULL,
   created_at   TIMESTAMP NOT NULL,
 ) PRIMARY KEY(order_id, action),
 INTERLEAVE IN PARENT orders ON DELETE CASCADE;
@@ -47,6 +81,14 @@ INTERLEAVE IN PARENT orders ON DELETE CASCADE;
 CREATE INDEX actions_by_created ON actions(created_at);
 
 
+CREATE TABLE snapshots (
+  market       STRING(73) NOT NULL,
+  checkpoint   TIMESTAMP NOT NULL,
+  data         BYTES(MAX) NOT NULL,
+  created_at   TIMESTAMP NOT NULL,
+) PRIMARY KEY(market);
+
+
 CREATE TABLE trades (
   trade_id          STRING(36) NOT NULL,
   liquidity         STRING(36) NOT NULL,
@@ -61,10 +103,58 @@ CREATE TABLE trades (
   user_id           STRING(36) NOT NULL,
   fee_asset_id      STRING(36) NOT NULL,
   fee_amount        STRING(128) NOT NULL,
+  fee_rate          STRING(128) NOT NULL,
 ) PRIMARY KEY(trade_id, liquidity);
 
 CREATE INDEX trades_by_base_quote_created_desc ON trades(base_asset_id, quote_asset_id, created_at DESC);
 CREATE INDEX trades_by_base_quote_created_asc ON trades(base_asset_id, quote_asset_id, created_at ASC);
+CREATE INDEX trades_by_user_base_quote_created ON trades(user_id, base_asset_id, quote_asset_id, created_at) STORING (price, amount);
+
+
+CREATE TABLE fee_tiers (
+  market            STRING(73) NOT NULL,
+  broker_id         STRING(36) NOT NULL,
+  minimum_volume    STRING(128) NOT NULL,
+  maker_fee_rate    STRING(128) NOT NULL,
+  taker_fee_rate    STRING(128) NOT NULL,
+  updated_at        TIMESTAMP NOT NULL,
+) PRIMARY KEY(market, broker_id, minimum_volume);
+
+
+CREATE TABLE broker_fee_shares (
+  broker_id         STRING(36) NOT NULL,
+  share_rate        STRING(128) NOT NULL,
+  payout_user_id    STRING(36) NOT NULL,
+  updated_at        TIMESTAMP NOT NULL,
+) PRIMARY KEY(broker_id);
+
+
+CREATE TABLE fee_ledger (
+  trade_id          STRING(36) NOT NULL,
+  liquidity         STRING(36) NOT NULL,
+  beneficiary_id    STRING(36) NOT NULL,
+  broker_id         STRING(36) NOT NULL,
+  asset_id          STRING(36) NOT NULL,
+  amount            STRING(128) NOT NULL,
+  created_at        TIMESTAMP NOT NULL,
+  payout_id         STRING(36) NOT NULL,
+) PRIMARY KEY(trade_id, liquidity, beneficiary_id);
+
+CREATE INDEX fee_ledger_by_payout_beneficiary_created ON fee_ledger(payout_id, beneficiary_id, created_at) STORING (broker_id, asset_id, amount);
+
+
+CREATE TABLE fee_payouts (
+  payout_id         STRING(36) NOT NULL,
+  beneficiary_id    STRING(36) NOT NULL,
+  broker_id         STRING(36) NOT NULL,
+  asset_id          STRING(36) NOT NULL,
+  amount            STRING(128) NOT NULL,
+  entries           INT64 NOT NULL,
+  user_id           STRING(36) NOT NULL,
+  created_at        TIMESTAMP NOT NULL,
+) PRIMARY KEY(payout_id);
+
+CREATE INDEX fee_payouts_by_beneficiary_created ON fee_payouts(beneficiary_id, created_at);
 
 
 CREATE TABLE transfers (
@@ -76,6 +166,7 @@ CREATE TABLE transfers (
   created_at        TIMESTAMP NOT NULL,
   user_id           STRING(36) NOT NULL,
   broker_id         STRING(36) NOT NULL,
+  reason            STRING(36) NOT NULL,
 ) PRIMARY KEY(transfer_id);
 
 CREATE INDEX transfers_by_broker_created ON transfers(broker_id,created_at);
@@ -0,0 +1,193 @@
+-- Schema for the SQL store, works with both PostgreSQL and SQLite.
+
+CREATE TABLE properties (
+  key         VARCHAR(512) NOT NULL,
+  value       VARCHAR(8192) NOT NULL,
+  updated_at  TIMESTAMP NOT NULL,
+  PRIMARY KEY(key)
+);
+
+
+CREATE TABLE brokers (
+  broker_id         VARCHAR(36) NOT NULL,
+  session_id        VARCHAR(36) NOT NULL,
+  session_key       VARCHAR(1024) NOT NULL,
+  pin_token         VARCHAR(512) NOT NULL,
+  encrypted_pin     VARCHAR(512) NOT NULL,
+  encryption_header BYTEA NOT NULL,
+  created_at        TIMESTAMP NOT NULL,
+  PRIMARY KEY(broker_id)
+);
+
+
+CREATE TABLE markets (
+  base_asset_id     VARCHAR(36) NOT NULL,
+  quote_asset_id    VARCHAR(36) NOT NULL,
+  price_precision   BIGINT NOT NULL,
+  amount_precision  BIGINT NOT NULL,
+  minimum_funds     VARCHAR(128) NOT NULL,
+  tick_size         VARCHAR(128) NOT NULL,
+  lot_size          VARCHAR(128) NOT NULL,
+  status            VARCHAR(36) NOT NULL,
+  created_at        TIMESTAMP NOT NULL,
+  updated_at        TIMESTAMP NOT NULL,
+  PRIMARY KEY(base_asset_id, quote_asset_id)
+);
+
+
+CREATE TABLE market_audits (
+  audit_id          VARCHAR(36) NOT NULL,
+  base_asset_id     VARCHAR(36) NOT NULL,
+  quote_asset_id    VARCHAR(36) NOT NULL,
+  previous_status   VARCHAR(36) NOT NULL,
+  status            VARCHAR(36) NOT NULL,
+  operator          VARCHAR(256) NOT NULL,
+  reason            VARCHAR(1024) NOT NULL,
+  created_at        TIMESTAMP NOT NULL,
+  PRIMARY KEY(audit_id)
+);
+
+CREATE INDEX market_audits_by_base_quote_created ON market_audits(base_asset_id, quote_asset_id, created_at);
+
+
+CREATE TABLE orders (
+  order_id          VARCHAR(36) NOT NULL,
+  order_type        VARCHAR(36) NOT NULL,
+  quote_asset_id    VARCHAR(36) NOT NULL,
+  base_asset_id     VARCHAR(36) NOT NULL,
+  side              VARCHAR(36) NOT NULL,
+  price             VARCHAR(128) NOT NULL,
+  remaining_amount  VARCHAR(128) NOT NULL,
+  filled_amount     VARCHAR(128) NOT NULL,
+  remaining_funds   VARCHAR(128) NOT NULL,
+  filled_funds      VARCHAR(128) NOT NULL,
+  trigger_price     VARCHAR(128) NOT NULL,
+  time_in_force     VARCHAR(36) NOT NULL,
+  expired_at        TIMESTAMP NOT NULL,
+  display_amount    VARCHAR(128) NOT NULL,
+  created_at        TIMESTAMP NOT NULL,
+  state             VARCHAR(36) NOT NULL,
+  user_id           VARCHAR(36) NOT NULL,
+  broker_id         VARCHAR(36) NOT NULL,
+  PRIMARY KEY(order_id)
+);
+
+CREATE INDEX orders_by_user_state_created ON orders(user_id, state, created_at);
+
+
+CREATE TABLE actions (
+  order_id     VARCHAR(36) NOT NULL REFERENCES orders(order_id) ON DELETE CASCADE,
+  action       VARCHAR(36) NOT NULL,
+  price        VARCHAR(128) NOT NULL,
+  amount       VARCHAR(128) NOT NULL,
+  created_at   TIMESTAMP NOT NULL,
+  PRIMARY KEY(order_id, action)
+);
+
+CREATE INDEX actions_by_created ON actions(created_at);
+
+
+CREATE TABLE snapshots (
+  market       VARCHAR(73) NOT NULL,
+  checkpoint   TIMESTAMP NOT NULL,
+  data         BYTEA NOT NULL,
+  created_at   TIMESTAMP NOT NULL,
+  PRIMARY KEY(market)
+);
+
+
+CREATE TABLE trades (
+  trade_id          VARCHAR(36) NOT NULL,
+  liquidity         VARCHAR(36) NOT NULL,
+  ask_order_id      VARCHAR(36) NOT NULL,
+  bid_order_id      VARCHAR(36) NOT NULL,
+  quote_asset_id    VARCHAR(36) NOT NULL,
+  base_asset_id     VARCHAR(36) NOT NULL,
+  side              VARCHAR(36) NOT NULL,
+  price             VARCHAR(128) NOT NULL,
+  amount            VARCHAR(128) NOT NULL,
+  created_at        TIMESTAMP NOT NULL,
+  user_id           VARCHAR(36) NOT NULL,
+  fee_asset_id      VARCHAR(36) NOT NULL,
+  fee_amount        VARCHAR(128) NOT NULL,
+  fee_rate          VARCHAR(128) NOT NULL,
+  PRIMARY KEY(trade_id, liquidity)
+);
+
+CREATE INDEX trades_by_base_quote_created ON trades(base_asset_id, quote_asset_id, created_at);
+CREATE INDEX trades_by_user_base_quote_created ON trades(user_id, base_asset_id, quote_asset_id, created_at);
+
+
+CREATE TABLE fee_tiers (
+  market            VARCHAR(73) NOT NULL,
+  broker_id         VARCHAR(36) NOT NULL,
+  minimum_volume    VARCHAR(128) NOT NULL,
+  maker_fee_rate    VARCHAR(128) NOT NULL,
+  taker_fee_rate    VARCHAR(128) NOT NULL,
+  updated_at        TIMESTAMP NOT NULL,
+  PRIMARY KEY(market, broker_id, minimum_volume)
+);
+
+
+CREATE TABLE broker_fee_shares (
+  broker_id         VARCHAR(36) NOT NULL,
+  share_rate        VARCHAR(128) NOT NULL,
+  payout_user_id    VARCHAR(36) NOT NULL,
+  updated_at        TIMESTAMP NOT NULL,
+  PRIMARY KEY(broker_id)
+);
+
+
+CREATE TABLE fee_ledger (
+  trade_id          VARCHAR(36) NOT NULL,
+  liquidity         VARCHAR(36) NOT NULL,
+  beneficiary_id    VARCHAR(36) NOT NULL,
+  broker_id         VARCHAR(36) NOT NULL,
+  asset_id          VARCHAR(36) NOT NULL,
+  amount            VARCHAR(128) NOT NULL,
+  created_at        TIMESTAMP NOT NULL,
+  payout_id         VARCHAR(36) NOT NULL,
+  PRIMARY KEY(trade_id, liquidity, beneficiary_id)
+);
+
+CREATE INDEX fee_ledger_by_payout_beneficiary_created ON fee_ledger(payout_id, beneficiary_id, created_at);
+
+
+CREATE TABLE fee_payouts (
+  payout_id         VARCHAR(36) NOT NULL,
+  beneficiary_id    VARCHAR(36) NOT NULL,
+  broker_id         VARCHAR(36) NOT NULL,
+  asset_id          VARCHAR(36) NOT NULL,
+  amount            VARCHAR(128) NOT NULL,
+  entries           BIGINT NOT NULL,
+  user_id           VARCHAR(36) NOT NULL,
+  created_at        TIMESTAMP NOT NULL,
+  PRIMARY KEY(payout_id)
+);
+
+CREATE INDEX fee_payouts_by_beneficiary_created ON fee_payouts(beneficiary_id, created_at);
+
+
+CREATE TABLE transfers (
+  transfer_id       VARCHAR(36) NOT NULL,
+  source            VARCHAR(36) NOT NULL,
+  detail            VARCHAR(36) NOT NULL,
+  asset_id          VARCHAR(36) NOT NULL,
+  amount            VARCHAR(128) NOT NULL,
+  created_at        TIMESTAMP NOT NULL,
+  user_id           VARCHAR(36) NOT NULL,
+  broker_id         VARCHAR(36) NOT NULL,
+  reason            VARCHAR(36) NOT NULL,
+  PRIMARY KEY(transfer_id)
+);
+
+CREATE INDEX transfers_by_broker_created ON transfers(broker_id, created_at);
+
+
+CREATE TABLE users (
+  user_id          VARCHAR(36) NOT NULL,
+  public_key       VARCHAR(512) NOT NULL,
+  PRIMARY KEY(user_id)
+);
+
+CREATE UNIQUE INDEX users_by_public_key ON users(public_key);
@@ -0,0 +1,174 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/MixinNetwork/ocean.one/engine"
+)
+
+type BookSnapshot struct {
+	Market     string    `spanner:"market"`
+	Checkpoint time.Time `spanner:"checkpoint"`
+	Data       []byte    `spanner:"data"`
+	CreatedAt  time.Time `spanner:"created_at"`
+}
+
+type BookState struct {
+	Asks        []*BookOrder  `json:"asks"`
+	Bids        []*BookOrder  `json:"bids"`
+	AskStops    []*Order      `json:"ask_stops"`
+	BidStops    []*Order      `json:"bid_stops"`
+	CreateIndex []string      `json:"create_index"`
+	CancelIndex []string      `json:"cancel_index"`
+	LastPrice   string        `json:"last_price"`
+	LastTrade   *BookTrade    `json:"last_trade"`
+	Buckets     []*BookBucket `json:"buckets"`
+	Halted      []*BookAction `json:"halted"`
+}
+
+type BookOrder struct {
+	*Order
+	Displayed string `json:"displayed,omitempty"`
+}
+
+type BookTrade struct {
+	TradeId   string    `json:"trade_id"`
+	Price     string    `json:"price"`
+	Amount    string    `json:"amount"`
+	Funds     string    `json:"funds"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type BookBucket struct {
+	StartedAt time.Time `json:"started_at"`
+	Open      string    `json:"open"`
+	High      string    `json:"high"`
+	Low       string    `json:"low"`
+	Volume    string    `json:"volume"`
+	Funds     string    `json:"funds"`
+}
+
+type BookAction struct {
+	Action string `json:"action"`
+	Order  *Order `json:"order"`
+}
+
+func WriteBookSnapshots(ctx context.Context, checkpoint time.Time, snapshots map[string]*engine.Snapshot) error {
+	books := make([]*BookSnapshot, 0)
+	for market, s := range snapshots {
+		state := BookState{
+			Asks:        snapshotBookOrders(s.Asks),
+			Bids:        snapshotBookOrders(s.Bids),
+			AskStops:    snapshotOrders(s.AskStops),
+			BidStops:    snapshotOrders(s.BidStops),
+			CreateIndex: s.CreateIndex,
+			CancelIndex: s.CancelIndex,
+			LastPrice:   s.LastPrice.Persist(),
+			Buckets:     snapshotBuckets(s.Buckets),
+			Halted:      snapshotActions(s.Halted),
+		}
+		if s.LastTrade != nil {
+			state.LastTrade = snapshotTrades([]*engine.TickerTrade{s.LastTrade})[0]
+		}
+		data, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		books = append(books, &BookSnapshot{
+			Market:     market,
+			Checkpoint: checkpoint,
+			Data:       data,
+			CreatedAt:  time.Now(),
+		})
+	}
+	return CurrentStore(ctx).WriteBookSnapshots(ctx, books)
+}
+
+func ReadBookSnapshots(ctx context.Context) ([]*BookSnapshot, error) {
+	return CurrentStore(ctx).ReadBookSnapshots(ctx)
+}
+
+func (s *BookSnapshot) State() (*BookState, error) {
+	var state BookState
+	err := json.Unmarshal(s.Data, &state)
+	return &state, err
+}
+
+func snapshotTrades(trades []*engine.TickerTrade) []*BookTrade {
+	snapshots := make([]*BookTrade, len(trades))
+	for i, t := range trades {
+		snapshots[i] = &BookTrade{
+			TradeId:   t.TradeId,
+			Price:     t.Price.Persist(),
+			Amount:    t.Amount.Persist(),
+			Funds:     t.Funds.Persist(),
+			CreatedAt: t.CreatedAt,
+		}
+	}
+	return snapshots
+}
+
+func snapshotBuckets(buckets []*engine.TickerBucket) []*BookBucket {
+	snapshots := make([]*BookBucket, len(buckets))
+	for i, b := range buckets {
+		snapshots[i] = &BookBucket{
+			StartedAt: b.StartedAt,
+			Open:      b.Open.Persist(),
+			High:      b.High.Persist(),
+			Low:       b.Low.Persist(),
+			Volume:    b.Volume.Persist(),
+			Funds:     b.Funds.Persist(),
+		}
+	}
+	return snapshots
+}
+
+func snapshotActions(events []*engine.OrderEvent) []*BookAction {
+	actions := make([]*BookAction, len(events))
+	for i, e := range events {
+		actions[i] = &BookAction{Action: e.Action, Order: snapshotOrders([]*engine.Order{e.Order})[0]}
+	}
+	return actions
+}
+
+func snapshotBookOrders(orders []*engine.Order) []*BookOrder {
+	snapshots := make([]*BookOrder, len(orders))
+	for i, o := range snapshotOrders(orders) {
+		snapshots[i] = &BookOrder{Order: o}
+		if !orders[i].Displayed.IsZero() {
+			snapshots[i].Displayed = orders[i].Displayed.Persist()
+		}
+	}
+	return snapshots
+}
+
+func snapshotOrders(orders []*engine.Order) []*Order {
+	snapshots := make([]*Order, len(orders))
+	for i, o := range orders {
+		snapshots[i] = &Order{
+			OrderId:         o.Id,
+			OrderType:       o.Type,
+			Side:            o.Side,
+			QuoteAssetId:    o.Quote,
+			BaseAssetId:     o.Base,
+			Price:           o.Price.Persist(),
+			RemainingAmount: o.RemainingAmount.Persist(),
+			FilledAmount:    o.FilledAmount.Persist(),
+			RemainingFunds:  o.RemainingFunds.Persist(),
+			FilledFunds:     o.FilledFunds.Persist(),
+			TriggerPrice:    o.TriggerPrice.Persist(),
+			TimeInForce:     o.TimeInForce,
+			ExpiredAt:       o.ExpiredAt,
+			DisplayAmount:   o.DisplayAmount.Persist(),
+			State:           OrderStatePending,
+			UserId:          o.UserId,
+			BrokerId:        o.BrokerId,
+		}
+		if o.Type == engine.OrderTypeStopLimit || o.Type == engine.OrderTypeStopMarket {
+			snapshots[i].State = OrderStateUntriggered
+		}
+	}
+	return snapshots
+}
@@ -0,0 +1,952 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/engine"
+	"google.golang.org/api/iterator"
+)
+
+type spannerStore struct {
+	client *spanner.Client
+}
+
+func NewSpannerStore(client *spanner.Client) Store {
+	return &spannerStore{client: client}
+}
+
+func (s *spannerStore) CountPendingActions(ctx context.Context) (int64, error) {
+	return s.count(ctx, "SELECT COUNT(*) FROM actions")
+}
+
+func (s *spannerStore) ListPendingActions(ctx context.Context, checkpoint time.Time, limit int) ([]*Action, error) {
+	txn := s.client.ReadOnlyTransaction()
+	defer txn.Close()
+
+	it := txn.Query(ctx, spanner.Statement{
+		SQL:    fmt.Sprintf("SELECT * FROM actions@{FORCE_INDEX=actions_by_created} WHERE created_at>=@checkpoint ORDER BY created_at LIMIT %d", limit),
+		Params: map[string]interface{}{"checkpoint": checkpoint},
+	})
+	defer it.Stop()
+
+	orderFilters := make(map[string]bool)
+	actions, orderIds := make([]*Action, 0), make([]string, 0)
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return actions, err
+		}
+		var action Action
+		err = row.ToStruct(&action)
+		if err != nil {
+			return actions, err
+		}
+		actions = append(actions, &action)
+		if orderFilters[action.OrderId] {
+			continue
+		}
+		orderFilters[action.OrderId] = true
+		orderIds = append(orderIds, action.OrderId)
+	}
+
+	oit := txn.Query(ctx, spanner.Statement{
+		SQL:    "SELECT * FROM orders WHERE order_id IN UNNEST(@order_ids)",
+		Params: map[string]interface{}{"order_ids": orderIds},
+	})
+	defer oit.Stop()
+
+	orders := make(map[string]*Order)
+	for {
+		row, err := oit.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return actions, err
+		}
+		var order Order
+		err = row.ToStruct(&order)
+		if err != nil {
+			return actions, err
+		}
+		orders[order.OrderId] = &order
+	}
+
+	for _, a := range actions {
+		a.Order = orders[a.OrderId]
+	}
+	return actions, nil
+}
+
+func (s *spannerStore) CreateOrderAction(ctx context.Context, order *Order, action *Action) error {
+	_, err := s.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		state, err := s.checkOrderState(ctx, txn, action.OrderId)
+		if err != nil || state != nil {
+			return err
+		}
+		orderMutation, err := spanner.InsertStruct("orders", order)
+		if err != nil {
+			return err
+		}
+		actionMutation, err := spanner.InsertStruct("actions", action)
+		if err != nil {
+			return err
+		}
+		return txn.BufferWrite([]*spanner.Mutation{orderMutation, actionMutation})
+	})
+	return err
+}
+
+func (s *spannerStore) CancelOrderAction(ctx context.Context, action *Action, userId string) error {
+	_, err := s.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		exist, err := s.checkActionExistence(ctx, txn, action.OrderId, action.Action)
+		if err != nil || exist {
+			return err
+		}
+		state, err := s.checkOrderState(ctx, txn, action.OrderId)
+		if err != nil || state == nil {
+			return err
+		}
+		if state.UserId != userId || !cancellable(state) {
+			return nil
+		}
+		actionMutation, err := spanner.InsertStruct("actions", action)
+		if err != nil {
+			return err
+		}
+		return txn.BufferWrite([]*spanner.Mutation{actionMutation})
+	})
+	return err
+}
+
+func (s *spannerStore) AmendOrderAction(ctx context.Context, action *Action, userId string) error {
+	_, err := s.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		exist, err := s.checkActionExistence(ctx, txn, action.OrderId, engine.OrderActionCancel)
+		if err != nil || exist {
+			return err
+		}
+		state, err := s.checkOrderState(ctx, txn, action.OrderId)
+		if err != nil || state == nil {
+			return err
+		}
+		if state.UserId != userId || !amendable(state) {
+			return nil
+		}
+		actionMutation, err := spanner.InsertOrUpdateStruct("actions", action)
+		if err != nil {
+			return err
+		}
+		return txn.BufferWrite([]*spanner.Mutation{actionMutation})
+	})
+	return err
+}
+
+func (s *spannerStore) ReadOrder(ctx context.Context, orderId string) (*Order, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{
+		SQL:    "SELECT * FROM orders WHERE order_id=@order_id",
+		Params: map[string]interface{}{"order_id": orderId},
+	})
+	defer it.Stop()
+
+	row, err := it.Next()
+	if err == iterator.Done {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var o Order
+	err = row.ToStruct(&o)
+	return &o, err
+}
+
+func (s *spannerStore) UserOrders(ctx context.Context, userId, base, quote, state string, offset time.Time, order string, limit int) ([]*Order, error) {
+	txn := s.client.ReadOnlyTransaction()
+	defer txn.Close()
+
+	cmp := "<"
+	if order != "DESC" {
+		cmp = ">"
+	}
+
+	query := "SELECT order_id FROM orders@{FORCE_INDEX=orders_by_user_state_created_%s} WHERE user_id=@user_id AND created_at%s=@offset AND state=@state"
+	query = fmt.Sprintf(query, strings.ToLower(order), cmp)
+	params := map[string]interface{}{"user_id": userId, "offset": offset, "state": state}
+	if base != "" && quote != "" {
+		query = query + " AND base_asset_id=@base AND quote_asset_id=@quote"
+		params["base"], params["quote"] = base, quote
+	}
+	query = query + " ORDER BY user_id,state,created_at " + order
+	query = fmt.Sprintf("%s LIMIT %d", query, limit)
+
+	iit := txn.Query(ctx, spanner.Statement{query, params})
+	defer iit.Stop()
+
+	var orderIds []string
+	for {
+		row, err := iit.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		var id string
+		err = row.Columns(&id)
+		if err != nil {
+			return nil, err
+		}
+		orderIds = append(orderIds, id)
+	}
+
+	oit := txn.Query(ctx, spanner.Statement{
+		SQL:    "SELECT * FROM orders WHERE order_id IN UNNEST(@order_ids)",
+		Params: map[string]interface{}{"order_ids": orderIds},
+	})
+	defer oit.Stop()
+
+	var orders []*Order
+	for {
+		row, err := oit.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return orders, err
+		}
+		var o Order
+		err = row.ToStruct(&o)
+		if err != nil {
+			return orders, err
+		}
+		orders = append(orders, &o)
+	}
+	if order == "DESC" {
+		sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.After(orders[j].CreatedAt) })
+	} else {
+		sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.Before(orders[j].CreatedAt) })
+	}
+	return orders, nil
+}
+
+func (s *spannerStore) CancelOrder(ctx context.Context, order *engine.Order, transfer *Transfer) error {
+	orderCols := []string{"order_id", "filled_amount", "remaining_amount", "filled_funds", "remaining_funds", "state"}
+	orderVals := []interface{}{order.Id, order.FilledAmount.Persist(), order.RemainingAmount.Persist(), order.FilledFunds.Persist(), order.RemainingFunds.Persist(), OrderStateDone}
+	mutations := []*spanner.Mutation{
+		spanner.Update("orders", orderCols, orderVals),
+		spanner.Delete("actions", spanner.Key{order.Id, engine.OrderActionCreate}),
+		spanner.Delete("actions", spanner.Key{order.Id, engine.OrderActionCancel}),
+		spanner.Delete("actions", spanner.Key{order.Id, engine.OrderActionAmend}),
+	}
+	transferMutation, err := spanner.InsertStruct("transfers", transfer)
+	if err != nil {
+		return err
+	}
+	mutations = append(mutations, transferMutation)
+	_, err = s.client.Apply(ctx, mutations)
+	return err
+}
+
+func (s *spannerStore) DecrementOrder(ctx context.Context, order *engine.Order, transfer *Transfer) error {
+	orderCols := []string{"order_id", "remaining_amount", "remaining_funds"}
+	orderVals := []interface{}{order.Id, order.RemainingAmount.Persist(), order.RemainingFunds.Persist()}
+	transferMutation, err := spanner.InsertStruct("transfers", transfer)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Apply(ctx, []*spanner.Mutation{
+		spanner.Update("orders", orderCols, orderVals),
+		transferMutation,
+	})
+	return err
+}
+
+func (s *spannerStore) AmendOrder(ctx context.Context, order *engine.Order, transfer *Transfer) error {
+	orderCols := []string{"order_id", "price", "remaining_amount", "remaining_funds"}
+	orderVals := []interface{}{order.Id, order.Price.Persist(), order.RemainingAmount.Persist(), order.RemainingFunds.Persist()}
+	mutations := []*spanner.Mutation{spanner.Update("orders", orderCols, orderVals)}
+	if transfer != nil {
+		transferMutation, err := spanner.InsertStruct("transfers", transfer)
+		if err != nil {
+			return err
+		}
+		mutations = append(mutations, transferMutation)
+	}
+	_, err := s.client.Apply(ctx, mutations)
+	return err
+}
+
+func (s *spannerStore) TriggerOrder(ctx context.Context, orderId string) error {
+	_, err := s.client.Apply(ctx, []*spanner.Mutation{
+		spanner.Update("orders", []string{"order_id", "state"}, []interface{}{orderId, OrderStatePending}),
+	})
+	return err
+}
+
+func (s *spannerStore) Transact(ctx context.Context, taker, maker *engine.Order, trades []*Trade, transfers []*Transfer, fees []*FeeEntry) error {
+	mutations := makeOrderMutations(taker, maker)
+	for _, t := range trades {
+		mutation, err := spanner.InsertStruct("trades", t)
+		if err != nil {
+			return err
+		}
+		mutations = append(mutations, mutation)
+	}
+	for _, t := range transfers {
+		mutation, err := spanner.InsertStruct("transfers", t)
+		if err != nil {
+			return err
+		}
+		mutations = append(mutations, mutation)
+	}
+	for _, f := range fees {
+		mutation, err := spanner.InsertStruct("fee_ledger", f)
+		if err != nil {
+			return err
+		}
+		mutations = append(mutations, mutation)
+	}
+	_, err := s.client.Apply(ctx, mutations)
+	return err
+}
+
+func (s *spannerStore) LastTrade(ctx context.Context, base, quote string) (*Trade, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{
+		SQL:    "SELECT * FROM trades@{FORCE_INDEX=trades_by_base_quote_created_desc} WHERE base_asset_id=@base AND quote_asset_id=@quote ORDER BY base_asset_id,quote_asset_id,created_at DESC",
+		Params: map[string]interface{}{"base": base, "quote": quote},
+	})
+	defer it.Stop()
+
+	row, err := it.Next()
+	if err == iterator.Done {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var t Trade
+	err = row.ToStruct(&t)
+	return &t, err
+}
+
+func (s *spannerStore) MarketTrades(ctx context.Context, base, quote string, offset time.Time, order string, limit int) ([]*Trade, error) {
+	txn := s.client.ReadOnlyTransaction()
+	defer txn.Close()
+
+	cmp := "<"
+	if order != "DESC" {
+		cmp = ">"
+	}
+
+	query := "SELECT trade_id FROM trades@{FORCE_INDEX=trades_by_base_quote_created_%s} WHERE base_asset_id=@base AND quote_asset_id=@quote AND created_at%s=@offset AND liquidity=@liquidity"
+	query = fmt.Sprintf(query, strings.ToLower(order), cmp)
+	query = query + " ORDER BY base_asset_id,quote_asset_id,created_at " + order
+	query = fmt.Sprintf("%s LIMIT %d", query, limit)
+	params := map[string]interface{}{"base": base, "quote": quote, "offset": offset, "liquidity": TradeLiquidityMaker}
+
+	iit := txn.Query(ctx, spanner.Statement{query, params})
+	defer iit.Stop()
+
+	var tradeIds []string
+	for {
+		row, err := iit.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		var id string
+		err = row.Columns(&id)
+		if err != nil {
+			return nil, err
+		}
+		tradeIds = append(tradeIds, id)
+	}
+
+	tit := txn.Query(ctx, spanner.Statement{
+		SQL:    "SELECT * FROM trades WHERE trade_id IN UNNEST(@trade_ids) AND liquidity=@liquidity",
+		Params: map[string]interface{}{"trade_ids": tradeIds, "liquidity": TradeLiquidityMaker},
+	})
+	defer tit.Stop()
+
+	var trades []*Trade
+	for {
+		row, err := tit.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return trades, err
+		}
+		var t Trade
+		err = row.ToStruct(&t)
+		if err != nil {
+			return trades, err
+		}
+		trades = append(trades, &t)
+	}
+	if order == "DESC" {
+		sort.Slice(trades, func(i, j int) bool { return trades[i].CreatedAt.After(trades[j].CreatedAt) })
+	} else {
+		sort.Slice(trades, func(i, j int) bool { return trades[i].CreatedAt.Before(trades[j].CreatedAt) })
+	}
+	return trades, nil
+}
+
+func (s *spannerStore) UserTradeVolume(ctx context.Context, userId, base, quote string, since time.Time) (number.Decimal, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{
+		SQL:    "SELECT price,amount FROM trades@{FORCE_INDEX=trades_by_user_base_quote_created} WHERE user_id=@user AND base_asset_id=@base AND quote_asset_id=@quote AND created_at>=@since",
+		Params: map[string]interface{}{"user": userId, "base": base, "quote": quote, "since": since},
+	})
+	defer it.Stop()
+
+	volume := number.Zero()
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			return volume, nil
+		} else if err != nil {
+			return volume, err
+		}
+		var price, amount string
+		err = row.Columns(&price, &amount)
+		if err != nil {
+			return volume, err
+		}
+		volume = volume.Add(number.FromString(price).Mul(number.FromString(amount)))
+	}
+}
+
+func (s *spannerStore) CountPendingTransfers(ctx context.Context) (int64, error) {
+	return s.count(ctx, "SELECT COUNT(*) FROM transfers")
+}
+
+func (s *spannerStore) ListPendingTransfers(ctx context.Context, broker string, limit int) ([]*Transfer, error) {
+	txn := s.client.ReadOnlyTransaction()
+	defer txn.Close()
+
+	it := txn.Query(ctx, spanner.Statement{
+		SQL:    fmt.Sprintf("SELECT transfer_id FROM transfers@{FORCE_INDEX=transfers_by_broker_created} WHERE broker_id=@broker ORDER BY broker_id,created_at LIMIT %d", limit),
+		Params: map[string]interface{}{"broker": broker},
+	})
+	defer it.Stop()
+
+	transferIds := make([]string, 0)
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		var id string
+		err = row.Columns(&id)
+		if err != nil {
+			return nil, err
+		}
+		transferIds = append(transferIds, id)
+	}
+
+	tit := txn.Query(ctx, spanner.Statement{
+		SQL:    "SELECT * FROM transfers WHERE transfer_id IN UNNEST(@transfer_ids)",
+		Params: map[string]interface{}{"transfer_ids": transferIds},
+	})
+	defer tit.Stop()
+
+	transfers := make([]*Transfer, 0)
+	for {
+		row, err := tit.Next()
+		if err == iterator.Done {
+			return transfers, nil
+		} else if err != nil {
+			return transfers, err
+		}
+		var transfer Transfer
+		err = row.ToStruct(&transfer)
+		if err != nil {
+			return transfers, err
+		}
+		transfers = append(transfers, &transfer)
+	}
+}
+
+func (s *spannerStore) ExpireTransfers(ctx context.Context, transfers []*Transfer) error {
+	var set []spanner.KeySet
+	for _, t := range transfers {
+		set = append(set, spanner.Key{t.TransferId})
+	}
+	_, err := s.client.Apply(ctx, []*spanner.Mutation{
+		spanner.Delete("transfers", spanner.KeySets(set...)),
+	})
+	return err
+}
+
+func (s *spannerStore) ReadTransferTrade(ctx context.Context, tradeId, assetId string) (*Trade, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{
+		SQL:    "SELECT * FROM trades WHERE trade_id=@trade_id",
+		Params: map[string]interface{}{"trade_id": tradeId},
+	})
+	defer it.Stop()
+
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		var trade Trade
+		err = row.ToStruct(&trade)
+		if err != nil {
+			return nil, err
+		}
+		if trade.FeeAssetId == assetId {
+			return &trade, nil
+		}
+	}
+}
+
+func (s *spannerStore) CreateTransfer(ctx context.Context, transfer *Transfer) error {
+	mutation, err := spanner.InsertStruct("transfers", transfer)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Apply(ctx, []*spanner.Mutation{mutation})
+	return err
+}
+
+func (s *spannerStore) ReadBrokers(ctx context.Context) ([]*Broker, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{SQL: "SELECT * FROM brokers"})
+	defer it.Stop()
+
+	brokers := make([]*Broker, 0)
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			return brokers, nil
+		} else if err != nil {
+			return brokers, err
+		}
+		var broker Broker
+		err = row.ToStruct(&broker)
+		if err != nil {
+			return brokers, err
+		}
+		brokers = append(brokers, &broker)
+	}
+}
+
+func (s *spannerStore) CreateBroker(ctx context.Context, broker *Broker) error {
+	insertBroker, err := spanner.InsertStruct("brokers", broker)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Apply(ctx, []*spanner.Mutation{insertBroker})
+	return err
+}
+
+func (s *spannerStore) ReadMarkets(ctx context.Context) ([]*Market, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{SQL: "SELECT * FROM markets"})
+	defer it.Stop()
+
+	markets := make([]*Market, 0)
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			return markets, nil
+		} else if err != nil {
+			return markets, err
+		}
+		var market Market
+		err = row.ToStruct(&market)
+		if err != nil {
+			return markets, err
+		}
+		markets = append(markets, &market)
+	}
+}
+
+func (s *spannerStore) WriteMarket(ctx context.Context, market *Market, audit *MarketAudit) error {
+	upsertMarket, err := spanner.InsertOrUpdateStruct("markets", market)
+	if err != nil {
+		return err
+	}
+	mutations := []*spanner.Mutation{upsertMarket}
+	if audit != nil {
+		insertAudit, err := spanner.InsertStruct("market_audits", audit)
+		if err != nil {
+			return err
+		}
+		mutations = append(mutations, insertAudit)
+	}
+	_, err = s.client.Apply(ctx, mutations)
+	return err
+}
+
+func (s *spannerStore) ReadMarketAudits(ctx context.Context, base, quote string, limit int) ([]*MarketAudit, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{
+		SQL:    fmt.Sprintf("SELECT * FROM market_audits@{FORCE_INDEX=market_audits_by_base_quote_created} WHERE base_asset_id=@base AND quote_asset_id=@quote ORDER BY base_asset_id,quote_asset_id,created_at DESC LIMIT %d", limit),
+		Params: map[string]interface{}{"base": base, "quote": quote},
+	})
+	defer it.Stop()
+
+	audits := make([]*MarketAudit, 0)
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			return audits, nil
+		} else if err != nil {
+			return audits, err
+		}
+		var audit MarketAudit
+		err = row.ToStruct(&audit)
+		if err != nil {
+			return audits, err
+		}
+		audits = append(audits, &audit)
+	}
+}
+
+func (s *spannerStore) ReadFeeTiers(ctx context.Context) ([]*FeeTier, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{SQL: "SELECT * FROM fee_tiers"})
+	defer it.Stop()
+
+	tiers := make([]*FeeTier, 0)
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			return tiers, nil
+		} else if err != nil {
+			return tiers, err
+		}
+		var tier FeeTier
+		err = row.ToStruct(&tier)
+		if err != nil {
+			return tiers, err
+		}
+		tiers = append(tiers, &tier)
+	}
+}
+
+func (s *spannerStore) WriteFeeTier(ctx context.Context, tier *FeeTier) error {
+	mutation, err := spanner.InsertOrUpdateStruct("fee_tiers", tier)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Apply(ctx, []*spanner.Mutation{mutation})
+	return err
+}
+
+func (s *spannerStore) DeleteFeeTier(ctx context.Context, market, brokerId, minimumVolume string) error {
+	_, err := s.client.Apply(ctx, []*spanner.Mutation{spanner.Delete("fee_tiers", spanner.Key{market, brokerId, minimumVolume})})
+	return err
+}
+
+func (s *spannerStore) ReadBrokerFeeShares(ctx context.Context) ([]*BrokerFeeShare, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{SQL: "SELECT * FROM broker_fee_shares"})
+	defer it.Stop()
+
+	shares := make([]*BrokerFeeShare, 0)
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			return shares, nil
+		} else if err != nil {
+			return shares, err
+		}
+		var share BrokerFeeShare
+		err = row.ToStruct(&share)
+		if err != nil {
+			return shares, err
+		}
+		shares = append(shares, &share)
+	}
+}
+
+func (s *spannerStore) WriteBrokerFeeShare(ctx context.Context, share *BrokerFeeShare) error {
+	mutation, err := spanner.InsertOrUpdateStruct("broker_fee_shares", share)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Apply(ctx, []*spanner.Mutation{mutation})
+	return err
+}
+
+func (s *spannerStore) ListUnpaidFees(ctx context.Context, beneficiaryId string, limit int) ([]*FeeEntry, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{
+		SQL:    fmt.Sprintf("SELECT trade_id,liquidity,beneficiary_id,broker_id,asset_id,amount,created_at,payout_id FROM fee_ledger@{FORCE_INDEX=fee_ledger_by_payout_beneficiary_created} WHERE payout_id=@payout AND beneficiary_id=@beneficiary ORDER BY payout_id,beneficiary_id,created_at LIMIT %d", limit),
+		Params: map[string]interface{}{"payout": "", "beneficiary": beneficiaryId},
+	})
+	defer it.Stop()
+
+	entries := make([]*FeeEntry, 0)
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			return entries, nil
+		} else if err != nil {
+			return entries, err
+		}
+		var entry FeeEntry
+		err = row.ToStruct(&entry)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, &entry)
+	}
+}
+
+func (s *spannerStore) CreateFeePayout(ctx context.Context, payout *FeePayout, entries []*FeeEntry, transfer *Transfer, carry *FeeEntry) error {
+	_, err := s.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		var set []spanner.KeySet
+		for _, e := range entries {
+			set = append(set, spanner.Key{e.TradeId, e.Liquidity, e.BeneficiaryId})
+		}
+		it := txn.Read(ctx, "fee_ledger", spanner.KeySets(set...), []string{"payout_id"})
+		defer it.Stop()
+
+		count := 0
+		for {
+			row, err := it.Next()
+			if err == iterator.Done {
+				break
+			} else if err != nil {
+				return err
+			}
+			var payoutId string
+			err = row.Columns(&payoutId)
+			if err != nil {
+				return err
+			}
+			if payoutId != "" {
+				return fmt.Errorf("fee entries already paid by %s", payoutId)
+			}
+			count++
+		}
+		if count != len(entries) {
+			return fmt.Errorf("fee entries not found for payout %s", payout.PayoutId)
+		}
+
+		payoutMutation, err := spanner.InsertStruct("fee_payouts", payout)
+		if err != nil {
+			return err
+		}
+		mutations := []*spanner.Mutation{payoutMutation}
+		if transfer != nil {
+			transferMutation, err := spanner.InsertStruct("transfers", transfer)
+			if err != nil {
+				return err
+			}
+			mutations = append(mutations, transferMutation)
+		}
+		if carry != nil {
+			carryMutation, err := spanner.InsertStruct("fee_ledger", carry)
+			if err != nil {
+				return err
+			}
+			mutations = append(mutations, carryMutation)
+		}
+		for _, e := range entries {
+			mutations = append(mutations, spanner.Update("fee_ledger", []string{"trade_id", "liquidity", "beneficiary_id", "payout_id"}, []interface{}{e.TradeId, e.Liquidity, e.BeneficiaryId, payout.PayoutId}))
+		}
+		return txn.BufferWrite(mutations)
+	})
+	return err
+}
+
+func (s *spannerStore) ReadFeePayouts(ctx context.Context, beneficiaryId string) ([]*FeePayout, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{
+		SQL:    "SELECT * FROM fee_payouts@{FORCE_INDEX=fee_payouts_by_beneficiary_created} WHERE beneficiary_id=@beneficiary ORDER BY beneficiary_id,created_at",
+		Params: map[string]interface{}{"beneficiary": beneficiaryId},
+	})
+	defer it.Stop()
+
+	payouts := make([]*FeePayout, 0)
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			return payouts, nil
+		} else if err != nil {
+			return payouts, err
+		}
+		var payout FeePayout
+		err = row.ToStruct(&payout)
+		if err != nil {
+			return payouts, err
+		}
+		payouts = append(payouts, &payout)
+	}
+}
+
+func (s *spannerStore) ReadProperty(ctx context.Context, key string) (string, error) {
+	it := s.client.Single().Read(ctx, "properties", spanner.Key{key}, []string{"value"})
+	defer it.Stop()
+
+	row, err := it.Next()
+	if err == iterator.Done {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	var value string
+	err = row.Column(0, &value)
+	return value, err
+}
+
+func (s *spannerStore) WriteProperty(ctx context.Context, key, value string) error {
+	_, err := s.client.Apply(ctx, []*spanner.Mutation{
+		spanner.InsertOrUpdate("properties", []string{"key", "value", "updated_at"}, []interface{}{key, value, time.Now()}),
+	})
+	return err
+}
+
+func (s *spannerStore) ReadUserPublicKey(ctx context.Context, userId string) (string, error) {
+	it := s.client.Single().Read(ctx, "users", spanner.Key{userId}, []string{"public_key"})
+	defer it.Stop()
+
+	row, err := it.Next()
+	if err == iterator.Done {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	var publicKey string
+	err = row.Columns(&publicKey)
+	return publicKey, err
+}
+
+func (s *spannerStore) UpdateUserPublicKey(ctx context.Context, userId, publicKey string) error {
+	_, err := s.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		it := txn.ReadUsingIndex(ctx, "users", "users_by_public_key", spanner.Key{publicKey}, []string{"user_id"})
+		defer it.Stop()
+
+		_, err := it.Next()
+		if err == iterator.Done {
+		} else if err != nil {
+			return err
+		} else {
+			return nil
+		}
+
+		return txn.BufferWrite([]*spanner.Mutation{spanner.InsertOrUpdateMap("users", map[string]interface{}{
+			"user_id":    userId,
+			"public_key": publicKey,
+		})})
+	})
+	return err
+}
+
+func (s *spannerStore) WriteBookSnapshots(ctx context.Context, snapshots []*BookSnapshot) error {
+	mutations := make([]*spanner.Mutation, 0)
+	for _, snapshot := range snapshots {
+		mutation, err := spanner.InsertOrUpdateStruct("snapshots", snapshot)
+		if err != nil {
+			return err
+		}
+		mutations = append(mutations, mutation)
+	}
+	if len(mutations) == 0 {
+		return nil
+	}
+	_, err := s.client.Apply(ctx, mutations)
+	return err
+}
+
+func (s *spannerStore) ReadBookSnapshots(ctx context.Context) ([]*BookSnapshot, error) {
+	it := s.client.Single().Read(ctx, "snapshots", spanner.AllKeys(), []string{"market", "checkpoint", "data", "created_at"})
+	defer it.Stop()
+
+	snapshots := make([]*BookSnapshot, 0)
+	for {
+		row, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return snapshots, err
+		}
+		var snapshot BookSnapshot
+		err = row.ToStruct(&snapshot)
+		if err != nil {
+			return snapshots, err
+		}
+		snapshots = append(snapshots, &snapshot)
+	}
+	return snapshots, nil
+}
+
+func (s *spannerStore) count(ctx context.Context, query string) (int64, error) {
+	it := s.client.Single().Query(ctx, spanner.Statement{SQL: query})
+	defer it.Stop()
+
+	row, err := it.Next()
+	if err == iterator.Done {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = row.Columns(&count)
+	return count, err
+}
+
+func (s *spannerStore) checkActionExistence(ctx context.Context, txn *spanner.ReadWriteTransaction, orderId, action string) (bool, error) {
+	it := txn.Read(ctx, "actions", spanner.Key{orderId, action}, []string{"created_at"})
+	defer it.Stop()
+
+	_, err := it.Next()
+	if err == iterator.Done {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *spannerStore) checkOrderState(ctx context.Context, txn *spanner.ReadWriteTransaction, orderId string) (*Order, error) {
+	it := txn.Read(ctx, "orders", spanner.Key{orderId}, []string{"order_type", "state", "user_id"})
+	defer it.Stop()
+
+	row, err := it.Next()
+	if err == iterator.Done {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var o Order
+	err = row.Columns(&o.OrderType, &o.State, &o.UserId)
+	return &o, err
+}
+
+func makeOrderMutations(taker, maker *engine.Order) []*spanner.Mutation {
+	takerOrderCols := []string{"order_id", "filled_amount", "remaining_amount", "filled_funds", "remaining_funds"}
+	takerOrderVals := []interface{}{taker.Id, taker.FilledAmount.Persist(), taker.RemainingAmount.Persist(), taker.FilledFunds.Persist(), taker.RemainingFunds.Persist()}
+	makerOrderCols := []string{"order_id", "filled_amount", "remaining_amount", "filled_funds", "remaining_funds"}
+	makerOrderVals := []interface{}{maker.Id, maker.FilledAmount.Persist(), maker.RemainingAmount.Persist(), maker.FilledFunds.Persist(), maker.RemainingFunds.Persist()}
+	if taker.RemainingAmount.IsZero() && taker.RemainingFunds.IsZero() {
+		takerOrderCols = append(takerOrderCols, "state")
+		takerOrderVals = append(takerOrderVals, OrderStateDone)
+	}
+	if maker.RemainingAmount.IsZero() && maker.RemainingFunds.IsZero() {
+		makerOrderCols = append(makerOrderCols, "state")
+		makerOrderVals = append(makerOrderVals, OrderStateDone)
+	}
+	mutations := []*spanner.Mutation{
+		spanner.Update("orders", takerOrderCols, takerOrderVals),
+		spanner.Update("orders", makerOrderCols, makerOrderVals),
+	}
+
+	if taker.RemainingAmount.IsZero() && taker.RemainingFunds.IsZero() {
+		mutations = append(mutations, spanner.Delete("actions", spanner.Key{taker.Id, engine.OrderActionCreate}))
+		mutations = append(mutations, spanner.Delete("actions", spanner.Key{taker.Id, engine.OrderActionCancel}))
+		mutations = append(mutations, spanner.Delete("actions", spanner.Key{taker.Id, engine.OrderActionAmend}))
+	}
+	if maker.RemainingAmount.IsZero() && maker.RemainingFunds.IsZero() {
+		mutations = append(mutations, spanner.Delete("actions", spanner.Key{maker.Id, engine.OrderActionCreate}))
+		mutations = append(mutations, spanner.Delete("actions", spanner.Key{maker.Id, engine.OrderActionCancel}))
+		mutations = append(mutations, spanner.Delete("actions", spanner.Key{maker.Id, engine.OrderActionAmend}))
+	}
+	return mutations
+}
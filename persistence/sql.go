@@ -0,0 +1,724 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/engine"
+)
+
+const (
+	SQLDialectPostgres = "postgres"
+	SQLDialectSQLite   = "sqlite3"
+)
+
+const (
+	sqlOrderColumns       = "order_id,order_type,quote_asset_id,base_asset_id,side,price,remaining_amount,filled_amount,remaining_funds,filled_funds,trigger_price,time_in_force,expired_at,display_amount,created_at,state,user_id,broker_id"
+	sqlActionColumns      = "order_id,action,price,amount,created_at"
+	sqlTradeColumns       = "trade_id,liquidity,ask_order_id,bid_order_id,quote_asset_id,base_asset_id,side,price,amount,created_at,user_id,fee_asset_id,fee_amount,fee_rate"
+	sqlTransferColumns    = "transfer_id,source,detail,asset_id,amount,created_at,user_id,broker_id,reason"
+	sqlBrokerColumns      = "broker_id,session_id,session_key,pin_token,encrypted_pin,encryption_header,created_at"
+	sqlSnapshotColumns    = "market,checkpoint,data,created_at"
+	sqlMarketColumns      = "base_asset_id,quote_asset_id,price_precision,amount_precision,minimum_funds,tick_size,lot_size,status,created_at,updated_at"
+	sqlMarketAuditColumns = "audit_id,base_asset_id,quote_asset_id,previous_status,status,operator,reason,created_at"
+	sqlFeeTierColumns     = "market,broker_id,minimum_volume,maker_fee_rate,taker_fee_rate,updated_at"
+	sqlFeeShareColumns    = "broker_id,share_rate,payout_user_id,updated_at"
+	sqlFeeEntryColumns    = "trade_id,liquidity,beneficiary_id,broker_id,asset_id,amount,created_at,payout_id"
+	sqlFeePayoutColumns   = "payout_id,beneficiary_id,broker_id,asset_id,amount,entries,user_id,created_at"
+)
+
+type sqlStore struct {
+	db      *sql.DB
+	dialect string
+}
+
+type sqlQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func NewSQLStore(db *sql.DB, dialect string) Store {
+	return &sqlStore{db: db, dialect: dialect}
+}
+
+func (s *sqlStore) CountPendingActions(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM actions").Scan(&count)
+	return count, err
+}
+
+func (s *sqlStore) ListPendingActions(ctx context.Context, checkpoint time.Time, limit int) ([]*Action, error) {
+	query := "SELECT a.order_id,a.action,a.price,a.amount,a.created_at,o." + strings.Replace(sqlOrderColumns, ",", ",o.", -1)
+	query = query + fmt.Sprintf(" FROM actions a JOIN orders o ON o.order_id=a.order_id WHERE a.created_at>=? ORDER BY a.created_at LIMIT %d", limit)
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), checkpoint.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := make([]*Action, 0)
+	for rows.Next() {
+		var a Action
+		var o Order
+		dest := []interface{}{&a.OrderId, &a.Action, &a.Price, &a.Amount, &a.CreatedAt}
+		err = rows.Scan(append(dest, orderDest(&o)...)...)
+		if err != nil {
+			return actions, err
+		}
+		a.Order = &o
+		actions = append(actions, &a)
+	}
+	return actions, rows.Err()
+}
+
+func (s *sqlStore) CreateOrderAction(ctx context.Context, order *Order, action *Action) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		state, err := s.checkOrderState(ctx, tx, action.OrderId)
+		if err != nil || state != nil {
+			return err
+		}
+		err = s.insert(ctx, tx, "orders", sqlOrderColumns, orderValues(order)...)
+		if err != nil {
+			return err
+		}
+		return s.insert(ctx, tx, "actions", sqlActionColumns, actionValues(action)...)
+	})
+}
+
+func (s *sqlStore) CancelOrderAction(ctx context.Context, action *Action, userId string) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		exist, err := s.checkActionExistence(ctx, tx, action.OrderId, action.Action)
+		if err != nil || exist {
+			return err
+		}
+		state, err := s.checkOrderState(ctx, tx, action.OrderId)
+		if err != nil || state == nil {
+			return err
+		}
+		if state.UserId != userId || !cancellable(state) {
+			return nil
+		}
+		return s.insert(ctx, tx, "actions", sqlActionColumns, actionValues(action)...)
+	})
+}
+
+func (s *sqlStore) AmendOrderAction(ctx context.Context, action *Action, userId string) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		exist, err := s.checkActionExistence(ctx, tx, action.OrderId, engine.OrderActionCancel)
+		if err != nil || exist {
+			return err
+		}
+		state, err := s.checkOrderState(ctx, tx, action.OrderId)
+		if err != nil || state == nil {
+			return err
+		}
+		if state.UserId != userId || !amendable(state) {
+			return nil
+		}
+		query := "INSERT INTO actions (" + sqlActionColumns + ") VALUES (?,?,?,?,?)"
+		query = query + " ON CONFLICT (order_id,action) DO UPDATE SET price=excluded.price,amount=excluded.amount,created_at=excluded.created_at"
+		_, err = tx.ExecContext(ctx, s.rebind(query), actionValues(action)...)
+		return err
+	})
+}
+
+func (s *sqlStore) ReadOrder(ctx context.Context, orderId string) (*Order, error) {
+	query := "SELECT " + sqlOrderColumns + " FROM orders WHERE order_id=?"
+	var o Order
+	err := s.db.QueryRowContext(ctx, s.rebind(query), orderId).Scan(orderDest(&o)...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &o, err
+}
+
+func (s *sqlStore) UserOrders(ctx context.Context, userId, base, quote, state string, offset time.Time, order string, limit int) ([]*Order, error) {
+	cmp := "<"
+	if order != "DESC" {
+		cmp = ">"
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM orders WHERE user_id=? AND created_at%s=? AND state=?", sqlOrderColumns, cmp)
+	args := []interface{}{userId, offset.UTC(), state}
+	if base != "" && quote != "" {
+		query = query + " AND base_asset_id=? AND quote_asset_id=?"
+		args = append(args, base, quote)
+	}
+	query = fmt.Sprintf("%s ORDER BY created_at %s LIMIT %d", query, order, limit)
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []*Order
+	for rows.Next() {
+		var o Order
+		err = rows.Scan(orderDest(&o)...)
+		if err != nil {
+			return orders, err
+		}
+		orders = append(orders, &o)
+	}
+	return orders, rows.Err()
+}
+
+func (s *sqlStore) CancelOrder(ctx context.Context, order *engine.Order, transfer *Transfer) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		query := "UPDATE orders SET filled_amount=?,remaining_amount=?,filled_funds=?,remaining_funds=?,state=? WHERE order_id=?"
+		_, err := tx.ExecContext(ctx, s.rebind(query), order.FilledAmount.Persist(), order.RemainingAmount.Persist(), order.FilledFunds.Persist(), order.RemainingFunds.Persist(), OrderStateDone, order.Id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, s.rebind("DELETE FROM actions WHERE order_id=?"), order.Id)
+		if err != nil {
+			return err
+		}
+		return s.insert(ctx, tx, "transfers", sqlTransferColumns, transferValues(transfer)...)
+	})
+}
+
+func (s *sqlStore) DecrementOrder(ctx context.Context, order *engine.Order, transfer *Transfer) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		query := "UPDATE orders SET remaining_amount=?,remaining_funds=? WHERE order_id=?"
+		_, err := tx.ExecContext(ctx, s.rebind(query), order.RemainingAmount.Persist(), order.RemainingFunds.Persist(), order.Id)
+		if err != nil {
+			return err
+		}
+		return s.insert(ctx, tx, "transfers", sqlTransferColumns, transferValues(transfer)...)
+	})
+}
+
+func (s *sqlStore) AmendOrder(ctx context.Context, order *engine.Order, transfer *Transfer) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		query := "UPDATE orders SET price=?,remaining_amount=?,remaining_funds=? WHERE order_id=?"
+		_, err := tx.ExecContext(ctx, s.rebind(query), order.Price.Persist(), order.RemainingAmount.Persist(), order.RemainingFunds.Persist(), order.Id)
+		if err != nil || transfer == nil {
+			return err
+		}
+		return s.insert(ctx, tx, "transfers", sqlTransferColumns, transferValues(transfer)...)
+	})
+}
+
+func (s *sqlStore) TriggerOrder(ctx context.Context, orderId string) error {
+	_, err := s.db.ExecContext(ctx, s.rebind("UPDATE orders SET state=? WHERE order_id=?"), OrderStatePending, orderId)
+	return err
+}
+
+func (s *sqlStore) Transact(ctx context.Context, taker, maker *engine.Order, trades []*Trade, transfers []*Transfer, fees []*FeeEntry) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		for _, o := range []*engine.Order{taker, maker} {
+			err := s.updateOrderFills(ctx, tx, o)
+			if err != nil {
+				return err
+			}
+		}
+		for _, t := range trades {
+			err := s.insert(ctx, tx, "trades", sqlTradeColumns, tradeValues(t)...)
+			if err != nil {
+				return err
+			}
+		}
+		for _, t := range transfers {
+			err := s.insert(ctx, tx, "transfers", sqlTransferColumns, transferValues(t)...)
+			if err != nil {
+				return err
+			}
+		}
+		for _, f := range fees {
+			err := s.insert(ctx, tx, "fee_ledger", sqlFeeEntryColumns, f.TradeId, f.Liquidity, f.BeneficiaryId, f.BrokerId, f.AssetId, f.Amount, f.CreatedAt.UTC(), f.PayoutId)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) LastTrade(ctx context.Context, base, quote string) (*Trade, error) {
+	query := fmt.Sprintf("SELECT %s FROM trades WHERE base_asset_id=? AND quote_asset_id=? ORDER BY created_at DESC LIMIT 1", sqlTradeColumns)
+	var t Trade
+	err := s.db.QueryRowContext(ctx, s.rebind(query), base, quote).Scan(tradeDest(&t)...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &t, err
+}
+
+func (s *sqlStore) MarketTrades(ctx context.Context, base, quote string, offset time.Time, order string, limit int) ([]*Trade, error) {
+	cmp := "<"
+	if order != "DESC" {
+		cmp = ">"
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM trades WHERE base_asset_id=? AND quote_asset_id=? AND created_at%s=? AND liquidity=?", sqlTradeColumns, cmp)
+	query = fmt.Sprintf("%s ORDER BY created_at %s LIMIT %d", query, order, limit)
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), base, quote, offset.UTC(), TradeLiquidityMaker)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trades []*Trade
+	for rows.Next() {
+		var t Trade
+		err = rows.Scan(tradeDest(&t)...)
+		if err != nil {
+			return trades, err
+		}
+		trades = append(trades, &t)
+	}
+	return trades, rows.Err()
+}
+
+func (s *sqlStore) UserTradeVolume(ctx context.Context, userId, base, quote string, since time.Time) (number.Decimal, error) {
+	volume := number.Zero()
+	query := "SELECT price,amount FROM trades WHERE user_id=? AND base_asset_id=? AND quote_asset_id=? AND created_at>=?"
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), userId, base, quote, since.UTC())
+	if err != nil {
+		return volume, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var price, amount string
+		err = rows.Scan(&price, &amount)
+		if err != nil {
+			return volume, err
+		}
+		volume = volume.Add(number.FromString(price).Mul(number.FromString(amount)))
+	}
+	return volume, rows.Err()
+}
+
+func (s *sqlStore) CountPendingTransfers(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM transfers").Scan(&count)
+	return count, err
+}
+
+func (s *sqlStore) ListPendingTransfers(ctx context.Context, broker string, limit int) ([]*Transfer, error) {
+	query := fmt.Sprintf("SELECT %s FROM transfers WHERE broker_id=? ORDER BY created_at LIMIT %d", sqlTransferColumns, limit)
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), broker)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := make([]*Transfer, 0)
+	for rows.Next() {
+		var t Transfer
+		err = rows.Scan(&t.TransferId, &t.Source, &t.Detail, &t.AssetId, &t.Amount, &t.CreatedAt, &t.UserId, &t.BrokerId, &t.Reason)
+		if err != nil {
+			return transfers, err
+		}
+		transfers = append(transfers, &t)
+	}
+	return transfers, rows.Err()
+}
+
+func (s *sqlStore) ExpireTransfers(ctx context.Context, transfers []*Transfer) error {
+	if len(transfers) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(transfers))
+	for i, t := range transfers {
+		args[i] = t.TransferId
+	}
+	query := "DELETE FROM transfers WHERE transfer_id IN (" + sqlPlaceholders(len(args)) + ")"
+	_, err := s.db.ExecContext(ctx, s.rebind(query), args...)
+	return err
+}
+
+func (s *sqlStore) ReadTransferTrade(ctx context.Context, tradeId, assetId string) (*Trade, error) {
+	query := fmt.Sprintf("SELECT %s FROM trades WHERE trade_id=? AND fee_asset_id=?", sqlTradeColumns)
+	var t Trade
+	err := s.db.QueryRowContext(ctx, s.rebind(query), tradeId, assetId).Scan(tradeDest(&t)...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &t, err
+}
+
+func (s *sqlStore) CreateTransfer(ctx context.Context, transfer *Transfer) error {
+	return s.insert(ctx, s.db, "transfers", sqlTransferColumns, transferValues(transfer)...)
+}
+
+func (s *sqlStore) ReadBrokers(ctx context.Context) ([]*Broker, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sqlBrokerColumns+" FROM brokers")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	brokers := make([]*Broker, 0)
+	for rows.Next() {
+		var b Broker
+		err = rows.Scan(&b.BrokerId, &b.SessionId, &b.SessionKey, &b.PINToken, &b.EncryptedPIN, &b.EncryptionHeader, &b.CreatedAt)
+		if err != nil {
+			return brokers, err
+		}
+		brokers = append(brokers, &b)
+	}
+	return brokers, rows.Err()
+}
+
+func (s *sqlStore) CreateBroker(ctx context.Context, b *Broker) error {
+	return s.insert(ctx, s.db, "brokers", sqlBrokerColumns, b.BrokerId, b.SessionId, b.SessionKey, b.PINToken, b.EncryptedPIN, b.EncryptionHeader, b.CreatedAt.UTC())
+}
+
+func (s *sqlStore) ReadMarkets(ctx context.Context) ([]*Market, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sqlMarketColumns+" FROM markets")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	markets := make([]*Market, 0)
+	for rows.Next() {
+		var m Market
+		err = rows.Scan(&m.BaseAssetId, &m.QuoteAssetId, &m.PricePrecision, &m.AmountPrecision, &m.MinimumFunds, &m.TickSize, &m.LotSize, &m.Status, &m.CreatedAt, &m.UpdatedAt)
+		if err != nil {
+			return markets, err
+		}
+		markets = append(markets, &m)
+	}
+	return markets, rows.Err()
+}
+
+func (s *sqlStore) WriteMarket(ctx context.Context, m *Market, audit *MarketAudit) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		query := "INSERT INTO markets (" + sqlMarketColumns + ") VALUES (" + sqlPlaceholders(10) + ") ON CONFLICT (base_asset_id,quote_asset_id) DO UPDATE SET minimum_funds=excluded.minimum_funds,tick_size=excluded.tick_size,lot_size=excluded.lot_size,status=excluded.status,updated_at=excluded.updated_at"
+		_, err := tx.ExecContext(ctx, s.rebind(query), m.BaseAssetId, m.QuoteAssetId, m.PricePrecision, m.AmountPrecision, m.MinimumFunds, m.TickSize, m.LotSize, m.Status, m.CreatedAt.UTC(), m.UpdatedAt.UTC())
+		if err != nil || audit == nil {
+			return err
+		}
+		return s.insert(ctx, tx, "market_audits", sqlMarketAuditColumns, audit.AuditId, audit.BaseAssetId, audit.QuoteAssetId, audit.PreviousStatus, audit.Status, audit.Operator, audit.Reason, audit.CreatedAt.UTC())
+	})
+}
+
+func (s *sqlStore) ReadFeeTiers(ctx context.Context) ([]*FeeTier, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sqlFeeTierColumns+" FROM fee_tiers")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tiers := make([]*FeeTier, 0)
+	for rows.Next() {
+		var t FeeTier
+		err = rows.Scan(&t.Market, &t.BrokerId, &t.MinimumVolume, &t.MakerFeeRate, &t.TakerFeeRate, &t.UpdatedAt)
+		if err != nil {
+			return tiers, err
+		}
+		tiers = append(tiers, &t)
+	}
+	return tiers, rows.Err()
+}
+
+func (s *sqlStore) WriteFeeTier(ctx context.Context, t *FeeTier) error {
+	query := "INSERT INTO fee_tiers (" + sqlFeeTierColumns + ") VALUES (" + sqlPlaceholders(6) + ") ON CONFLICT (market,broker_id,minimum_volume) DO UPDATE SET maker_fee_rate=excluded.maker_fee_rate,taker_fee_rate=excluded.taker_fee_rate,updated_at=excluded.updated_at"
+	_, err := s.db.ExecContext(ctx, s.rebind(query), t.Market, t.BrokerId, t.MinimumVolume, t.MakerFeeRate, t.TakerFeeRate, t.UpdatedAt.UTC())
+	return err
+}
+
+func (s *sqlStore) DeleteFeeTier(ctx context.Context, market, brokerId, minimumVolume string) error {
+	query := "DELETE FROM fee_tiers WHERE market=? AND broker_id=? AND minimum_volume=?"
+	_, err := s.db.ExecContext(ctx, s.rebind(query), market, brokerId, minimumVolume)
+	return err
+}
+
+func (s *sqlStore) ReadBrokerFeeShares(ctx context.Context) ([]*BrokerFeeShare, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sqlFeeShareColumns+" FROM broker_fee_shares")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := make([]*BrokerFeeShare, 0)
+	for rows.Next() {
+		var b BrokerFeeShare
+		err = rows.Scan(&b.BrokerId, &b.ShareRate, &b.PayoutUserId, &b.UpdatedAt)
+		if err != nil {
+			return shares, err
+		}
+		shares = append(shares, &b)
+	}
+	return shares, rows.Err()
+}
+
+func (s *sqlStore) WriteBrokerFeeShare(ctx context.Context, b *BrokerFeeShare) error {
+	query := "INSERT INTO broker_fee_shares (" + sqlFeeShareColumns + ") VALUES (" + sqlPlaceholders(4) + ") ON CONFLICT (broker_id) DO UPDATE SET share_rate=excluded.share_rate,payout_user_id=excluded.payout_user_id,updated_at=excluded.updated_at"
+	_, err := s.db.ExecContext(ctx, s.rebind(query), b.BrokerId, b.ShareRate, b.PayoutUserId, b.UpdatedAt.UTC())
+	return err
+}
+
+func (s *sqlStore) ListUnpaidFees(ctx context.Context, beneficiaryId string, limit int) ([]*FeeEntry, error) {
+	query := fmt.Sprintf("SELECT %s FROM fee_ledger WHERE payout_id=? AND beneficiary_id=? ORDER BY created_at LIMIT %d", sqlFeeEntryColumns, limit)
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), "", beneficiaryId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*FeeEntry, 0)
+	for rows.Next() {
+		var e FeeEntry
+		err = rows.Scan(&e.TradeId, &e.Liquidity, &e.BeneficiaryId, &e.BrokerId, &e.AssetId, &e.Amount, &e.CreatedAt, &e.PayoutId)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
+
+func (s *sqlStore) CreateFeePayout(ctx context.Context, payout *FeePayout, entries []*FeeEntry, transfer *Transfer, carry *FeeEntry) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		for _, e := range entries {
+			query := "UPDATE fee_ledger SET payout_id=? WHERE trade_id=? AND liquidity=? AND beneficiary_id=? AND payout_id=?"
+			result, err := tx.ExecContext(ctx, s.rebind(query), payout.PayoutId, e.TradeId, e.Liquidity, e.BeneficiaryId, "")
+			if err != nil {
+				return err
+			}
+			if n, err := result.RowsAffected(); err != nil {
+				return err
+			} else if n != 1 {
+				return fmt.Errorf("fee entry %s %s already paid", e.TradeId, e.Liquidity)
+			}
+		}
+		err := s.insert(ctx, tx, "fee_payouts", sqlFeePayoutColumns, payout.PayoutId, payout.BeneficiaryId, payout.BrokerId, payout.AssetId, payout.Amount, payout.Entries, payout.UserId, payout.CreatedAt.UTC())
+		if err != nil {
+			return err
+		}
+		if carry != nil {
+			err = s.insert(ctx, tx, "fee_ledger", sqlFeeEntryColumns, carry.TradeId, carry.Liquidity, carry.BeneficiaryId, carry.BrokerId, carry.AssetId, carry.Amount, carry.CreatedAt.UTC(), carry.PayoutId)
+			if err != nil {
+				return err
+			}
+		}
+		if transfer == nil {
+			return nil
+		}
+		return s.insert(ctx, tx, "transfers", sqlTransferColumns, transferValues(transfer)...)
+	})
+}
+
+func (s *sqlStore) ReadFeePayouts(ctx context.Context, beneficiaryId string) ([]*FeePayout, error) {
+	query := "SELECT " + sqlFeePayoutColumns + " FROM fee_payouts WHERE beneficiary_id=? ORDER BY created_at"
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), beneficiaryId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payouts := make([]*FeePayout, 0)
+	for rows.Next() {
+		var p FeePayout
+		err = rows.Scan(&p.PayoutId, &p.BeneficiaryId, &p.BrokerId, &p.AssetId, &p.Amount, &p.Entries, &p.UserId, &p.CreatedAt)
+		if err != nil {
+			return payouts, err
+		}
+		payouts = append(payouts, &p)
+	}
+	return payouts, rows.Err()
+}
+
+func (s *sqlStore) ReadMarketAudits(ctx context.Context, base, quote string, limit int) ([]*MarketAudit, error) {
+	query := fmt.Sprintf("SELECT %s FROM market_audits WHERE base_asset_id=? AND quote_asset_id=? ORDER BY created_at DESC LIMIT %d", sqlMarketAuditColumns, limit)
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), base, quote)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	audits := make([]*MarketAudit, 0)
+	for rows.Next() {
+		var a MarketAudit
+		err = rows.Scan(&a.AuditId, &a.BaseAssetId, &a.QuoteAssetId, &a.PreviousStatus, &a.Status, &a.Operator, &a.Reason, &a.CreatedAt)
+		if err != nil {
+			return audits, err
+		}
+		audits = append(audits, &a)
+	}
+	return audits, rows.Err()
+}
+
+func (s *sqlStore) ReadProperty(ctx context.Context, key string) (string, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, s.rebind("SELECT value FROM properties WHERE key=?"), key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+func (s *sqlStore) WriteProperty(ctx context.Context, key, value string) error {
+	query := "INSERT INTO properties (key,value,updated_at) VALUES (?,?,?) ON CONFLICT (key) DO UPDATE SET value=excluded.value,updated_at=excluded.updated_at"
+	_, err := s.db.ExecContext(ctx, s.rebind(query), key, value, time.Now().UTC())
+	return err
+}
+
+func (s *sqlStore) ReadUserPublicKey(ctx context.Context, userId string) (string, error) {
+	var publicKey string
+	err := s.db.QueryRowContext(ctx, s.rebind("SELECT public_key FROM users WHERE user_id=?"), userId).Scan(&publicKey)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return publicKey, err
+}
+
+func (s *sqlStore) UpdateUserPublicKey(ctx context.Context, userId, publicKey string) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		var id string
+		err := tx.QueryRowContext(ctx, s.rebind("SELECT user_id FROM users WHERE public_key=?"), publicKey).Scan(&id)
+		if err != sql.ErrNoRows {
+			return err
+		}
+		query := "INSERT INTO users (user_id,public_key) VALUES (?,?) ON CONFLICT (user_id) DO UPDATE SET public_key=excluded.public_key"
+		_, err = tx.ExecContext(ctx, s.rebind(query), userId, publicKey)
+		return err
+	})
+}
+
+func (s *sqlStore) WriteBookSnapshots(ctx context.Context, snapshots []*BookSnapshot) error {
+	if len(snapshots) == 0 {
+		return nil
+	}
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		query := "INSERT INTO snapshots (" + sqlSnapshotColumns + ") VALUES (?,?,?,?)"
+		query = query + " ON CONFLICT (market) DO UPDATE SET checkpoint=excluded.checkpoint,data=excluded.data,created_at=excluded.created_at"
+		for _, snapshot := range snapshots {
+			_, err := tx.ExecContext(ctx, s.rebind(query), snapshot.Market, snapshot.Checkpoint.UTC(), snapshot.Data, snapshot.CreatedAt.UTC())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) ReadBookSnapshots(ctx context.Context) ([]*BookSnapshot, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sqlSnapshotColumns+" FROM snapshots")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := make([]*BookSnapshot, 0)
+	for rows.Next() {
+		var snapshot BookSnapshot
+		err = rows.Scan(&snapshot.Market, &snapshot.Checkpoint, &snapshot.Data, &snapshot.CreatedAt)
+		if err != nil {
+			return snapshots, err
+		}
+		snapshots = append(snapshots, &snapshot)
+	}
+	return snapshots, rows.Err()
+}
+
+func (s *sqlStore) updateOrderFills(ctx context.Context, tx *sql.Tx, o *engine.Order) error {
+	query := "UPDATE orders SET filled_amount=?,remaining_amount=?,filled_funds=?,remaining_funds=? WHERE order_id=?"
+	args := []interface{}{o.FilledAmount.Persist(), o.RemainingAmount.Persist(), o.FilledFunds.Persist(), o.RemainingFunds.Persist(), o.Id}
+	if !o.RemainingAmount.IsZero() || !o.RemainingFunds.IsZero() {
+		_, err := tx.ExecContext(ctx, s.rebind(query), args...)
+		return err
+	}
+
+	query = "UPDATE orders SET filled_amount=?,remaining_amount=?,filled_funds=?,remaining_funds=?,state=? WHERE order_id=?"
+	args = append(args[:4], OrderStateDone, o.Id)
+	_, err := tx.ExecContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, s.rebind("DELETE FROM actions WHERE order_id=?"), o.Id)
+	return err
+}
+
+func (s *sqlStore) checkActionExistence(ctx context.Context, tx *sql.Tx, orderId, action string) (bool, error) {
+	var createdAt time.Time
+	err := tx.QueryRowContext(ctx, s.rebind("SELECT created_at FROM actions WHERE order_id=? AND action=?"), orderId, action).Scan(&createdAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *sqlStore) checkOrderState(ctx context.Context, tx *sql.Tx, orderId string) (*Order, error) {
+	var o Order
+	err := tx.QueryRowContext(ctx, s.rebind("SELECT order_type,state,user_id FROM orders WHERE order_id=?"), orderId).Scan(&o.OrderType, &o.State, &o.UserId)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &o, err
+}
+
+func (s *sqlStore) insert(ctx context.Context, q sqlQueryer, table, columns string, values ...interface{}) error {
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, columns, sqlPlaceholders(len(values)))
+	_, err := q.ExecContext(ctx, s.rebind(query), values...)
+	return err
+}
+
+func (s *sqlStore) transaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) rebind(query string) string {
+	if s.dialect != SQLDialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+		n = n + 1
+		fmt.Fprintf(&b, "$%d", n)
+	}
+	return b.String()
+}
+
+func sqlPlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+func orderDest(o *Order) []interface{} {
+	return []interface{}{&o.OrderId, &o.OrderType, &o.QuoteAssetId, &o.BaseAssetId, &o.Side, &o.Price, &o.RemainingAmount, &o.FilledAmount, &o.RemainingFunds, &o.FilledFunds, &o.TriggerPrice, &o.TimeInForce, &o.ExpiredAt, &o.DisplayAmount, &o.CreatedAt, &o.State, &o.UserId, &o.BrokerId}
+}
+
+func orderValues(o *Order) []interface{} {
+	return []interface{}{o.OrderId, o.OrderType, o.QuoteAssetId, o.BaseAssetId, o.Side, o.Price, o.RemainingAmount, o.FilledAmount, o.RemainingFunds, o.FilledFunds, o.TriggerPrice, o.TimeInForce, o.ExpiredAt.UTC(), o.DisplayAmount, o.CreatedAt.UTC(), o.State, o.UserId, o.BrokerId}
+}
+
+func actionValues(a *Action) []interface{} {
+	return []interface{}{a.OrderId, a.Action, a.Price, a.Amount, a.CreatedAt.UTC()}
+}
+
+func tradeDest(t *Trade) []interface{} {
+	return []interface{}{&t.TradeId, &t.Liquidity, &t.AskOrderId, &t.BidOrderId, &t.QuoteAssetId, &t.BaseAssetId, &t.Side, &t.Price, &t.Amount, &t.CreatedAt, &t.UserId, &t.FeeAssetId, &t.FeeAmount, &t.FeeRate}
+}
+
+func tradeValues(t *Trade) []interface{} {
+	return []interface{}{t.TradeId, t.Liquidity, t.AskOrderId, t.BidOrderId, t.QuoteAssetId, t.BaseAssetId, t.Side, t.Price, t.Amount, t.CreatedAt.UTC(), t.UserId, t.FeeAssetId, t.FeeAmount, t.FeeRate}
+}
+
+func transferValues(t *Transfer) []interface{} {
+	return []interface{}{t.TransferId, t.Source, t.Detail, t.AssetId, t.Amount, t.CreatedAt.UTC(), t.UserId, t.BrokerId, t.Reason}
+}
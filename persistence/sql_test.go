@@ -0,0 +1,698 @@
+package persistence
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/engine"
+	"github.com/dgrijalva/jwt-go"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLOrderTradeTransfer(t *testing.T) {
+	ctx := testSetupSQLStore(t)
+	assert := assert.New(t)
+
+	base, quote := testUUID(), testUUID()
+	market := base + "-" + quote
+	askUser, askBroker, bidUser, bidBroker := testUUID(), testUUID(), testUUID(), testUUID()
+	ask := testEngineOrder(engine.PageSideAsk, base, quote, 10000, 100, askUser, askBroker)
+	bid := testEngineOrder(engine.PageSideBid, base, quote, 10000, 60, bidUser, bidBroker)
+
+	err := CreateOrderAction(ctx, ask, askUser, askBroker, time.Now())
+	assert.Nil(err)
+	err = CreateOrderAction(ctx, bid, bidUser, bidBroker, time.Now())
+	assert.Nil(err)
+	err = CreateOrderAction(ctx, bid, bidUser, bidBroker, time.Now())
+	assert.Nil(err)
+	count, err := CountPendingActions(ctx)
+	assert.Nil(err)
+	assert.Equal(int64(2), count)
+
+	actions, err := ListPendingActions(ctx, time.Time{}, 100)
+	assert.Nil(err)
+	assert.Len(actions, 2)
+	assert.Equal(ask.Id, actions[0].OrderId)
+	assert.Equal(engine.OrderActionCreate, actions[0].Action)
+	assert.Equal("10", actions[0].Amount)
+	assert.Equal("100", actions[0].Order.Price)
+	assert.Equal(askBroker, actions[0].Order.BrokerId)
+	assert.Equal(bid.Id, actions[1].OrderId)
+	assert.Equal("600", actions[1].Amount)
+	assert.Equal("600", actions[1].Order.RemainingFunds)
+	assert.Equal(OrderStatePending, actions[1].Order.State)
+
+	err = CancelOrderAction(ctx, ask.Id, time.Now(), bidUser)
+	assert.Nil(err)
+	count, _ = CountPendingActions(ctx)
+	assert.Equal(int64(2), count)
+
+	ask.RemainingAmount = number.NewInteger(40, 1)
+	ask.FilledAmount = number.NewInteger(60, 1)
+	ask.FilledFunds = number.NewInteger(600000, 3)
+	bid.RemainingFunds = number.NewInteger(0, 3)
+	bid.FilledAmount = number.NewInteger(60, 1)
+	bid.FilledFunds = number.NewInteger(600000, 3)
+	settled, _, err := Transact(ctx, bid, ask, number.NewInteger(60, 1), TakerFeeRate, MakerFeeRate, "0")
+	assert.Nil(err)
+	tradeId := settled[0].TradeId
+	assert.Equal(getSettlementId(bid.Id, ask.Id), tradeId)
+
+	actions, _ = ListPendingActions(ctx, time.Time{}, 100)
+	assert.Len(actions, 1)
+	assert.Equal(ask.Id, actions[0].OrderId)
+	assert.Equal("4", actions[0].Order.RemainingAmount)
+	assert.Equal("6", actions[0].Order.FilledAmount)
+	orders, err := UserOrders(ctx, bidUser, market, OrderStateDone, time.Time{}, "ASC", 10)
+	assert.Nil(err)
+	assert.Len(orders, 1)
+	assert.Equal(bid.Id, orders[0].OrderId)
+	assert.Equal("0", orders[0].RemainingFunds)
+
+	trade, err := LastTrade(ctx, market)
+	assert.Nil(err)
+	assert.Equal(tradeId, trade.TradeId)
+	assert.Equal("100", trade.Price)
+	assert.Equal("6", trade.Amount)
+	trades, err := MarketTrades(ctx, market, time.Time{}, "ASC", 10)
+	assert.Nil(err)
+	assert.Len(trades, 1)
+	assert.Equal(TradeLiquidityMaker, trades[0].Liquidity)
+	assert.Equal(askUser, trades[0].UserId)
+	trade, err = ReadTransferTrade(ctx, tradeId, base)
+	assert.Nil(err)
+	assert.Equal(TradeLiquidityTaker, trade.Liquidity)
+	assert.Equal("0.006", trade.FeeAmount)
+	assert.Equal("0.001", trade.FeeRate)
+	volume, err := UserTradeVolume(ctx, bidUser, market, time.Now().Add(-time.Hour))
+	assert.Nil(err)
+	assert.Equal("600", volume.Persist())
+	volume, _ = UserTradeVolume(ctx, bidUser, market, time.Now().Add(time.Hour))
+	assert.Equal("0", volume.Persist())
+
+	transfers, err := ListPendingTransfers(ctx, bidBroker, 10)
+	assert.Nil(err)
+	assert.Len(transfers, 1)
+	assert.Equal(TransferSourceTradeConfirmed, transfers[0].Source)
+	assert.Equal(quote, transfers[0].AssetId)
+	assert.Equal("600", transfers[0].Amount)
+	assert.Equal(askUser, transfers[0].UserId)
+	transfers, err = ListPendingTransfers(ctx, askBroker, 10)
+	assert.Nil(err)
+	assert.Len(transfers, 1)
+	assert.Equal(base, transfers[0].AssetId)
+	assert.Equal("5.994", transfers[0].Amount)
+	assert.Equal(bidUser, transfers[0].UserId)
+
+	err = CancelOrderAction(ctx, ask.Id, time.Now(), askUser)
+	assert.Nil(err)
+	count, _ = CountPendingActions(ctx)
+	assert.Equal(int64(2), count)
+	transfer, err := CancelOrder(ctx, ask)
+	assert.Nil(err)
+	assert.Equal(ask.Id, transfer.Detail)
+	count, _ = CountPendingActions(ctx)
+	assert.Equal(int64(0), count)
+	o, err := ReadOrder(ctx, ask.Id)
+	assert.Nil(err)
+	assert.Equal(OrderStateDone, o.State)
+
+	transfers, _ = ListPendingTransfers(ctx, askBroker, 10)
+	assert.Len(transfers, 2)
+	assert.Equal(TransferSourceOrderCancelled, transfers[1].Source)
+	assert.Equal(base, transfers[1].AssetId)
+	assert.Equal("4", transfers[1].Amount)
+	err = ExpireTransfers(ctx, transfers)
+	assert.Nil(err)
+	count, err = CountPendingTransfers(ctx)
+	assert.Nil(err)
+	assert.Equal(int64(1), count)
+}
+
+func TestSQLIcebergTransact(t *testing.T) {
+	ctx := testSetupSQLStore(t)
+	assert := assert.New(t)
+
+	base, quote := testUUID(), testUUID()
+	ask := testEngineOrder(engine.PageSideAsk, base, quote, 10000, 30, testUUID(), testUUID())
+	ask.DisplayAmount = number.NewInteger(10, 1)
+	bid := testEngineOrder(engine.PageSideBid, base, quote, 10000, 30, testUUID(), testUUID())
+	assert.Nil(CreateOrderAction(ctx, ask, ask.UserId, ask.BrokerId, time.Now()))
+	assert.Nil(CreateOrderAction(ctx, bid, bid.UserId, bid.BrokerId, time.Now()))
+
+	ids := make(map[string]bool)
+	slice, funds := number.NewInteger(10, 1), number.NewInteger(100000, 3)
+	for i := 0; i < 3; i++ {
+		ask.RemainingAmount, ask.FilledAmount = ask.RemainingAmount.Sub(slice), ask.FilledAmount.Add(slice)
+		ask.FilledFunds = ask.FilledFunds.Add(funds)
+		bid.RemainingFunds, bid.FilledFunds = bid.RemainingFunds.Sub(funds), bid.FilledFunds.Add(funds)
+		bid.FilledAmount = bid.FilledAmount.Add(slice)
+		trades, transfers, err := Transact(ctx, bid, ask, slice, TakerFeeRate, MakerFeeRate, "0")
+		assert.Nil(err)
+		assert.Len(transfers, 2)
+		ids[trades[0].TradeId] = true
+	}
+	assert.Len(ids, 3)
+	trades, err := MarketTrades(ctx, base+"-"+quote, time.Time{}, "ASC", 10)
+	assert.Nil(err)
+	assert.Len(trades, 3)
+	entries, err := ListUnpaidFees(ctx, "", 10)
+	assert.Nil(err)
+	assert.Len(entries, 3)
+}
+
+func TestSQLMigrations(t *testing.T) {
+	assert := assert.New(t)
+
+	schema, err := ioutil.ReadFile("schema_sql.sql")
+	assert.Nil(err)
+	migrations, err := ioutil.ReadFile("migrate_sql.sql")
+	assert.Nil(err)
+	legacy := testLegacySchema(t, string(schema), string(migrations))
+	assert.NotContains(legacy, "trigger_price")
+	ctx, db := testSetupSQLSchema(t, legacy)
+
+	base, quote := testUUID(), testUUID()
+	ask, bid := testUUID(), testUUID()
+	_, err = db.Exec(`INSERT INTO orders (order_id,order_type,quote_asset_id,base_asset_id,side,price,remaining_amount,filled_amount,remaining_funds,filled_funds,created_at,state,user_id,broker_id)
+		VALUES (?,'LIMIT',?,?,'ASK','100','10','0','0','0',?,'PENDING',?,?), (?,'MARKET',?,?,'BID','0','0','0','600','0',?,'PENDING',?,?)`,
+		ask, quote, base, time.Now().UTC(), testUUID(), testUUID(), bid, quote, base, time.Now().UTC(), testUUID(), testUUID())
+	assert.Nil(err)
+	transfer, trade := testUUID(), testUUID()
+	_, err = db.Exec(`INSERT INTO transfers (transfer_id,source,detail,asset_id,amount,created_at,user_id,broker_id)
+		VALUES (?,'REFUND',?,?,'1',?,?,?)`, transfer, testUUID(), quote, time.Now().UTC(), testUUID(), testUUID())
+	assert.Nil(err)
+	_, err = db.Exec(`INSERT INTO trades (trade_id,liquidity,ask_order_id,bid_order_id,quote_asset_id,base_asset_id,side,price,amount,created_at,user_id,fee_asset_id,fee_amount)
+		VALUES (?,'TAKER',?,?,?,?,'ASK','100','1',?,?,?,'0.1'), (?,'MAKER',?,?,?,?,'ASK','100','1',?,?,?,'0')`,
+		trade, ask, bid, quote, base, time.Now().UTC(), testUUID(), quote, trade, ask, bid, quote, base, time.Now().UTC(), testUUID(), base)
+	assert.Nil(err)
+	_, err = db.Exec(string(migrations))
+	assert.Nil(err)
+
+	rows, err := db.Query("SELECT trigger_price,time_in_force,expired_at,display_amount FROM orders WHERE order_id IN (?,?) ORDER BY side DESC", ask, bid)
+	assert.Nil(err)
+	var filled []string
+	for rows.Next() {
+		var price, timeInForce, display string
+		var expiredAt time.Time
+		assert.Nil(rows.Scan(&price, &timeInForce, &expiredAt, &display))
+		assert.True(expiredAt.IsZero())
+		filled = append(filled, price, timeInForce, display)
+	}
+	assert.Nil(rows.Close())
+	assert.Equal([]string{"0", engine.TimeInForceIOC, "0", "0", engine.TimeInForceGTC, "0"}, filled)
+
+	var reason string
+	assert.Nil(db.QueryRow("SELECT reason FROM transfers WHERE transfer_id=?", transfer).Scan(&reason))
+	assert.Equal("", reason)
+	rows, err = db.Query("SELECT fee_rate FROM trades WHERE trade_id=? ORDER BY liquidity DESC", trade)
+	assert.Nil(err)
+	var rates []string
+	for rows.Next() {
+		var rate string
+		assert.Nil(rows.Scan(&rate))
+		rates = append(rates, rate)
+	}
+	assert.Nil(rows.Close())
+	assert.Equal([]string{TakerFeeRate, "0"}, rates)
+
+	o := testEngineOrder(engine.PageSideAsk, base, quote, 10000, 30, testUUID(), testUUID())
+	o.DisplayAmount = number.NewInteger(10, 1)
+	assert.Nil(CreateOrderAction(ctx, o, o.UserId, o.BrokerId, time.Now()))
+	b := testEngineOrder(engine.PageSideBid, base, quote, 10000, 10, testUUID(), testUUID())
+	assert.Nil(CreateOrderAction(ctx, b, b.UserId, b.BrokerId, time.Now()))
+	o.RemainingAmount, o.FilledAmount, o.FilledFunds = number.NewInteger(20, 1), number.NewInteger(10, 1), number.NewInteger(100000, 3)
+	b.RemainingFunds, b.FilledAmount, b.FilledFunds = number.NewInteger(0, 3), number.NewInteger(10, 1), number.NewInteger(100000, 3)
+	_, transfers, err := Transact(ctx, b, o, number.NewInteger(10, 1), TakerFeeRate, MakerFeeRate, "0")
+	assert.Nil(err)
+	assert.Len(transfers, 2)
+	actions, err := ListPendingActions(ctx, time.Time{}, 10)
+	assert.Nil(err)
+	assert.Len(actions, 1)
+	assert.Equal("1", actions[0].Order.DisplayAmount)
+}
+
+func TestSQLAmendAndDecrement(t *testing.T) {
+	ctx := testSetupSQLStore(t)
+	assert := assert.New(t)
+
+	base, quote, user, broker := testUUID(), testUUID(), testUUID(), testUUID()
+	bid := testEngineOrder(engine.PageSideBid, base, quote, 10000, 50, user, broker)
+	err := CreateOrderAction(ctx, bid, user, broker, time.Now())
+	assert.Nil(err)
+
+	err = AmendOrderAction(ctx, bid.Id, "90", "300", time.Now(), user)
+	assert.Nil(err)
+	err = AmendOrderAction(ctx, bid.Id, "95", "200", time.Now(), user)
+	assert.Nil(err)
+	actions, _ := ListPendingActions(ctx, time.Time{}, 10)
+	assert.Len(actions, 2)
+	assert.Equal(engine.OrderActionAmend, actions[1].Action)
+	assert.Equal("95", actions[1].Price)
+	assert.Equal("200", actions[1].Amount)
+
+	bid.Price = number.NewInteger(9500, 2)
+	bid.RemainingFunds = number.NewInteger(200000, 3)
+	err = AmendOrder(ctx, bid, number.NewInteger(0, 1), number.NewInteger(300000, 3))
+	assert.Nil(err)
+	opponent := testEngineOrder(engine.PageSideAsk, base, quote, 9500, 10, testUUID(), broker)
+	bid.RemainingFunds = number.NewInteger(150000, 3)
+	err = DecrementOrder(ctx, bid, opponent, number.NewInteger(0, 1), number.NewInteger(50000, 3))
+	assert.Nil(err)
+
+	actions, _ = ListPendingActions(ctx, time.Time{}, 10)
+	assert.Equal("95", actions[0].Order.Price)
+	assert.Equal("150", actions[0].Order.RemainingFunds)
+	transfers, _ := ListPendingTransfers(ctx, broker, 10)
+	assert.Len(transfers, 2)
+	assert.Equal(quote, transfers[0].AssetId)
+	assert.Equal("300", transfers[0].Amount)
+	assert.Equal("50", transfers[1].Amount)
+
+	err = CancelOrderAction(ctx, bid.Id, time.Now(), user)
+	assert.Nil(err)
+	err = AmendOrderAction(ctx, bid.Id, "96", "100", time.Now(), user)
+	assert.Nil(err)
+	actions, _ = ListPendingActions(ctx, time.Time{}, 10)
+	assert.Len(actions, 3)
+	assert.Equal("95", actions[1].Price)
+}
+
+func TestSQLUsersPropertiesSnapshots(t *testing.T) {
+	ctx := testSetupSQLStore(t)
+	assert := assert.New(t)
+
+	offset := time.Unix(1500000000, 123456000)
+	err := WriteTimeProperty(ctx, "checkpoint", offset)
+	assert.Nil(err)
+	err = WriteTimeProperty(ctx, "checkpoint", offset.Add(time.Second))
+	assert.Nil(err)
+	checkpoint, err := ReadPropertyAsTime(ctx, "checkpoint")
+	assert.Nil(err)
+	assert.True(offset.Add(time.Second).Equal(checkpoint))
+	value, err := ReadProperty(ctx, "missing")
+	assert.Nil(err)
+	assert.Equal("", value)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pkix, _ := x509.MarshalPKIXPublicKey(key.Public())
+	userId := testUUID()
+	err = UpdateUserPublicKey(ctx, userId, hex.EncodeToString(pkix))
+	assert.Nil(err)
+	err = UpdateUserPublicKey(ctx, testUUID(), hex.EncodeToString(pkix))
+	assert.Nil(err)
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"uid": userId}).SignedString(key)
+	id, err := Authenticate(ctx, token)
+	assert.Nil(err)
+	assert.Equal(userId, id)
+	token, _ = jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"uid": testUUID()}).SignedString(key)
+	id, err = Authenticate(ctx, token)
+	assert.Nil(err)
+	assert.Equal("", id)
+
+	brokers, err := AllBrokers(ctx, false)
+	assert.Nil(err)
+	assert.Len(brokers, 1)
+	err = CurrentStore(ctx).CreateBroker(ctx, &Broker{BrokerId: testUUID(), EncryptionHeader: []byte{1, 2}, CreatedAt: time.Now()})
+	assert.Nil(err)
+	brokers, err = AllBrokers(ctx, false)
+	assert.Nil(err)
+	assert.Len(brokers, 2)
+	assert.Equal([]byte{1, 2}, brokers[1].EncryptionHeader)
+
+	market := testUUID() + "-" + testUUID()
+	err = WriteBookSnapshots(ctx, offset, map[string]*engine.Snapshot{market: &engine.Snapshot{LastPrice: number.NewInteger(12345, 2)}})
+	assert.Nil(err)
+	err = WriteBookSnapshots(ctx, checkpoint, map[string]*engine.Snapshot{market: &engine.Snapshot{LastPrice: number.NewInteger(12346, 2)}})
+	assert.Nil(err)
+	snapshots, err := ReadBookSnapshots(ctx)
+	assert.Nil(err)
+	assert.Len(snapshots, 1)
+	assert.True(checkpoint.Equal(snapshots[0].Checkpoint))
+	state, err := snapshots[0].State()
+	assert.Nil(err)
+	assert.Equal("123.46", state.LastPrice)
+}
+
+func TestSQLMarkets(t *testing.T) {
+	ctx := testSetupSQLStore(t)
+	assert := assert.New(t)
+
+	base, quote := testUUID(), testUUID()
+	market := &Market{BaseAssetId: base, QuoteAssetId: quote, PricePrecision: 4, AmountPrecision: 4, MinimumFunds: "1", TickSize: "0.0001", LotSize: "0.0001", Status: MarketStatusActive}
+	assert.NotNil(WriteMarket(ctx, &Market{BaseAssetId: base, QuoteAssetId: base, PricePrecision: 4, TickSize: "1", Status: MarketStatusActive}))
+	assert.NotNil(WriteMarket(ctx, &Market{BaseAssetId: base, QuoteAssetId: quote, PricePrecision: 4, TickSize: "0.00001", Status: MarketStatusActive}))
+	assert.NotNil(WriteMarket(ctx, &Market{BaseAssetId: base, QuoteAssetId: quote, PricePrecision: 4, TickSize: "0.0001", Status: "UNKNOWN"}))
+	assert.NotNil(WriteMarket(ctx, &Market{BaseAssetId: base, QuoteAssetId: quote, PricePrecision: 9, TickSize: "0.0001", Status: MarketStatusActive}))
+	assert.NotNil(WriteMarket(ctx, &Market{BaseAssetId: base, QuoteAssetId: quote, PricePrecision: 4, AmountPrecision: 2, TickSize: "0.0001", LotSize: "0.001", Status: MarketStatusActive}))
+	assert.Nil(WriteMarket(ctx, market))
+
+	m, err := ReadMarket(ctx, base+"-"+quote)
+	assert.Nil(err)
+	assert.NotNil(m)
+	assert.Equal(int64(4), m.PricePrecision)
+	assert.Equal("0.0001", m.TickSize)
+	assert.Equal(MarketStatusActive, m.Status)
+	createdAt := m.CreatedAt
+
+	m.Status, m.TickSize, m.LotSize = MarketStatusHalted, "0.0100", "0.10"
+	assert.Nil(WriteMarket(ctx, m))
+	m.PricePrecision = 8
+	assert.NotNil(WriteMarket(ctx, m))
+	markets, err := AllMarkets(ctx)
+	assert.Nil(err)
+	assert.Len(markets, 1)
+	assert.Equal(MarketStatusActive, markets[0].Status)
+
+	_, err = UpdateMarketStatus(ctx, base+"-"+quote, MarketStatusHalted, "", "maintenance")
+	assert.NotNil(err)
+	_, err = UpdateMarketStatus(ctx, base+"-"+quote, "CLOSED", "operator", "maintenance")
+	assert.NotNil(err)
+	_, err = UpdateMarketStatus(ctx, quote+"-"+base, MarketStatusHalted, "operator", "maintenance")
+	assert.NotNil(err)
+	m, err = UpdateMarketStatus(ctx, base+"-"+quote, MarketStatusHalted, "operator", "maintenance")
+	assert.Nil(err)
+	assert.Equal(MarketStatusHalted, m.Status)
+	_, err = UpdateMarketStatus(ctx, base+"-"+quote, MarketStatusCancelOnly, "operator", "deposits resumed")
+	assert.Nil(err)
+	audits, err := MarketAudits(ctx, base+"-"+quote, 10)
+	assert.Nil(err)
+	assert.Len(audits, 2)
+	assert.Equal(MarketStatusHalted, audits[0].PreviousStatus)
+	assert.Equal(MarketStatusCancelOnly, audits[0].Status)
+	assert.Equal("deposits resumed", audits[0].Reason)
+	assert.Equal(MarketStatusActive, audits[1].PreviousStatus)
+	assert.Equal("operator", audits[1].Operator)
+	markets, err = AllMarkets(ctx)
+	assert.Nil(err)
+	assert.Equal(MarketStatusCancelOnly, markets[0].Status)
+	assert.Equal("0.01", markets[0].TickSize)
+	assert.Equal("0.1", markets[0].LotSize)
+	assert.Equal(int64(4), markets[0].PricePrecision)
+	assert.True(createdAt.Equal(markets[0].CreatedAt))
+	m, err = ReadMarket(ctx, quote+"-"+base)
+	assert.Nil(err)
+	assert.Nil(m)
+}
+
+func TestSQLFeeTiers(t *testing.T) {
+	ctx := testSetupSQLStore(t)
+	assert := assert.New(t)
+
+	market, broker := testUUID()+"-"+testUUID(), testUUID()
+	assert.NotNil(WriteFeeTier(ctx, &FeeTier{Market: "market", MakerFeeRate: "0", TakerFeeRate: "0.001"}))
+	assert.NotNil(WriteFeeTier(ctx, &FeeTier{BrokerId: "broker", MakerFeeRate: "0", TakerFeeRate: "0.001"}))
+	assert.NotNil(WriteFeeTier(ctx, &FeeTier{MinimumVolume: "-1", MakerFeeRate: "0", TakerFeeRate: "0.001"}))
+	assert.NotNil(WriteFeeTier(ctx, &FeeTier{MakerFeeRate: "0", TakerFeeRate: "0.2"}))
+	assert.NotNil(WriteFeeTier(ctx, &FeeTier{MakerFeeRate: "-0.002", TakerFeeRate: "0.001"}))
+	assert.Nil(WriteFeeTier(ctx, &FeeTier{MakerFeeRate: "0.001", TakerFeeRate: "0.002"}))
+	assert.Nil(WriteFeeTier(ctx, &FeeTier{MinimumVolume: "1000", MakerFeeRate: "0.0005", TakerFeeRate: "0.0015"}))
+	assert.Nil(WriteFeeTier(ctx, &FeeTier{Market: market, MinimumVolume: "0", MakerFeeRate: "0.0000", TakerFeeRate: "0.001"}))
+	assert.Nil(WriteFeeTier(ctx, &FeeTier{Market: market, MinimumVolume: "100.0", MakerFeeRate: "-0.0001", TakerFeeRate: "0.001"}))
+	assert.Nil(WriteFeeTier(ctx, &FeeTier{BrokerId: broker, MinimumVolume: "10", MakerFeeRate: "0", TakerFeeRate: "0.0005"}))
+	assert.Nil(WriteFeeTier(ctx, &FeeTier{Market: market, MinimumVolume: "100", MakerFeeRate: "-0.0002", TakerFeeRate: "0.001"}))
+
+	tiers, err := AllFeeTiers(ctx)
+	assert.Nil(err)
+	assert.Len(tiers, 5)
+	tier := MatchFeeTier(tiers, market, "", number.FromString("150"))
+	assert.Equal("-0.0002", tier.Rate(TradeLiquidityMaker))
+	assert.Equal("0.001", tier.Rate(TradeLiquidityTaker))
+	assert.Equal("0", MatchFeeTier(tiers, market, "", number.FromString("99")).MakerFeeRate)
+	assert.Equal("0.0015", MatchFeeTier(tiers, testUUID()+"-"+testUUID(), "", number.FromString("1000")).TakerFeeRate)
+	assert.Equal("0.002", MatchFeeTier(tiers, testUUID()+"-"+testUUID(), testUUID(), number.FromString("999")).TakerFeeRate)
+	assert.Equal("0.0005", MatchFeeTier(tiers, market, broker, number.FromString("10")).TakerFeeRate)
+	assert.Equal("0.001", MatchFeeTier(tiers, market, broker, number.FromString("9")).TakerFeeRate)
+	assert.Nil(MatchFeeTier(tiers[:0], market, broker, number.FromString("9")))
+
+	assert.Nil(RemoveFeeTier(ctx, market, "", "100.00"))
+	tiers, _ = AllFeeTiers(ctx)
+	assert.Len(tiers, 4)
+	assert.Equal("0", MatchFeeTier(tiers, market, "", number.FromString("150")).MakerFeeRate)
+}
+
+func TestSQLFeePayouts(t *testing.T) {
+	ctx := testSetupSQLStore(t)
+	assert := assert.New(t)
+
+	base, quote, treasury := testUUID(), testUUID(), testUUID()
+	askBroker, bidBroker := testUUID(), testUUID()
+	for i := 0; i < 6; i++ {
+		ask := testEngineOrder(engine.PageSideAsk, base, quote, 10000, 10, testUUID(), askBroker)
+		bid := testEngineOrder(engine.PageSideBid, base, quote, 10000, 10, testUUID(), bidBroker)
+		takerFeeRate, makerFeeRate := "0", "-0.0005"
+		if i >= 3 {
+			takerFeeRate, makerFeeRate = TakerFeeRate, "0"
+		}
+		_, _, err := Transact(ctx, bid, ask, number.NewInteger(10, 1), takerFeeRate, makerFeeRate, "0")
+		assert.Nil(err)
+	}
+	entries, err := ListUnpaidFees(ctx, "", 10)
+	assert.Nil(err)
+	assert.Len(entries, 6)
+	transfers, _ := ListPendingTransfers(ctx, askBroker, 10)
+	ExpireTransfers(ctx, transfers)
+	transfers, _ = ListPendingTransfers(ctx, bidBroker, 10)
+	ExpireTransfers(ctx, transfers)
+
+	payouts, err := PayoutFees(ctx, treasury, 3)
+	assert.Nil(err)
+	assert.Len(payouts, 1)
+	assert.Equal(bidBroker, payouts[0].BrokerId)
+	assert.Equal(quote, payouts[0].AssetId)
+	assert.Equal("0", payouts[0].Amount)
+	assert.Equal(int64(3), payouts[0].Entries)
+	transfers, _ = ListPendingTransfers(ctx, bidBroker, 10)
+	assert.Len(transfers, 0)
+	entries, _ = ListUnpaidFees(ctx, "", 10)
+	assert.Len(entries, 4)
+	carry := entries[3]
+	assert.Equal(FeeLiquidityCarry, carry.Liquidity)
+	assert.Equal(payouts[0].PayoutId, carry.TradeId)
+	assert.Equal(bidBroker, carry.BrokerId)
+	assert.Equal(quote, carry.AssetId)
+	assert.Equal("-0.15", carry.Amount)
+
+	payouts, err = PayoutFees(ctx, treasury, 3)
+	assert.Nil(err)
+	assert.Len(payouts, 1)
+	assert.Equal(askBroker, payouts[0].BrokerId)
+	assert.Equal(base, payouts[0].AssetId)
+	assert.Equal("0.003", payouts[0].Amount)
+	assert.Equal(int64(3), payouts[0].Entries)
+	transfers, err = ListPendingTransfers(ctx, askBroker, 10)
+	assert.Nil(err)
+	assert.Len(transfers, 1)
+	assert.Equal(TransferSourceFeePayout, transfers[0].Source)
+	assert.Equal(payouts[0].PayoutId, transfers[0].Detail)
+	assert.Equal(treasury, transfers[0].UserId)
+	assert.Equal("0.003", transfers[0].Amount)
+	payouts, err = PayoutFees(ctx, treasury, 3)
+	assert.Nil(err)
+	assert.Len(payouts, 0)
+
+	for i := 0; i < 2; i++ {
+		ask := testEngineOrder(engine.PageSideAsk, base, quote, 10000, 10, testUUID(), askBroker)
+		bid := testEngineOrder(engine.PageSideBid, base, quote, 10000, 10, testUUID(), bidBroker)
+		_, _, err := Transact(ctx, ask, bid, number.NewInteger(10, 1), TakerFeeRate, "0", "0")
+		assert.Nil(err)
+	}
+	payouts, err = PayoutFees(ctx, treasury, 2)
+	assert.Nil(err)
+	assert.Len(payouts, 1)
+	assert.Equal("0", payouts[0].Amount)
+	entries, _ = ListUnpaidFees(ctx, "", 10)
+	assert.Len(entries, 2)
+	assert.Equal("0.1", entries[0].Amount)
+	assert.Equal("-0.05", entries[1].Amount)
+	payouts, err = PayoutFees(ctx, treasury, 10)
+	assert.Nil(err)
+	assert.Len(payouts, 1)
+	assert.Equal(bidBroker, payouts[0].BrokerId)
+	assert.Equal(quote, payouts[0].AssetId)
+	assert.Equal("0.05", payouts[0].Amount)
+	transfers, _ = ListPendingTransfers(ctx, bidBroker, 10)
+	assert.Len(transfers, 3)
+	assert.Equal("0.05", transfers[2].Amount)
+	entries, _ = ListUnpaidFees(ctx, "", 10)
+	assert.Len(entries, 0)
+
+	reports, err := BeneficiaryFeeReports(ctx, "", 10)
+	assert.Nil(err)
+	assert.Len(reports, 2)
+	assert.NotNil(CurrentStore(ctx).CreateFeePayout(ctx, &FeePayout{PayoutId: testUUID(), CreatedAt: time.Now()}, []*FeeEntry{{TradeId: testUUID(), Liquidity: TradeLiquidityMaker}}, &Transfer{TransferId: testUUID(), CreatedAt: time.Now()}, nil))
+}
+
+func TestSQLBrokerFeeShares(t *testing.T) {
+	ctx := testSetupSQLStore(t)
+	assert := assert.New(t)
+
+	base, quote, payout := testUUID(), testUUID(), testUUID()
+	askBroker, bidBroker := testUUID(), testUUID()
+	assert.NotNil(WriteBrokerFeeShare(ctx, &BrokerFeeShare{BrokerId: "broker", ShareRate: "0.2", PayoutUserId: payout}))
+	assert.NotNil(WriteBrokerFeeShare(ctx, &BrokerFeeShare{BrokerId: bidBroker, ShareRate: "0.2", PayoutUserId: "user"}))
+	assert.NotNil(WriteBrokerFeeShare(ctx, &BrokerFeeShare{BrokerId: bidBroker, ShareRate: "1.1", PayoutUserId: payout}))
+	assert.NotNil(WriteBrokerFeeShare(ctx, &BrokerFeeShare{BrokerId: bidBroker, ShareRate: "-0.1", PayoutUserId: payout}))
+	assert.Nil(WriteBrokerFeeShare(ctx, &BrokerFeeShare{BrokerId: bidBroker, ShareRate: "0.5", PayoutUserId: testUUID()}))
+	assert.Nil(WriteBrokerFeeShare(ctx, &BrokerFeeShare{BrokerId: bidBroker, ShareRate: "0.25", PayoutUserId: payout}))
+	shares, err := AllBrokerFeeShares(ctx)
+	assert.Nil(err)
+	assert.Len(shares, 1)
+	assert.Equal("0.25", shares[0].ShareRate)
+	assert.Equal(payout, shares[0].PayoutUserId)
+
+	for i := 0; i < 2; i++ {
+		ask := testEngineOrder(engine.PageSideAsk, base, quote, 10000, 10, testUUID(), askBroker)
+		bid := testEngineOrder(engine.PageSideBid, base, quote, 10000, 10, testUUID(), bidBroker)
+		_, _, err := Transact(ctx, bid, ask, number.NewInteger(10, 1), TakerFeeRate, "0", "0.25")
+		assert.Nil(err)
+	}
+	entries, err := ListUnpaidFees(ctx, "", 10)
+	assert.Nil(err)
+	assert.Len(entries, 2)
+	assert.Equal("0.00075", entries[0].Amount)
+	entries, err = ListUnpaidFees(ctx, bidBroker, 10)
+	assert.Nil(err)
+	assert.Len(entries, 2)
+	assert.Equal(askBroker, entries[0].BrokerId)
+	assert.Equal(base, entries[0].AssetId)
+	assert.Equal("0.00025", entries[0].Amount)
+	transfers, _ := ListPendingTransfers(ctx, askBroker, 10)
+	ExpireTransfers(ctx, transfers)
+
+	payouts, err := PayoutFees(ctx, "", 10)
+	assert.Nil(err)
+	assert.Len(payouts, 1)
+	assert.Equal(bidBroker, payouts[0].BeneficiaryId)
+	assert.Equal(payout, payouts[0].UserId)
+	assert.Equal("0.0005", payouts[0].Amount)
+	transfers, err = ListPendingTransfers(ctx, askBroker, 10)
+	assert.Nil(err)
+	assert.Len(transfers, 1)
+	assert.Equal(TransferSourceFeePayout, transfers[0].Source)
+	assert.Equal(payout, transfers[0].UserId)
+	entries, _ = ListUnpaidFees(ctx, bidBroker, 10)
+	assert.Len(entries, 0)
+	entries, _ = ListUnpaidFees(ctx, "", 10)
+	assert.Len(entries, 2)
+
+	reports, err := BeneficiaryFeeReports(ctx, bidBroker, 10)
+	assert.Nil(err)
+	assert.Len(reports, 1)
+	assert.Equal(base, reports[0].AssetId)
+	assert.Equal("0.0005", reports[0].Paid)
+	assert.Equal("0", reports[0].Unpaid)
+	reports, err = BeneficiaryFeeReports(ctx, "", 10)
+	assert.Nil(err)
+	assert.Len(reports, 1)
+	assert.Equal("0", reports[0].Paid)
+	assert.Equal("0.0015", reports[0].Unpaid)
+}
+
+func TestSQLRebind(t *testing.T) {
+	assert := assert.New(t)
+
+	query := "UPDATE orders SET state=? WHERE order_id IN (?,?)"
+	postgres := &sqlStore{dialect: SQLDialectPostgres}
+	assert.Equal("UPDATE orders SET state=$1 WHERE order_id IN ($2,$3)", postgres.rebind(query))
+	sqlite := &sqlStore{dialect: SQLDialectSQLite}
+	assert.Equal(query, sqlite.rebind(query))
+}
+
+func testSetupSQLStore(t *testing.T) context.Context {
+	schema, err := ioutil.ReadFile("schema_sql.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, _ := testSetupSQLSchema(t, string(schema))
+	return ctx
+}
+
+func testSetupSQLSchema(t *testing.T, schemas ...string) (context.Context, *sql.DB) {
+	db, err := sql.Open(SQLDialectSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	for _, schema := range schemas {
+		_, err = db.Exec(schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return SetupStore(context.Background(), NewSQLStore(db, SQLDialectSQLite)), db
+}
+
+// testLegacySchema drops the columns added by the migrations from the current schema
+func testLegacySchema(t *testing.T, schema, migrations string) string {
+	columns := make(map[string]bool)
+	for _, line := range strings.Split(migrations, "\n") {
+		var table, column string
+		n, _ := fmt.Sscanf(line, "ALTER TABLE %s ADD COLUMN %s", &table, &column)
+		if n == 2 {
+			columns[table+"."+column] = true
+		}
+	}
+	var table string
+	var lines []string
+	for _, line := range strings.Split(schema, "\n") {
+		if strings.HasPrefix(line, "CREATE TABLE ") {
+			table = strings.Fields(line)[2]
+		}
+		if fields := strings.Fields(line); len(fields) > 0 && columns[table+"."+fields[0]] {
+			delete(columns, table+"."+fields[0])
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(columns) > 0 {
+		t.Fatal("unknown migrated columns", columns)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func testEngineOrder(side, base, quote string, price, amount int64, userId, brokerId string) *engine.Order {
+	order := &engine.Order{
+		Id:              testUUID(),
+		Side:            side,
+		Type:            engine.OrderTypeLimit,
+		Price:           number.NewInteger(price, 2),
+		RemainingAmount: number.NewInteger(0, 1),
+		FilledAmount:    number.NewInteger(0, 1),
+		RemainingFunds:  number.NewInteger(0, 3),
+		FilledFunds:     number.NewInteger(0, 3),
+		TriggerPrice:    number.NewInteger(0, 2),
+		DisplayAmount:   number.NewInteger(0, 1),
+		TimeInForce:     engine.TimeInForceGTC,
+		Quote:           quote,
+		Base:            base,
+		UserId:          userId,
+		BrokerId:        brokerId,
+	}
+	if side == engine.PageSideAsk {
+		order.RemainingAmount = number.NewInteger(amount, 1)
+	} else {
+		order.RemainingFunds = order.Price.Mul(number.NewInteger(amount, 1))
+	}
+	return order
+}
+
+func testUUID() string {
+	id, _ := uuid.NewV4()
+	return id.String()
+}
@@ -0,0 +1,60 @@
+package persistence
+
+import (
+	"context"
+	"time"
+
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/engine"
+)
+
+type Store interface {
+	CountPendingActions(ctx context.Context) (int64, error)
+	ListPendingActions(ctx context.Context, checkpoint time.Time, limit int) ([]*Action, error)
+	CreateOrderAction(ctx context.Context, order *Order, action *Action) error
+	CancelOrderAction(ctx context.Context, action *Action, userId string) error
+	AmendOrderAction(ctx context.Context, action *Action, userId string) error
+
+	ReadOrder(ctx context.Context, orderId string) (*Order, error)
+	UserOrders(ctx context.Context, userId, base, quote, state string, offset time.Time, order string, limit int) ([]*Order, error)
+	CancelOrder(ctx context.Context, order *engine.Order, transfer *Transfer) error
+	DecrementOrder(ctx context.Context, order *engine.Order, transfer *Transfer) error
+	AmendOrder(ctx context.Context, order *engine.Order, transfer *Transfer) error
+	TriggerOrder(ctx context.Context, orderId string) error
+
+	Transact(ctx context.Context, taker, maker *engine.Order, trades []*Trade, transfers []*Transfer, fees []*FeeEntry) error
+	LastTrade(ctx context.Context, base, quote string) (*Trade, error)
+	MarketTrades(ctx context.Context, base, quote string, offset time.Time, order string, limit int) ([]*Trade, error)
+	UserTradeVolume(ctx context.Context, userId, base, quote string, since time.Time) (number.Decimal, error)
+
+	CountPendingTransfers(ctx context.Context) (int64, error)
+	ListPendingTransfers(ctx context.Context, broker string, limit int) ([]*Transfer, error)
+	ExpireTransfers(ctx context.Context, transfers []*Transfer) error
+	ReadTransferTrade(ctx context.Context, tradeId, assetId string) (*Trade, error)
+	CreateTransfer(ctx context.Context, transfer *Transfer) error
+
+	ReadBrokers(ctx context.Context) ([]*Broker, error)
+	CreateBroker(ctx context.Context, broker *Broker) error
+
+	ReadMarkets(ctx context.Context) ([]*Market, error)
+	WriteMarket(ctx context.Context, market *Market, audit *MarketAudit) error
+	ReadMarketAudits(ctx context.Context, base, quote string, limit int) ([]*MarketAudit, error)
+
+	ReadFeeTiers(ctx context.Context) ([]*FeeTier, error)
+	WriteFeeTier(ctx context.Context, tier *FeeTier) error
+	DeleteFeeTier(ctx context.Context, market, brokerId, minimumVolume string) error
+	ReadBrokerFeeShares(ctx context.Context) ([]*BrokerFeeShare, error)
+	WriteBrokerFeeShare(ctx context.Context, share *BrokerFeeShare) error
+	ListUnpaidFees(ctx context.Context, beneficiaryId string, limit int) ([]*FeeEntry, error)
+	CreateFeePayout(ctx context.Context, payout *FeePayout, entries []*FeeEntry, transfer *Transfer, carry *FeeEntry) error
+	ReadFeePayouts(ctx context.Context, beneficiaryId string) ([]*FeePayout, error)
+
+	ReadProperty(ctx context.Context, key string) (string, error)
+	WriteProperty(ctx context.Context, key, value string) error
+
+	ReadUserPublicKey(ctx context.Context, userId string) (string, error)
+	UpdateUserPublicKey(ctx context.Context, userId, publicKey string) error
+
+	WriteBookSnapshots(ctx context.Context, snapshots []*BookSnapshot) error
+	ReadBookSnapshots(ctx context.Context) ([]*BookSnapshot, error)
+}
@@ -6,7 +6,6 @@ import (
 	"io"
 	"time"
 
-	"cloud.google.com/go/spanner"
 	"github.com/MixinNetwork/go-number"
 	"github.com/MixinNetwork/ocean.one/engine"
 	"github.com/satori/go.uuid"
@@ -34,46 +33,20 @@ type Trade struct {
 	UserId       string    `spanner:"user_id"`
 	FeeAssetId   string    `spanner:"fee_asset_id"`
 	FeeAmount    string    `spanner:"fee_amount"`
+	FeeRate      string    `spanner:"fee_rate"`
 }
 
-func Transact(ctx context.Context, taker, maker *engine.Order, amount number.Integer) (string, error) {
+func Transact(ctx context.Context, taker, maker *engine.Order, amount number.Integer, takerFeeRate, makerFeeRate, brokerShareRate string) ([]*Trade, []*Transfer, error) {
 	askTrade, bidTrade := makeTrades(taker, maker, amount.Decimal())
-	askTransfer, bidTransfer := handleFees(askTrade, bidTrade, taker, maker)
-
-	askTradeMutation, err := spanner.InsertStruct("trades", askTrade)
-	if err != nil {
-		return "", err
-	}
-	bidTradeMutation, err := spanner.InsertStruct("trades", bidTrade)
-	if err != nil {
-		return "", err
-	}
-
-	askTransferMutation, err := spanner.InsertStruct("transfers", askTransfer)
-	if err != nil {
-		return "", err
-	}
-	bidTransferMutation, err := spanner.InsertStruct("transfers", bidTransfer)
-	if err != nil {
-		return "", err
-	}
-
-	mutations := makeOrderMutations(taker, maker)
-	mutations = append(mutations, askTradeMutation, bidTradeMutation)
-	mutations = append(mutations, askTransferMutation, bidTransferMutation)
-	_, err = Spanner(ctx).Apply(ctx, mutations)
-	return askTrade.TradeId, err
+	askTransfer, bidTransfer := handleFees(askTrade, bidTrade, taker, maker, takerFeeRate, makerFeeRate)
+	trades := []*Trade{askTrade, bidTrade}
+	transfers := []*Transfer{askTransfer, bidTransfer}
+	fees := makeFeeEntries(trades, transfers, taker.BrokerId, brokerShareRate)
+	err := CurrentStore(ctx).Transact(ctx, taker, maker, trades, transfers, fees)
+	return trades, transfers, err
 }
 
-func CancelOrder(ctx context.Context, order *engine.Order) error {
-	orderCols := []string{"order_id", "filled_amount", "remaining_amount", "filled_funds", "remaining_funds", "state"}
-	orderVals := []interface{}{order.Id, order.FilledAmount.Persist(), order.RemainingAmount.Persist(), order.FilledFunds.Persist(), order.RemainingFunds.Persist(), OrderStateDone}
-	mutations := []*spanner.Mutation{
-		spanner.Update("orders", orderCols, orderVals),
-		spanner.Delete("actions", spanner.Key{order.Id, engine.OrderActionCreate}),
-		spanner.Delete("actions", spanner.Key{order.Id, engine.OrderActionCancel}),
-	}
-
+func CancelOrder(ctx context.Context, order *engine.Order) (*Transfer, error) {
 	transfer := &Transfer{
 		TransferId: getSettlementId(order.Id, engine.OrderActionCancel),
 		Source:     TransferSourceOrderCancelled,
@@ -88,46 +61,60 @@ func CancelOrder(ctx context.Context, order *engine.Order) error {
 		transfer.AssetId = order.Quote
 		transfer.Amount = order.RemainingFunds.Persist()
 	}
-	transferMutation, err := spanner.InsertStruct("transfers", transfer)
-	if err != nil {
-		return err
-	}
-	mutations = append(mutations, transferMutation)
-	_, err = Spanner(ctx).Apply(ctx, mutations)
-	return err
+	return transfer, CurrentStore(ctx).CancelOrder(ctx, order, transfer)
 }
 
-func makeOrderMutations(taker, maker *engine.Order) []*spanner.Mutation {
-	takerOrderCols := []string{"order_id", "filled_amount", "remaining_amount", "filled_funds", "remaining_funds"}
-	takerOrderVals := []interface{}{taker.Id, taker.FilledAmount.Persist(), taker.RemainingAmount.Persist(), taker.FilledFunds.Persist(), taker.RemainingFunds.Persist()}
-	makerOrderCols := []string{"order_id", "filled_amount", "remaining_amount", "filled_funds", "remaining_funds"}
-	makerOrderVals := []interface{}{maker.Id, maker.FilledAmount.Persist(), maker.RemainingAmount.Persist(), maker.FilledFunds.Persist(), maker.RemainingFunds.Persist()}
-	if taker.RemainingAmount.IsZero() && taker.RemainingFunds.IsZero() {
-		takerOrderCols = append(takerOrderCols, "state")
-		takerOrderVals = append(takerOrderVals, OrderStateDone)
+func DecrementOrder(ctx context.Context, order, opponent *engine.Order, amount, funds number.Integer) error {
+	transfer := &Transfer{
+		TransferId: getSettlementId(order.Id, opponent.Id),
+		Source:     TransferSourceOrderCancelled,
+		Detail:     order.Id,
+		AssetId:    order.Base,
+		Amount:     amount.Persist(),
+		CreatedAt:  time.Now(),
+		UserId:     order.UserId,
+		BrokerId:   order.BrokerId,
 	}
-	if maker.RemainingAmount.IsZero() && maker.RemainingFunds.IsZero() {
-		makerOrderCols = append(makerOrderCols, "state")
-		makerOrderVals = append(makerOrderVals, OrderStateDone)
+	if order.Side == engine.PageSideBid {
+		transfer.AssetId = order.Quote
+		transfer.Amount = funds.Persist()
 	}
-	mutations := []*spanner.Mutation{
-		spanner.Update("orders", takerOrderCols, takerOrderVals),
-		spanner.Update("orders", makerOrderCols, makerOrderVals),
+	return CurrentStore(ctx).DecrementOrder(ctx, order, transfer)
+}
+
+func AmendOrder(ctx context.Context, order *engine.Order, amount, funds number.Integer) error {
+	if amount.IsZero() && funds.IsZero() {
+		return CurrentStore(ctx).AmendOrder(ctx, order, nil)
 	}
 
-	if taker.RemainingAmount.IsZero() && taker.RemainingFunds.IsZero() {
-		mutations = append(mutations, spanner.Delete("actions", spanner.Key{taker.Id, engine.OrderActionCreate}))
-		mutations = append(mutations, spanner.Delete("actions", spanner.Key{taker.Id, engine.OrderActionCancel}))
+	transfer := &Transfer{
+		TransferId: getSettlementId(order.Id, engine.OrderActionAmend+order.RemainingAmount.Persist()),
+		Source:     TransferSourceOrderCancelled,
+		Detail:     order.Id,
+		AssetId:    order.Base,
+		Amount:     amount.Persist(),
+		CreatedAt:  time.Now(),
+		UserId:     order.UserId,
+		BrokerId:   order.BrokerId,
 	}
-	if maker.RemainingAmount.IsZero() && maker.RemainingFunds.IsZero() {
-		mutations = append(mutations, spanner.Delete("actions", spanner.Key{maker.Id, engine.OrderActionCreate}))
-		mutations = append(mutations, spanner.Delete("actions", spanner.Key{maker.Id, engine.OrderActionCancel}))
+	if order.Side == engine.PageSideBid {
+		transfer.TransferId = getSettlementId(order.Id, engine.OrderActionAmend+order.RemainingFunds.Persist())
+		transfer.AssetId = order.Quote
+		transfer.Amount = funds.Persist()
 	}
-	return mutations
+	return CurrentStore(ctx).AmendOrder(ctx, order, transfer)
+}
+
+func TriggerOrder(ctx context.Context, order *engine.Order) error {
+	return CurrentStore(ctx).TriggerOrder(ctx, order.Id)
 }
 
 func makeTrades(taker, maker *engine.Order, amount number.Decimal) (*Trade, *Trade) {
 	tradeId := getSettlementId(taker.Id, maker.Id)
+	if !maker.DisplayAmount.IsZero() {
+		// each replenished slice of an iceberg order may match the same taker again
+		tradeId = getSettlementId(taker.Id, maker.Id+maker.FilledAmount.Persist())
+	}
 	askOrderId, bidOrderId := taker.Id, maker.Id
 	if taker.Side == engine.PageSideBid {
 		askOrderId, bidOrderId = maker.Id, taker.Id
@@ -168,19 +155,21 @@ func makeTrades(taker, maker *engine.Order, amount number.Decimal) (*Trade, *Tra
 	return askTrade, bidTrade
 }
 
-func handleFees(ask, bid *Trade, taker, maker *engine.Order) (*Transfer, *Transfer) {
-	total := number.FromString(ask.Amount).Mul(number.FromString(ask.Price))
-	askFee := total.Mul(number.FromString(TakerFeeRate))
-	bidFee := number.FromString(bid.Amount).Mul(number.FromString(MakerFeeRate))
+func handleFees(ask, bid *Trade, taker, maker *engine.Order, takerFeeRate, makerFeeRate string) (*Transfer, *Transfer) {
+	askRate, bidRate := number.FromString(takerFeeRate), number.FromString(makerFeeRate)
 	if ask.Liquidity == TradeLiquidityMaker {
-		askFee = total.Mul(number.FromString(MakerFeeRate))
-		bidFee = number.FromString(bid.Amount).Mul(number.FromString(TakerFeeRate))
+		askRate, bidRate = bidRate, askRate
 	}
+	total := number.FromString(ask.Amount).Mul(number.FromString(ask.Price))
+	askFee := total.Mul(askRate)
+	bidFee := number.FromString(bid.Amount).Mul(bidRate)
 
 	ask.FeeAssetId = ask.QuoteAssetId
 	ask.FeeAmount = askFee.Persist()
+	ask.FeeRate = askRate.Persist()
 	bid.FeeAssetId = bid.BaseAssetId
 	bid.FeeAmount = bidFee.Persist()
+	bid.FeeRate = bidRate.Persist()
 
 	askTransfer := &Transfer{
 		TransferId: getSettlementId(ask.TradeId, ask.Liquidity),
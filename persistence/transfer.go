@@ -2,12 +2,9 @@ package persistence
 
 import (
 	"context"
-	"fmt"
 	"time"
 
-	"cloud.google.com/go/spanner"
 	"github.com/MixinNetwork/go-number"
-	"google.golang.org/api/iterator"
 )
 
 const (
@@ -15,6 +12,25 @@ const (
 	TransferSourceOrderCancelled = "ORDER_CANCELLED"
 	TransferSourceOrderFilled    = "ORDER_FILLED"
 	TransferSourceOrderInvalid   = "ORDER_INVALID"
+	TransferSourceFeePayout      = "FEE_PAYOUT"
+
+	RefundReasonInvalidMemo        = "INVALID_MEMO"
+	RefundReasonInvalidPair        = "INVALID_PAIR"
+	RefundReasonMarketInactive     = "MARKET_INACTIVE"
+	RefundReasonInvalidType        = "INVALID_TYPE"
+	RefundReasonInvalidPrice       = "INVALID_PRICE"
+	RefundReasonPriceTooHigh       = "PRICE_TOO_HIGH"
+	RefundReasonPriceTick          = "PRICE_TICK"
+	RefundReasonInvalidTrigger     = "INVALID_TRIGGER"
+	RefundReasonInvalidTimeInForce = "INVALID_TIME_IN_FORCE"
+	RefundReasonInvalidExpiry      = "INVALID_EXPIRY"
+	RefundReasonInvalidDisplay     = "INVALID_DISPLAY"
+	RefundReasonAmountTooHigh      = "AMOUNT_TOO_HIGH"
+	RefundReasonAmountLot          = "AMOUNT_LOT"
+	RefundReasonFundsTooHigh       = "FUNDS_TOO_HIGH"
+	RefundReasonFundsTooLow        = "FUNDS_TOO_LOW"
+	RefundReasonInvalidOrder       = "INVALID_ORDER"
+	RefundReasonInvalidAmount      = "INVALID_AMOUNT"
 )
 
 type Transfer struct {
@@ -26,111 +42,26 @@ type Transfer struct {
 	CreatedAt  time.Time `spanner:"created_at"`
 	UserId     string    `spanner:"user_id"`
 	BrokerId   string    `spanner:"broker_id"`
+	Reason     string    `spanner:"reason"`
 }
 
 func CountPendingTransfers(ctx context.Context) (int64, error) {
-	it := Spanner(ctx).Single().Query(ctx, spanner.Statement{
-		SQL: "SELECT COUNT(*) FROM transfers",
-	})
-	defer it.Stop()
-
-	row, err := it.Next()
-	if err == iterator.Done {
-		return 0, nil
-	} else if err != nil {
-		return 0, err
-	}
-	var count int64
-	err = row.Columns(&count)
-	return count, err
+	return CurrentStore(ctx).CountPendingTransfers(ctx)
 }
 
 func ListPendingTransfers(ctx context.Context, broker string, limit int) ([]*Transfer, error) {
-	txn := Spanner(ctx).ReadOnlyTransaction()
-	defer txn.Close()
-
-	it := txn.Query(ctx, spanner.Statement{
-		SQL:    fmt.Sprintf("SELECT transfer_id FROM transfers@{FORCE_INDEX=transfers_by_broker_created} WHERE broker_id=@broker ORDER BY broker_id,created_at LIMIT %d", limit),
-		Params: map[string]interface{}{"broker": broker},
-	})
-	defer it.Stop()
-
-	transferIds := make([]string, 0)
-	for {
-		row, err := it.Next()
-		if err == iterator.Done {
-			break
-		} else if err != nil {
-			return nil, err
-		}
-		var id string
-		err = row.Columns(&id)
-		if err != nil {
-			return nil, err
-		}
-		transferIds = append(transferIds, id)
-	}
-
-	tit := txn.Query(ctx, spanner.Statement{
-		SQL:    "SELECT * FROM transfers WHERE transfer_id IN UNNEST(@transfer_ids)",
-		Params: map[string]interface{}{"transfer_ids": transferIds},
-	})
-	defer tit.Stop()
-
-	transfers := make([]*Transfer, 0)
-	for {
-		row, err := tit.Next()
-		if err == iterator.Done {
-			return transfers, nil
-		} else if err != nil {
-			return transfers, err
-		}
-		var transfer Transfer
-		err = row.ToStruct(&transfer)
-		if err != nil {
-			return transfers, err
-		}
-		transfers = append(transfers, &transfer)
-	}
+	return CurrentStore(ctx).ListPendingTransfers(ctx, broker, limit)
 }
 
 func ExpireTransfers(ctx context.Context, transfers []*Transfer) error {
-	var set []spanner.KeySet
-	for _, t := range transfers {
-		set = append(set, spanner.Key{t.TransferId})
-	}
-	_, err := Spanner(ctx).Apply(ctx, []*spanner.Mutation{
-		spanner.Delete("transfers", spanner.KeySets(set...)),
-	})
-	return err
+	return CurrentStore(ctx).ExpireTransfers(ctx, transfers)
 }
 
 func ReadTransferTrade(ctx context.Context, tradeId, assetId string) (*Trade, error) {
-	it := Spanner(ctx).Single().Query(ctx, spanner.Statement{
-		SQL:    "SELECT * FROM trades WHERE trade_id=@trade_id",
-		Params: map[string]interface{}{"trade_id": tradeId},
-	})
-	defer it.Stop()
-
-	for {
-		row, err := it.Next()
-		if err == iterator.Done {
-			return nil, nil
-		} else if err != nil {
-			return nil, err
-		}
-		var trade Trade
-		err = row.ToStruct(&trade)
-		if err != nil {
-			return nil, err
-		}
-		if trade.FeeAssetId == assetId {
-			return &trade, nil
-		}
-	}
+	return CurrentStore(ctx).ReadTransferTrade(ctx, tradeId, assetId)
 }
 
-func CreateRefundTransfer(ctx context.Context, brokerId, userId, assetId string, amount number.Decimal, trace string) error {
+func CreateRefundTransfer(ctx context.Context, brokerId, userId, assetId string, amount number.Decimal, trace, reason string) error {
 	if amount.Exhausted() {
 		return nil
 	}
@@ -143,11 +74,7 @@ func CreateRefundTransfer(ctx context.Context, brokerId, userId, assetId string,
 		CreatedAt:  time.Now(),
 		UserId:     userId,
 		BrokerId:   brokerId,
+		Reason:     reason,
 	}
-	mutation, err := spanner.InsertStruct("transfers", transfer)
-	if err != nil {
-		return err
-	}
-	_, err = Spanner(ctx).Apply(ctx, []*spanner.Mutation{mutation})
-	return err
+	return CurrentStore(ctx).CreateTransfer(ctx, transfer)
 }
@@ -26,6 +26,9 @@ type R struct{}
 func NewRouter() *httptreemux.TreeMux {
 	router, impl := httptreemux.New(), &R{}
 	router.GET("/brokers", impl.brokers)
+	router.GET("/markets", impl.markets)
+	router.GET("/markets/:id", impl.market)
+	router.GET("/markets/:id/audits", impl.marketAudits)
 	router.GET("/markets/:id/ticker", impl.marketTicker)
 	router.GET("/markets/:id/book", impl.marketBook)
 	router.GET("/markets/:id/trades", impl.marketTrades)
@@ -107,7 +110,82 @@ func (impl *R) tokens(w http.ResponseWriter, r *http.Request, _ map[string]strin
 	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"token": tokenString}})
 }
 
+func (impl *R) markets(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	markets, err := persistence.AllMarkets(r.Context())
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	data := make([]map[string]interface{}, 0)
+	for _, m := range markets {
+		data = append(data, marketView(m))
+	}
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (impl *R) market(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	m, err := persistence.ReadMarket(r.Context(), params["id"])
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if m == nil {
+		render.New().JSON(w, http.StatusNotFound, map[string]interface{}{})
+		return
+	}
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": marketView(m)})
+}
+
+func (impl *R) marketAudits(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	audits, err := persistence.MarketAudits(r.Context(), params["id"], limit)
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	data := make([]map[string]interface{}, 0)
+	for _, a := range audits {
+		data = append(data, map[string]interface{}{
+			"audit_id":        a.AuditId,
+			"previous_status": a.PreviousStatus,
+			"status":          a.Status,
+			"operator":        a.Operator,
+			"reason":          a.Reason,
+			"created_at":      a.CreatedAt,
+		})
+	}
+	render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func marketView(m *persistence.Market) map[string]interface{} {
+	return map[string]interface{}{
+		"market":           m.Id(),
+		"base":             m.BaseAssetId,
+		"quote":            m.QuoteAssetId,
+		"price_precision":  m.PricePrecision,
+		"amount_precision": m.AmountPrecision,
+		"minimum_funds":    m.MinimumFunds,
+		"tick_size":        m.TickSize,
+		"lot_size":         m.LotSize,
+		"status":           m.Status,
+		"updated_at":       m.UpdatedAt,
+	}
+}
+
 func (impl *R) marketTicker(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	event, err := cache.Ticker(r.Context(), params["id"])
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if event != nil {
+		event.Data["sequence"] = event.Sequence
+		event.Data["timestamp"] = event.Timestamp
+		render.New().JSON(w, http.StatusOK, map[string]interface{}{"data": event.Data})
+		return
+	}
+
 	t, err := persistence.LastTrade(r.Context(), params["id"])
 	if err != nil {
 		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
@@ -151,7 +229,26 @@ func (impl *R) marketTicker(w http.ResponseWriter, r *http.Request, params map[s
 }
 
 func (impl *R) marketBook(w http.ResponseWriter, r *http.Request, params map[string]string) {
-	book, err := cache.Book(r.Context(), params["id"], 0)
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("depth"))
+	typ, depth, err := cache.ParseDepth(query.Get("level"), limit, query.Get("tick"))
+	if err != nil {
+		render.New().JSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	book, err := cache.LastEvent(r.Context(), params["id"]+"-"+typ)
+	if err == nil && book == nil && typ == cache.EventTypeBookL2 {
+		book, err = cache.Book(r.Context(), params["id"], 0)
+	}
+	if err != nil {
+		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if book == nil {
+		render.New().JSON(w, http.StatusNotFound, map[string]interface{}{})
+		return
+	}
+	book, err = cache.BookDepth(book, depth)
 	if err != nil {
 		render.New().JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
 	} else {
@@ -219,6 +316,10 @@ func (impl *R) orders(w http.ResponseWriter, r *http.Request, params map[string]
 			"filled_amount":    o.FilledAmount,
 			"remaining_funds":  o.RemainingFunds,
 			"filled_funds":     o.FilledFunds,
+			"trigger_price":    o.TriggerPrice,
+			"time_in_force":    o.TimeInForce,
+			"expired_at":       o.ExpiredAt,
+			"display_amount":   o.DisplayAmount,
 			"state":            o.State,
 			"created_at":       o.CreatedAt,
 		})
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MixinNetwork/bot-api-go-client"
+	"github.com/MixinNetwork/go-number"
+	"github.com/MixinNetwork/ocean.one/persistence"
+	"github.com/satori/go.uuid"
+)
+
+type MixinNetworkSimulator struct {
+	sync.Mutex
+	clock     time.Time
+	balances  map[string]number.Decimal
+	snapshots []*Snapshot
+	traces    map[string]*Snapshot
+	messages  chan bot.MessageView
+}
+
+func NewMixinNetworkSimulator() *MixinNetworkSimulator {
+	return &MixinNetworkSimulator{
+		balances: make(map[string]number.Decimal),
+		traces:   make(map[string]*Snapshot),
+		messages: make(chan bot.MessageView, 1024),
+	}
+}
+
+func (sim *MixinNetworkSimulator) Deposit(userId, assetId string, amount number.Decimal) {
+	sim.Lock()
+	defer sim.Unlock()
+
+	sim.balances[userId+assetId] = sim.balance(userId, assetId).Add(amount)
+}
+
+func (sim *MixinNetworkSimulator) Balance(userId, assetId string) number.Decimal {
+	sim.Lock()
+	defer sim.Unlock()
+
+	return sim.balance(userId, assetId)
+}
+
+func (sim *MixinNetworkSimulator) Transfer(senderId, recipientId, assetId string, amount number.Decimal, traceId, memo string) (*Snapshot, error) {
+	sim.Lock()
+	defer sim.Unlock()
+
+	if s := sim.traces[traceId]; s != nil {
+		if s.UserId != recipientId || s.OpponentId != senderId || s.Asset.AssetId != assetId || number.FromString(s.Amount).Cmp(amount) != 0 || s.Data != memo {
+			return nil, fmt.Errorf("trace %s conflicts with an earlier transfer", traceId)
+		}
+		return s, nil
+	}
+	if amount.Cmp(number.Zero()) <= 0 {
+		return nil, fmt.Errorf("invalid amount %s", amount.Persist())
+	}
+	balance := sim.balance(senderId, assetId)
+	if balance.Cmp(amount) < 0 {
+		return nil, errors.New("insufficient balance")
+	}
+	sim.balances[senderId+assetId] = balance.Sub(amount)
+	sim.balances[recipientId+assetId] = sim.balance(recipientId, assetId).Add(amount)
+
+	sim.snapshot(senderId, recipientId, assetId, "-"+amount.Persist(), traceId, memo)
+	s := sim.snapshot(recipientId, senderId, assetId, amount.Persist(), traceId, memo)
+	sim.traces[traceId] = s
+	return s, nil
+}
+
+func (sim *MixinNetworkSimulator) SendMessage(msg bot.MessageView) {
+	sim.messages <- msg
+}
+
+func (sim *MixinNetworkSimulator) ReadSnapshots(ctx context.Context, checkpoint time.Time, limit int) ([]*Snapshot, error) {
+	sim.Lock()
+	defer sim.Unlock()
+
+	i := sort.Search(len(sim.snapshots), func(i int) bool { return !sim.snapshots[i].CreatedAt.Before(checkpoint) })
+	snapshots := make([]*Snapshot, 0)
+	for ; i < len(sim.snapshots) && len(snapshots) < limit; i++ {
+		snapshots = append(snapshots, sim.snapshots[i])
+	}
+	return snapshots, nil
+}
+
+func (sim *MixinNetworkSimulator) CreateTransfer(ctx context.Context, broker *persistence.Broker, in *bot.TransferInput) error {
+	_, err := sim.Transfer(broker.BrokerId, in.RecipientId, in.AssetId, in.Amount, in.TraceId, in.Memo)
+	return err
+}
+
+func (sim *MixinNetworkSimulator) LoopMessages(ctx context.Context, listener bot.BlazeListener) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-sim.messages:
+			err := listener.OnMessage(ctx, msg, msg.UserId)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (sim *MixinNetworkSimulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		UserId      string `json:"user_id"`
+		RecipientId string `json:"recipient_id"`
+		AssetId     string `json:"asset_id"`
+		Amount      string `json:"amount"`
+		TraceId     string `json:"trace_id"`
+		Memo        string `json:"memo"`
+	}
+	if r.Method == "POST" {
+		err := json.NewDecoder(r.Body).Decode(&params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var data interface{}
+	switch r.Method + " " + r.URL.Path {
+	case "POST /deposits":
+		sim.Deposit(params.UserId, params.AssetId, number.FromString(params.Amount))
+		data = map[string]string{"balance": sim.Balance(params.UserId, params.AssetId).Persist()}
+	case "POST /transfers":
+		if params.TraceId == "" {
+			id, _ := uuid.NewV4()
+			params.TraceId = id.String()
+		}
+		s, err := sim.Transfer(params.UserId, params.RecipientId, params.AssetId, number.FromString(params.Amount), params.TraceId, params.Memo)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data = s
+	case "GET /balances":
+		query := r.URL.Query()
+		data = map[string]string{"balance": sim.Balance(query.Get("user_id"), query.Get("asset_id")).Persist()}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func (sim *MixinNetworkSimulator) balance(userId, assetId string) number.Decimal {
+	if b, found := sim.balances[userId+assetId]; found {
+		return b
+	}
+	return number.Zero()
+}
+
+func (sim *MixinNetworkSimulator) snapshot(userId, opponentId, assetId, amount, traceId, memo string) *Snapshot {
+	now := time.Now().UTC()
+	if !now.After(sim.clock) {
+		now = sim.clock.Add(time.Microsecond)
+	}
+	sim.clock = now
+
+	id, _ := uuid.NewV4()
+	s := &Snapshot{
+		SnapshotId: id.String(),
+		Amount:     amount,
+		CreatedAt:  now,
+		TraceId:    traceId,
+		UserId:     userId,
+		OpponentId: opponentId,
+		Data:       memo,
+	}
+	s.Asset.AssetId = assetId
+	sim.snapshots = append(sim.snapshots, s)
+	return s
+}